@@ -0,0 +1,123 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	errTokenNotJWT      = errors.New("the access token is not a JWT")
+	errMissingClaim     = errors.New("the access token lacks a required claim")
+	errAudienceMismatch = errors.New("the audience of the access token doesn't match the request host")
+)
+
+// jwtClaims decodes the claims of a JWT into claims. The signature isn't verified, that's up to the server
+// receiving the token.
+func jwtClaims(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errTokenNotJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTokenNotJWT, err)
+	}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("%w: %v", errTokenNotJWT, err)
+	}
+	return nil
+}
+
+// jwtAudiences returns the aud claim of a JWT, which is either a single string or an array of strings.
+// See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.3
+func jwtAudiences(token string) ([]string, error) {
+	var claims struct {
+		Audience json.RawMessage `json:"aud"`
+	}
+	if err := jwtClaims(token, &claims); err != nil {
+		return nil, err
+	}
+
+	var audience string
+	if json.Unmarshal(claims.Audience, &audience) == nil && audience != "" {
+		return []string{audience}, nil
+	}
+	var audiences []string
+	if json.Unmarshal(claims.Audience, &audiences) == nil && len(audiences) > 0 {
+		return audiences, nil
+	}
+	return nil, fmt.Errorf("%w: aud", errMissingClaim)
+}
+
+// audienceMatchesHost tells whether an audience, either a host or a URL, designates the given request host.
+// The port is only compared when the audience has one.
+func audienceMatchesHost(audience string, host string) bool {
+	if u, err := url.Parse(audience); err == nil && u.Host != "" {
+		audience = u.Host
+	}
+	if _, _, err := net.SplitHostPort(audience); err == nil {
+		return strings.EqualFold(audience, host)
+	}
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	return strings.EqualFold(strings.Trim(audience, "[]"), hostname)
+}
+
+// audienceRoundTripper fails the requests sent to a host the token isn't issued for, to catch tokens
+// requested for the audience of another endpoint.
+type audienceRoundTripper struct {
+	source oauth2.TokenSource
+	base   http.RoundTripper
+}
+
+func (a *audienceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := a.checkAudience(req)
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return a.base.RoundTrip(req)
+}
+
+func (a *audienceRoundTripper) checkAudience(req *http.Request) error {
+	token, err := a.source.Token()
+	if err != nil {
+		return err
+	}
+	audiences, err := jwtAudiences(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	for _, audience := range audiences {
+		if audienceMatchesHost(audience, req.URL.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: audience %q, host %q", errAudienceMismatch, audiences, req.URL.Host)
+}
@@ -0,0 +1,135 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestJWT returns an unsigned JWT with the given JSON claims.
+func newTestJWT(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + "."
+}
+
+func TestVerifyAudienceAgainstHost(t *testing.T) {
+	var backendRequests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backendRequests, 1)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{
+			name:  "url_audience",
+			token: newTestJWT(fmt.Sprintf(`{"aud":%q}`, backend.URL)),
+		},
+		{
+			name:  "host_audience",
+			token: newTestJWT(`{"aud":"127.0.0.1"}`),
+		},
+		{
+			name:  "one_of_several_audiences",
+			token: newTestJWT(`{"aud":["https://api.example.com","127.0.0.1"]}`),
+		},
+		{
+			name:        "mismatching_audience",
+			token:       newTestJWT(`{"aud":"https://api.example.com"}`),
+			expectedErr: errAudienceMismatch,
+		},
+		{
+			name:        "mismatching_port",
+			token:       newTestJWT(`{"aud":"127.0.0.1:1"}`),
+			expectedErr: errAudienceMismatch,
+		},
+		{
+			name:        "no_audience",
+			token:       newTestJWT(`{"sub":"testclientid"}`),
+			expectedErr: errMissingClaim,
+		},
+		{
+			name:        "opaque_token",
+			token:       "opaque-token",
+			expectedErr: errTokenNotJWT,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":3600}`, test.token)
+			}))
+			defer tokenServer.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                  "testclientid",
+				ClientSecret:              "testsecret",
+				TokenURL:                  tokenServer.URL,
+				VerifyAudienceAgainstHost: true,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+			require.NoError(t, err)
+
+			atomic.StoreInt32(&backendRequests, 0)
+			req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+			require.NoError(t, err)
+			resp, err := roundTripper.RoundTrip(req)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				assert.Equal(t, int32(0), atomic.LoadInt32(&backendRequests))
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, int32(1), atomic.LoadInt32(&backendRequests))
+		})
+	}
+}
+
+func TestAudienceMatchesHost(t *testing.T) {
+	tests := []struct {
+		audience string
+		host     string
+		matches  bool
+	}{
+		{audience: "api.example.com", host: "api.example.com", matches: true},
+		{audience: "api.example.com", host: "api.example.com:8443", matches: true},
+		{audience: "API.example.com", host: "api.example.com", matches: true},
+		{audience: "https://api.example.com/v1", host: "api.example.com", matches: true},
+		{audience: "https://api.example.com:8443", host: "api.example.com:8443", matches: true},
+		{audience: "https://api.example.com:8443", host: "api.example.com:443", matches: false},
+		{audience: "https://[::1]", host: "[::1]:4318", matches: true},
+		{audience: "billing.example.com", host: "api.example.com", matches: false},
+		{audience: "example.com", host: "api.example.com", matches: false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matches, audienceMatchesHost(test.audience, test.host), "%s against %s", test.audience, test.host)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+)
+
+// auditEntry describes a token request. It never holds the token nor the client credentials.
+type auditEntry struct {
+	Timestamp  time.Time  `json:"timestamp"`
+	Extension  string     `json:"extension"`
+	Profile    string     `json:"profile,omitempty"`
+	TokenURL   string     `json:"token_url"`
+	Outcome    string     `json:"outcome"`
+	Expiry     *time.Time `json:"expiry,omitempty"`
+	StatusCode int        `json:"status_code,omitempty"`
+	ErrorCode  string     `json:"error_code,omitempty"`
+	Temporary  bool       `json:"temporary,omitempty"`
+}
+
+// auditLog appends audit entries to a file as JSON lines. The file is opened on the first entry, in append mode,
+// so that it may be rotated by tools truncating it, and opened again when the path no longer refers to it, so that it
+// may be rotated by tools renaming it. Entries written once it is closed are dropped.
+type auditLog struct {
+	path   string
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+func newAuditLog(path string, logger *zap.Logger) *auditLog {
+	return &auditLog{path: path, logger: logger}
+}
+
+func (a *auditLog) write(entry auditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		a.logger.Warn("Failed to write audit log entry", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	if a.file != nil && a.rotated() {
+		_ = a.file.Close()
+		a.file = nil
+	}
+	if a.file == nil {
+		if a.file, err = os.OpenFile(filepath.Clean(a.path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+			a.logger.Warn("Failed to open audit log file", zap.String("path", a.path), zap.Error(err))
+			return
+		}
+	}
+	// a single write per entry, so that entries are never interleaved
+	if _, err = a.file.Write(line); err != nil {
+		a.logger.Warn("Failed to write audit log entry", zap.String("path", a.path), zap.Error(err))
+	}
+}
+
+// rotated reports whether the path of the audit log no longer refers to the open file, e.g. because it was renamed.
+func (a *auditLog) rotated() bool {
+	current, err := os.Stat(filepath.Clean(a.path))
+	if err != nil {
+		return true
+	}
+	open, err := a.file.Stat()
+	if err != nil {
+		return true
+	}
+	return !os.SameFile(current, open)
+}
+
+func (a *auditLog) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// redactURL drops the user information of a URL, which may carry credentials.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// auditTokenSource writes an audit entry for every token request.
+type auditTokenSource struct {
+	ts       oauth2.TokenSource
+	log      *auditLog
+	id       config.ComponentID
+	profile  string
+	tokenURL string
+}
+
+func (s *auditTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	entry := auditEntry{
+		Timestamp: time.Now().UTC(),
+		Extension: s.id.String(),
+		Profile:   s.profile,
+		TokenURL:  redactURL(s.tokenURL),
+		Outcome:   auditOutcomeSuccess,
+	}
+	if err != nil {
+		tokenErr := &FailedToGetSecurityTokenError{id: s.id, err: err}
+		entry.Outcome = auditOutcomeFailure
+		entry.ErrorCode = tokenErr.ErrorCode()
+		entry.Temporary = tokenErr.Temporary()
+		var rErr *oauth2.RetrieveError
+		if errors.As(err, &rErr) && rErr.Response != nil {
+			entry.StatusCode = rErr.Response.StatusCode
+		}
+	} else if !token.Expiry.IsZero() {
+		entry.Expiry = &token.Expiry
+	}
+	s.log.write(entry)
+	return token, err
+}
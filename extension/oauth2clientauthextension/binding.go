@@ -0,0 +1,68 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+var errCertificateBindingMismatch = errors.New("the access token is not bound to the client certificate")
+
+// certificateThumbprint returns the x5t#S256 thumbprint of the leaf certificate of the first certificate chain
+// of tlsCfg, the empty string when there is none.
+// See https://datatracker.ietf.org/doc/html/rfc8705#section-3.1
+func certificateThumbprint(tlsCfg *tls.Config) string {
+	if tlsCfg == nil || len(tlsCfg.Certificates) == 0 || len(tlsCfg.Certificates[0].Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(tlsCfg.Certificates[0].Certificate[0])
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// certificateBoundTokenSource rejects the tokens whose confirmation claim doesn't name the client certificate,
+// catching certificate-bound tokens issued for another certificate.
+type certificateBoundTokenSource struct {
+	ts         oauth2.TokenSource
+	thumbprint string
+}
+
+func (s *certificateBoundTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	var claims struct {
+		Confirmation struct {
+			Thumbprint string `json:"x5t#S256"`
+		} `json:"cnf"`
+	}
+	if err = jwtClaims(token.AccessToken, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Confirmation.Thumbprint == "" {
+		return nil, fmt.Errorf("%w: cnf.x5t#S256", errMissingClaim)
+	}
+	if claims.Confirmation.Thumbprint != s.thumbprint {
+		return nil, fmt.Errorf("%w: token thumbprint %q, certificate thumbprint %q",
+			errCertificateBindingMismatch, claims.Confirmation.Thumbprint, s.thumbprint)
+	}
+	return token, nil
+}
@@ -0,0 +1,98 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+)
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("testdata/test-cert.pem", "testdata/test-key.pem")
+	require.NoError(t, err)
+	sum := sha256.Sum256(cert.Certificate[0])
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{
+			name:  "matching_thumbprint",
+			token: newTestJWT(fmt.Sprintf(`{"cnf":{"x5t#S256":%q}}`, thumbprint)),
+		},
+		{
+			name:        "mismatching_thumbprint",
+			token:       newTestJWT(`{"cnf":{"x5t#S256":"bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"}}`),
+			expectedErr: errCertificateBindingMismatch,
+		},
+		{
+			name:        "unbound_token",
+			token:       newTestJWT(`{"sub":"testclientid"}`),
+			expectedErr: errMissingClaim,
+		},
+		{
+			name:        "opaque_token",
+			token:       "opaque-token",
+			expectedErr: errTokenNotJWT,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":3600}`, test.token)
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                 "testclientid",
+				ClientSecret:             "testsecret",
+				TokenURL:                 server.URL,
+				VerifyCertificateBinding: true,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{
+							CertFile: "testdata/test-cert.pem",
+							KeyFile:  "testdata/test-key.pem",
+						},
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			assert.Equal(t, thumbprint, oauth2Authenticator.certThumbprint)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.token, token.AccessToken)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// bootstrap seeds the token of the extension configuration with the access token given by the bootstrap environment
+// variable, which is handed out until it expires and then refreshed as usual. Profiles aren't seeded.
+// The expiry of the bootstrap token is set on start and kept by reloads, which don't seed it again once expired.
+func (o *ClientCredentialsAuthenticator) bootstrap() {
+	o.mu.Lock()
+	if o.bootstrapUntil.IsZero() {
+		o.bootstrapUntil = time.Now().Add(o.bootstrapExpiry)
+	}
+	expiry := o.bootstrapUntil
+	o.mu.Unlock()
+	if !time.Now().Before(expiry) {
+		return
+	}
+
+	accessToken := strings.TrimSpace(os.Getenv(o.bootstrapEnv))
+	if accessToken == "" {
+		o.logger.Warn("Bootstrap access token environment variable is empty, requesting a token instead",
+			zap.String("env", o.bootstrapEnv))
+		return
+	}
+	token := &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}
+	o.mu.Lock()
+	o.warmTokens = map[string]*oauth2.Token{"": token}
+	o.mu.Unlock()
+}
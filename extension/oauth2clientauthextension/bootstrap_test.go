@@ -0,0 +1,113 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestBootstrapAccessToken(t *testing.T) {
+	t.Setenv("TEST_BOOTSTRAP_ACCESS_TOKEN", "bootstrap-token\n")
+	server, requests := newFlakyTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:                "testclientid",
+		ClientSecret:            "testsecret",
+		TokenURL:                server.URL,
+		BootstrapAccessTokenEnv: "TEST_BOOTSTRAP_ACCESS_TOKEN",
+		BootstrapExpiry:         time.Hour,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "bootstrap-token", token.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, 5*time.Second)
+	assert.Equal(t, 0, *requests)
+
+	// once the bootstrap token expires, tokens are requested as usual
+	token.Expiry = time.Now().Add(-time.Minute)
+	token, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestBootstrapAccessTokenOnReload(t *testing.T) {
+	t.Setenv("TEST_BOOTSTRAP_ACCESS_TOKEN", "bootstrap-token")
+	server, requests := newFlakyTokenServer(t)
+
+	cfg := &Config{
+		ClientID:                "testclientid",
+		ClientSecret:            "testsecret",
+		TokenURL:                server.URL,
+		BootstrapAccessTokenEnv: "TEST_BOOTSTRAP_ACCESS_TOKEN",
+		BootstrapExpiry:         time.Hour,
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	expiry := token.Expiry
+
+	// the bootstrap token keeps the expiry set on start
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, oauth2Authenticator.Reload(cfg))
+	token, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "bootstrap-token", token.AccessToken)
+	assert.Equal(t, expiry, token.Expiry)
+
+	// once expired, the bootstrap token isn't handed out again by a reload
+	oauth2Authenticator.bootstrapUntil = time.Now().Add(-time.Minute)
+	require.NoError(t, oauth2Authenticator.Reload(cfg))
+	token, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestBootstrapAccessTokenEmptyEnv(t *testing.T) {
+	t.Setenv("TEST_BOOTSTRAP_ACCESS_TOKEN", "")
+	server, requests := newFlakyTokenServer(t)
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:                "testclientid",
+		ClientSecret:            "testsecret",
+		TokenURL:                server.URL,
+		BootstrapAccessTokenEnv: "TEST_BOOTSTRAP_ACCESS_TOKEN",
+		BootstrapExpiry:         time.Hour,
+	}, zap.New(core))
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+	assert.Equal(t, 1, logs.Len())
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, 1, *requests)
+}
@@ -0,0 +1,96 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Modes of the extension, Mode of Config.
+const (
+	modeOAuth2 = "oauth2"
+	modeBroker = "broker"
+)
+
+// defaultBrokerTokenTTL is how long the tokens of the broker are cached when BrokerTokenTTL isn't set.
+const defaultBrokerTokenTTL = time.Minute
+
+var errEmptyBrokerToken = errors.New("the token broker returned an empty token")
+
+// brokerTokenSource gets tokens from a token broker, e.g. an identity sidecar holding the tokens of the collector,
+// with a GET request to its endpoint. The broker answers either a JSON token response, like a token endpoint, or the
+// bare token as text. Tokens are cached for ttl at most, the broker being in charge of their refresh.
+type brokerTokenSource struct {
+	ctx context.Context
+	url string
+	ttl time.Duration
+	now func() time.Time
+}
+
+func (s *brokerTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	resp, err := contextClient(s.ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &oauth2.RetrieveError{Response: resp, Body: body}
+	}
+
+	token := &oauth2.Token{TokenType: "Bearer"}
+	var expiresIn int64
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var response struct {
+			AccessToken string `json:"access_token"`
+			TokenType   string `json:"token_type"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return nil, err
+		}
+		token.AccessToken, expiresIn = response.AccessToken, response.ExpiresIn
+		if response.TokenType != "" {
+			token.TokenType = response.TokenType
+		}
+	} else {
+		token.AccessToken = strings.TrimSpace(string(body))
+	}
+	if token.AccessToken == "" {
+		return nil, errEmptyBrokerToken
+	}
+
+	ttl := s.ttl
+	if lifetime := time.Duration(expiresIn) * time.Second; lifetime > 0 && lifetime < ttl {
+		ttl = lifetime
+	}
+	token.Expiry = s.now().Add(ttl)
+	return token, nil
+}
@@ -0,0 +1,154 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBrokerMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		ttl            time.Duration
+		expectedToken  string
+		expectedExpiry time.Duration
+	}{
+		{
+			name:           "json_response",
+			contentType:    "application/json",
+			body:           `{"access_token":"broker-token","token_type":"bearer","expires_in":3600}`,
+			expectedToken:  "broker-token",
+			expectedExpiry: time.Minute,
+		},
+		{
+			name:           "json_response_expiring_before_ttl",
+			contentType:    "application/json",
+			body:           `{"access_token":"broker-token","expires_in":30}`,
+			expectedToken:  "broker-token",
+			expectedExpiry: 30 * time.Second,
+		},
+		{
+			name:           "bare_token",
+			contentType:    "text/plain",
+			body:           "broker-token\n",
+			ttl:            20 * time.Second,
+			expectedToken:  "broker-token",
+			expectedExpiry: 20 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				assert.Equal(t, http.MethodGet, r.Method)
+				assert.Equal(t, "/token", r.URL.Path)
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer broker.Close()
+
+			cfg := &Config{
+				Mode:           modeBroker,
+				BrokerURL:      broker.URL + "/token",
+				BrokerTokenTTL: test.ttl,
+			}
+			require.NoError(t, cfg.Validate())
+			oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+			require.NoError(t, err)
+
+			var authorizations []string
+			rt, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				authorizations = append(authorizations, req.Header.Get("Authorization"))
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}))
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+				require.NoError(t, err)
+				_, err = rt.RoundTrip(req)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, []string{"Bearer " + test.expectedToken, "Bearer " + test.expectedToken}, authorizations)
+			// the token is cached
+			assert.Equal(t, 1, requests)
+
+			start := time.Now()
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.WithinDuration(t, start.Add(test.expectedExpiry), token.Expiry, 5*time.Second)
+		})
+	}
+}
+
+func TestBrokerModeFailure(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		expectedErr       error
+		expectedTemporary bool
+	}{
+		{
+			name:              "unavailable",
+			status:            http.StatusServiceUnavailable,
+			expectedTemporary: true,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+		},
+		{
+			name:        "empty_token",
+			status:      http.StatusOK,
+			expectedErr: errEmptyBrokerToken,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer broker.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				Mode:      modeBroker,
+				BrokerURL: broker.URL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			require.Error(t, err)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+			}
+			tokenErr, ok := err.(*FailedToGetSecurityTokenError)
+			require.True(t, ok)
+			assert.Equal(t, test.expectedTemporary, tokenErr.Temporary())
+		})
+	}
+}
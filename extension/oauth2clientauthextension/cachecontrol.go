@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+type responseHeadersKey struct{}
+
+// responseHeaders holds the headers of the last token response received by a token source.
+type responseHeaders struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+func (h *responseHeaders) set(header http.Header) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header = header
+}
+
+func (h *responseHeaders) take() http.Header {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	header := h.header
+	h.header = nil
+	return header
+}
+
+// responseHeadersRoundTripper stores the headers of token responses in the responseHeaders found in the
+// request context, as golang.org/x/oauth2 doesn't expose them.
+type responseHeadersRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (r *responseHeadersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err == nil {
+		if headers, ok := req.Context().Value(responseHeadersKey{}).(*responseHeaders); ok {
+			headers.set(resp.Header.Clone())
+		}
+	}
+	return resp, err
+}
+
+// cacheHeadersTokenSource shortens the lifetime of the tokens to the freshness lifetime given by the
+// Cache-Control max-age directive or the Expires header of the token response.
+//
+// Cache-Control: no-store is ignored, RFC 6749 requires it on every token response to prevent intermediaries
+// from storing the response, it says nothing about the lifetime of the token.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type cacheHeadersTokenSource struct {
+	ts      oauth2.TokenSource
+	headers *responseHeaders
+	logger  *zap.Logger
+}
+
+func (s *cacheHeadersTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	header := s.headers.take()
+	if err != nil || header == nil {
+		return token, err
+	}
+	expiry, ok := expiryFromCacheHeaders(header, time.Now())
+	if ok && (token.Expiry.IsZero() || expiry.Before(token.Expiry)) {
+		s.logger.Debug("Shortening token lifetime to the token response freshness lifetime",
+			zap.Time("token_expiry", token.Expiry), zap.Time("expiry", expiry))
+		token.Expiry = expiry
+	}
+	return token, nil
+}
+
+// expiryFromCacheHeaders returns the end of the freshness lifetime of a response, max-age taking precedence
+// over Expires. See https://datatracker.ietf.org/doc/html/rfc7234#section-4.2.1
+func expiryFromCacheHeaders(header http.Header, now time.Time) (time.Time, bool) {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := strings.TrimSpace(directive), ""
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name, arg = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+			}
+			if !strings.EqualFold(name, "max-age") {
+				continue
+			}
+			if seconds, err := strconv.ParseInt(arg, 10, 64); err == nil && seconds >= 0 {
+				return now.Add(time.Duration(seconds) * time.Second), true
+			}
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t, true
+		}
+		// invalid dates, like "0", represent a time in the past
+		return now, true
+	}
+	return time.Time{}, false
+}
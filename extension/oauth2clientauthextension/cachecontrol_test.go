@@ -0,0 +1,142 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestExpiryFromCacheHeaders(t *testing.T) {
+	now := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		header         http.Header
+		expectedExpiry time.Time
+		expectedOK     bool
+	}{
+		{
+			name:   "no_cache_headers",
+			header: http.Header{},
+		},
+		{
+			name:   "no_store",
+			header: http.Header{"Cache-Control": []string{"no-store"}, "Pragma": []string{"no-cache"}},
+		},
+		{
+			name:           "max_age",
+			header:         http.Header{"Cache-Control": []string{"no-store, max-age=60"}},
+			expectedExpiry: now.Add(time.Minute),
+			expectedOK:     true,
+		},
+		{
+			name:           "quoted_max_age",
+			header:         http.Header{"Cache-Control": []string{`private, MAX-AGE="120"`}},
+			expectedExpiry: now.Add(2 * time.Minute),
+			expectedOK:     true,
+		},
+		{
+			name: "max_age_takes_precedence_over_expires",
+			header: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Expires":       []string{"Wed, 01 Dec 2021 10:30:00 GMT"},
+			},
+			expectedExpiry: now.Add(time.Minute),
+			expectedOK:     true,
+		},
+		{
+			name:           "expires",
+			header:         http.Header{"Expires": []string{"Wed, 01 Dec 2021 10:30:00 GMT"}},
+			expectedExpiry: now.Add(30 * time.Minute),
+			expectedOK:     true,
+		},
+		{
+			name:           "invalid_expires_is_in_the_past",
+			header:         http.Header{"Expires": []string{"0"}},
+			expectedExpiry: now,
+			expectedOK:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expiry, ok := expiryFromCacheHeaders(test.header, now)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.True(t, test.expectedExpiry.Equal(expiry), "expected %v, got %v", test.expectedExpiry, expiry)
+		})
+	}
+}
+
+func TestHonorHTTPCacheHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		honor          bool
+		cacheControl   string
+		expectedExpiry time.Duration
+	}{
+		{
+			name:           "max_age_shortens_lifetime",
+			honor:          true,
+			cacheControl:   "no-store, max-age=60",
+			expectedExpiry: time.Minute,
+		},
+		{
+			name:           "max_age_does_not_extend_lifetime",
+			honor:          true,
+			cacheControl:   "max-age=7200",
+			expectedExpiry: time.Hour,
+		},
+		{
+			name:           "no_store_keeps_lifetime",
+			honor:          true,
+			cacheControl:   "no-store",
+			expectedExpiry: time.Hour,
+		},
+		{
+			name:           "headers_ignored_by_default",
+			cacheControl:   "max-age=60",
+			expectedExpiry: time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Cache-Control", test.cacheControl)
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:              "testclientid",
+				ClientSecret:          "testsecret",
+				TokenURL:              server.URL,
+				HonorHTTPCacheHeaders: test.honor,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(test.expectedExpiry), token.Expiry, 10*time.Second)
+		})
+	}
+}
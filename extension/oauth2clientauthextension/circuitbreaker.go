@@ -0,0 +1,119 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var errCircuitOpen = errors.New("token endpoint circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops sending token requests after consecutive failures within a window, the count starting over
+// once the window has passed since the first of them. Once open, it fails fast with the last error for the cooldown
+// period, then lets a single trial request through: the circuit closes again if it succeeds and reopens otherwise.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu           sync.Mutex
+	state        circuitState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	lastErr      error
+}
+
+func newCircuitBreaker(settings CircuitBreakerSettings) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: settings.FailureThreshold,
+		window:    settings.Window,
+		cooldown:  settings.Cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns an error when the request must not be sent.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%w: %v", errCircuitOpen, b.lastErr)
+		}
+		b.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// the trial request is in flight
+		return fmt.Errorf("%w: %v", errCircuitOpen, b.lastErr)
+	}
+	return nil
+}
+
+// record updates the state of the circuit with the outcome of a request.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.state = circuitClosed
+		b.failures = 0
+		b.lastErr = nil
+		return
+	}
+	b.lastErr = err
+	now := b.now()
+	if b.state == circuitClosed && b.window > 0 && b.failures > 0 && now.Sub(b.firstFailure) >= b.window {
+		// the previous failures are too old to count
+		b.failures = 0
+	}
+	if b.failures == 0 {
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.state = circuitOpen
+		b.openedAt = now
+	}
+}
+
+// circuitBreakerTokenSource guards token requests with a circuitBreaker.
+type circuitBreakerTokenSource struct {
+	ts      oauth2.TokenSource
+	breaker *circuitBreaker
+}
+
+func (s *circuitBreakerTokenSource) Token() (*oauth2.Token, error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	token, err := s.ts.Token()
+	s.breaker.record(err)
+	return token, err
+}
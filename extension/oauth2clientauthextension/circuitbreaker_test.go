@@ -0,0 +1,151 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 2, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	fetchErr := errors.New("token endpoint unavailable")
+	calls := 0
+	var result error
+	ts := &circuitBreakerTokenSource{
+		breaker: breaker,
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			if result != nil {
+				return nil, result
+			}
+			return &oauth2.Token{AccessToken: "test-token"}, nil
+		}),
+	}
+
+	// closed: failures below the threshold are passed through
+	result = fetchErr
+	_, err := ts.Token()
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, circuitClosed, breaker.state)
+
+	// open: the threshold is reached, requests fail fast with the last error
+	_, err = ts.Token()
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, circuitOpen, breaker.state)
+	_, err = ts.Token()
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Contains(t, err.Error(), fetchErr.Error())
+	assert.Equal(t, 2, calls)
+
+	// half-open: a failed trial request reopens the circuit for another cooldown
+	now = now.Add(time.Minute)
+	_, err = ts.Token()
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, circuitOpen, breaker.state)
+	now = now.Add(time.Minute - time.Second)
+	_, err = ts.Token()
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, 3, calls)
+
+	// closed: a successful trial request closes the circuit
+	now = now.Add(time.Second)
+	result = nil
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, circuitClosed, breaker.state)
+
+	// the failure count starts over
+	result = fetchErr
+	_, err = ts.Token()
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, circuitClosed, breaker.state)
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 2, Window: time.Minute, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+	fetchErr := errors.New("token endpoint unavailable")
+
+	// failures further apart than the window don't open the circuit
+	breaker.record(fetchErr)
+	now = now.Add(time.Minute)
+	breaker.record(fetchErr)
+	assert.Equal(t, circuitClosed, breaker.state)
+	assert.NoError(t, breaker.allow())
+
+	// the count started over with the previous failure
+	now = now.Add(30 * time.Second)
+	breaker.record(fetchErr)
+	assert.Equal(t, circuitOpen, breaker.state)
+	assert.ErrorIs(t, breaker.allow(), errCircuitOpen)
+}
+
+func TestCircuitBreakerHalfOpenAllowsSingleTrial(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(CircuitBreakerSettings{FailureThreshold: 1, Cooldown: time.Minute})
+	breaker.now = func() time.Time { return now }
+	breaker.record(errors.New("token endpoint unavailable"))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, breaker.allow())
+	assert.ErrorIs(t, breaker.allow(), errCircuitOpen)
+}
+
+func TestCircuitBreakerWithRetries(t *testing.T) {
+	server, requests := newFlakyTokenServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		CircuitBreaker: CircuitBreakerSettings{
+			FailureThreshold: 2,
+			Cooldown:         time.Hour,
+		},
+		Retry: RetrySettings{
+			MaxRetries:      1,
+			InitialInterval: time.Millisecond,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	// a retried token request counts as a single failure
+	_, err = fetchToken(oauth2Authenticator)
+	assert.Error(t, err)
+	assert.Equal(t, 2, *requests)
+	_, err = fetchToken(oauth2Authenticator)
+	assert.Error(t, err)
+	assert.Equal(t, 4, *requests)
+
+	_, err = fetchToken(oauth2Authenticator)
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, 4, *requests)
+}
@@ -0,0 +1,95 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var errConcurrencyLimit = errors.New("max_concurrent_requests reached")
+
+const (
+	onLimitQueue = "queue"
+	onLimitFail  = "fail"
+)
+
+// limitRoundTripper bounds the number of authenticated requests in flight, a request being in flight until
+// its response body is closed. Requests beyond the limit wait for a slot, or fail right away when failFast is set.
+type limitRoundTripper struct {
+	base     http.RoundTripper
+	slots    chan struct{}
+	failFast bool
+}
+
+func newLimitRoundTripper(base http.RoundTripper, limit int, onLimit string) *limitRoundTripper {
+	return &limitRoundTripper{
+		base:     base,
+		slots:    make(chan struct{}, limit),
+		failFast: onLimit == onLimitFail,
+	}
+}
+
+func (l *limitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := l.acquire(req); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := l.base.RoundTrip(req)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: l.release}
+	return resp, nil
+}
+
+func (l *limitRoundTripper) acquire(req *http.Request) error {
+	if l.failFast {
+		select {
+		case l.slots <- struct{}{}:
+			return nil
+		default:
+			return errConcurrencyLimit
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func (l *limitRoundTripper) release() {
+	<-l.slots
+}
+
+// releasingBody calls release once closed, e.g. to release the slot of its request.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,120 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newLimitedRoundTripper returns the RoundTripper of an extension allowing a single request in flight, sending
+// requests to a backend which signals the requests it receives on received and answers them once unblocked.
+func newLimitedRoundTripper(t *testing.T, onLimit string) (rt http.RoundTripper, backendURL string, received <-chan struct{}, unblock chan<- struct{}) {
+	receivedCh := make(chan struct{}, 10)
+	unblockCh := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedCh <- struct{}{}
+		<-unblockCh
+	}))
+	t.Cleanup(backend.Close)
+
+	tokenServer, _ := newFlakyTokenServer(t)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:              "testclientid",
+		ClientSecret:          "testsecret",
+		TokenURL:              tokenServer.URL,
+		MaxConcurrentRequests: 1,
+		OnLimit:               onLimit,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	rt, err = oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+	return rt, backend.URL, receivedCh, unblockCh
+}
+
+// send sends a request through rt in the background, reporting its outcome on the returned channel.
+func send(ctx context.Context, t *testing.T, rt http.RoundTripper, url string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	return done
+}
+
+func TestMaxConcurrentRequestsQueue(t *testing.T) {
+	rt, url, received, unblock := newLimitedRoundTripper(t, onLimitQueue)
+
+	first := send(context.Background(), t, rt, url)
+	<-received
+	second := send(context.Background(), t, rt, url)
+
+	// the second request waits for the first one to complete
+	select {
+	case <-received:
+		t.Fatal("the second request was sent while the first one was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unblock <- struct{}{}
+	require.NoError(t, <-first)
+	<-received
+	unblock <- struct{}{}
+	require.NoError(t, <-second)
+}
+
+func TestMaxConcurrentRequestsQueueCanceled(t *testing.T) {
+	rt, url, received, unblock := newLimitedRoundTripper(t, "")
+
+	first := send(context.Background(), t, rt, url)
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, <-send(ctx, t, rt, url), context.DeadlineExceeded)
+
+	unblock <- struct{}{}
+	require.NoError(t, <-first)
+}
+
+func TestMaxConcurrentRequestsFail(t *testing.T) {
+	rt, url, received, unblock := newLimitedRoundTripper(t, onLimitFail)
+
+	first := send(context.Background(), t, rt, url)
+	<-received
+
+	assert.ErrorIs(t, <-send(context.Background(), t, rt, url), errConcurrencyLimit)
+
+	unblock <- struct{}{}
+	require.NoError(t, <-first)
+
+	// the slot is released once the response body is closed
+	third := send(context.Background(), t, rt, url)
+	<-received
+	unblock <- struct{}{}
+	require.NoError(t, <-third)
+}
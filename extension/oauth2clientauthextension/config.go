@@ -15,7 +15,13 @@
 package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/collector/config"
@@ -23,9 +29,87 @@ import (
 )
 
 var (
-	errNoClientIDProvided     = errors.New("no ClientID provided in the OAuth2 exporter configuration")
-	errNoTokenURLProvided     = errors.New("no TokenURL provided in OAuth Client Credentials configuration")
-	errNoClientSecretProvided = errors.New("no ClientSecret provided in OAuth Client Credentials configuration")
+	errNoClientIDProvided       = errors.New("no ClientID provided in the OAuth2 exporter configuration")
+	errNoTokenURLProvided       = errors.New("no TokenURL provided in OAuth Client Credentials configuration")
+	errNoClientSecretProvided   = errors.New("no ClientSecret provided in OAuth Client Credentials configuration")
+	errNegativeMaxRetries       = errors.New("retry.max_retries must not be negative")
+	errNegativeMaxElapsedTime   = errors.New("retry.max_elapsed_time must not be negative")
+	errNegativeMaxDNSRetries    = errors.New("retry.max_dns_retries must not be negative")
+	errNegativeRetryBudget      = errors.New("retry.budget_per_second must not be negative")
+	errNegativeRetryBudgetBurst = errors.New("retry.budget_burst must not be negative")
+	errUnsupportedJitter        = errors.New("unsupported retry.jitter_strategy, must be full, equal or none")
+	errNoInitialInterval        = errors.New("retry.initial_interval must be positive when retries are enabled")
+	errUnsupportedGrantType     = errors.New("unsupported grant_type in OAuth2 configuration")
+	errUnsupportedMode          = errors.New("unsupported mode, must be oauth2 or broker")
+	errNoBrokerURL              = errors.New("broker_url is required in the broker mode")
+	errBrokerWithProfiles       = errors.New("profiles aren't supported in the broker mode")
+	errNegativeBrokerTTL        = errors.New("broker_token_ttl must not be negative")
+	errNoSAMLAssertionFile      = errors.New("no saml_assertion_file provided for the SAML 2.0 bearer grant")
+	errEmptySAMLAssertion       = errors.New("empty SAML assertion file")
+	errEmptySubjectToken        = errors.New("empty subject token file")
+	errKeyPassphraseWithoutKey  = errors.New("tls.key_passphrase requires tls.cert_file and tls.key_file")
+	errKeyNotEncrypted          = errors.New("tls.key_passphrase is set but the key file is not an encrypted PKCS#8 key")
+	errAuthorizationMetadata    = errors.New("grpc_metadata must not contain the authorization key")
+	errInvalidEndpointAddress   = errors.New("token_endpoint_address must be a host:port address")
+	errUnixSocketWithAddress    = errors.New("token_unix_socket and token_endpoint_address are mutually exclusive")
+	errInvalidLocalAddress      = errors.New("local_address must be an IP address")
+	errLocalAddressWithSocket   = errors.New("local_address and token_unix_socket are mutually exclusive")
+	errSRVWithAddress           = errors.New("token_srv can't be combined with token_endpoint_address or token_unix_socket")
+	errUnsupportedDialNetwork   = errors.New("unsupported dial_network, must be tcp, tcp4 or tcp6")
+	errUnsupportedCompression   = errors.New("unsupported token_request_compression, must be gzip or deflate")
+	errNegativeMinValidity      = errors.New("min_remaining_validity must not be negative")
+	errNegativeRevalidate       = errors.New("stale_while_revalidate must not be negative")
+	errNegativeMaxLifetime      = errors.New("max_token_lifetime must not be negative")
+	errNegativeMaxRefreshes     = errors.New("max_refreshes_per_minute must not be negative")
+	errUnsupportedNotBefore     = errors.New("unsupported not_before, must be wait or warn")
+	errNegativeClockSkew        = errors.New("not_before_clock_skew must not be negative")
+	errUnsupportedTokenLocation = errors.New("unsupported token_location, must be header or query")
+	errNegativeMaxCached        = errors.New("max_cached_token_sources must not be negative")
+	errNoMaxStale               = errors.New("max_stale must be positive when serve_stale_on_refresh_failure is enabled")
+	errNegativeThreshold        = errors.New("circuit_breaker.failure_threshold must not be negative")
+	errNoCooldown               = errors.New("circuit_breaker.cooldown must be positive when the circuit breaker is enabled")
+	errNegativeCircuitWindow    = errors.New("circuit_breaker.window must not be negative")
+	errInvalidFieldPath         = errors.New("response_field_map paths must be dot-separated field names")
+	errNegativeMaxConcurrent    = errors.New("max_concurrent_requests must not be negative")
+	errInvalidAnonymousStatus   = errors.New("anonymous_on_status must only list HTTP status codes")
+	errNegativeRequestRate      = errors.New("token_requests_per_second must not be negative")
+	errNegativeRequestBurst     = errors.New("token_requests_burst must not be negative")
+	errNoBootstrapExpiry        = errors.New("bootstrap_expiry must be positive when bootstrap_access_token_env is set")
+	errBootstrapWithValidate    = errors.New("bootstrap_access_token_env and validate_on_start are mutually exclusive")
+	errNegativeStartGrace       = errors.New("start_grace_period must not be negative")
+	errGraceWithoutValidate     = errors.New("start_grace_period requires validate_on_start")
+	errBindingWithoutCert       = errors.New("verify_certificate_binding requires tls.cert_file and tls.key_file")
+	errUnsupportedOnLimit       = errors.New("unsupported on_limit, must be queue or fail")
+	errNegativeEventHistory     = errors.New("fetch_event_history must not be negative")
+	errInvalidRefreshSchedule   = errors.New("invalid refresh_schedule, must be a duration or a cron expression")
+	errAttributeWithoutProfiles = errors.New("profile_attribute requires profiles")
+	errUnsupportedHMACAlgorithm = errors.New("unsupported request_signing.hmac_algorithm, must be sha256, sha384 or sha512")
+	errInvalidClaimsTemplate    = errors.New("invalid signed_header.claims template")
+	errReservedSignedClaim      = errors.New("signed_header.claims must not set the iat, exp and jti claims")
+	errInvalidPrimingURL        = errors.New("session_priming_url must be an absolute http or https URL")
+	errNonReloadableSetting     = errors.New("setting can't be changed by a reload, the collector must be restarted")
+)
+
+const (
+	tokenLocationHeader = "header"
+	tokenLocationQuery  = "query"
+)
+
+const (
+	dialNetworkTCP  = "tcp"
+	dialNetworkTCP4 = "tcp4"
+	dialNetworkTCP6 = "tcp6"
+)
+
+const (
+	compressionGzip    = "gzip"
+	compressionDeflate = "deflate"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeSAML2Bearer       = "urn:ietf:params:oauth:grant-type:saml2-bearer"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
 )
 
 // Config stores the configuration for OAuth2 Client Credentials (2-legged OAuth2 flow) setup.
@@ -40,31 +124,774 @@ type Config struct {
 	// See https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
 	ClientSecret string `mapstructure:"client_secret"`
 
+	// ClientIDField replaces the client_id form field name of token requests, for authorization servers
+	// deviating from the specification. Setting it or ClientSecretField sends the credentials in the request body.
+	ClientIDField string `mapstructure:"client_id_field,omitempty"`
+
+	// ClientSecretField replaces the client_secret form field name of token requests, for authorization servers
+	// deviating from the specification.
+	ClientSecretField string `mapstructure:"client_secret_field,omitempty"`
+
+	// AlwaysSendClientIDInBody sends the client_id form field in the body of the token requests even when the client
+	// credentials are sent in the Authorization header, for authorization servers requiring both. This deviates from
+	// the specification, which requires a single authentication method per request.
+	AlwaysSendClientIDInBody bool `mapstructure:"always_send_client_id_in_body,omitempty"`
+
+	// Mode selects where tokens come from: "oauth2" (default) requests them from the authorization server, "broker"
+	// gets them from the token broker at BrokerURL, e.g. an identity sidecar, without any OAuth2 settings.
+	Mode string `mapstructure:"mode,omitempty"`
+
+	// BrokerURL is the endpoint of the token broker the tokens are got from with a GET request in the broker mode.
+	BrokerURL string `mapstructure:"broker_url,omitempty"`
+
+	// BrokerTokenTTL is how long the tokens of the broker are cached, unless they expire sooner. Defaults to 1m.
+	BrokerTokenTTL time.Duration `mapstructure:"broker_token_ttl,omitempty"`
+
+	// GrantType selects the flow used to obtain tokens, either "client_credentials" (default),
+	// "urn:ietf:params:oauth:grant-type:saml2-bearer", "urn:ietf:params:oauth:grant-type:token-exchange"
+	// or a grant type registered with RegisterGrantHandler.
+	// See https://datatracker.ietf.org/doc/html/rfc7522 and https://datatracker.ietf.org/doc/html/rfc8693
+	GrantType string `mapstructure:"grant_type,omitempty"`
+
+	// SAMLAssertionFile is the path of the file holding the SAML 2.0 assertion exchanged for tokens
+	// when GrantType is "urn:ietf:params:oauth:grant-type:saml2-bearer". The file is read on every token request.
+	SAMLAssertionFile string `mapstructure:"saml_assertion_file,omitempty"`
+
+	// SubjectTokenFile is the path of the file holding the JWT exchanged for tokens when GrantType is
+	// "urn:ietf:params:oauth:grant-type:token-exchange". The file is read on every token request. Defaults to the
+	// projected service account token of Kubernetes pods, /var/run/secrets/kubernetes.io/serviceaccount/token.
+	SubjectTokenFile string `mapstructure:"subject_token_file,omitempty"`
+
 	// TokenURL is the resource server's token endpoint
 	// URL. This is a constant specific to each server.
 	// See https://datatracker.ietf.org/doc/html/rfc6749#section-3.2
 	TokenURL string `mapstructure:"token_url"`
 
+	// TokenRequestHost replaces the Host header of the token requests, which otherwise is the host of TokenURL.
+	// The connection is still established with the host of TokenURL.
+	TokenRequestHost string `mapstructure:"token_request_host,omitempty"`
+
+	// IdempotencyKeyHeader is the header of the idempotency key sent with the token requests, e.g. "Idempotency-Key",
+	// for authorization servers issuing a single token for the retries of a token request. A new key is generated
+	// for every token request, and kept for its retries. No key is sent when empty.
+	IdempotencyKeyHeader string `mapstructure:"idempotency_key_header,omitempty"`
+
+	// SessionPrimingURL is got before every token request, for authorization servers only issuing tokens to clients
+	// holding a session cookie set beforehand. The cookies set by its responses and by the token responses are sent
+	// with the following token requests, and only with them. No request is sent first when empty.
+	SessionPrimingURL string `mapstructure:"session_priming_url,omitempty"`
+
+	// TokenRequestCompression compresses the body of the token requests with the given Content-Encoding,
+	// either "gzip" or "deflate", for authorization servers supporting it. Bodies are sent uncompressed when empty.
+	TokenRequestCompression string `mapstructure:"token_request_compression,omitempty"`
+
+	// TokenRequestParameterOrder lists the form parameters of the token requests in the order they are sent,
+	// for gateways computing signatures over the body as is. The other parameters follow, sorted by name.
+	TokenRequestParameterOrder []string `mapstructure:"token_request_parameter_order,omitempty"`
+
+	// PropagateTraceContext sends the span of the token requests to the authorization server with the W3C
+	// traceparent and tracestate headers, when a span is recorded for them.
+	PropagateTraceContext bool `mapstructure:"propagate_trace_context,omitempty"`
+
+	// CorrelationHeader is the header carrying the trace ID of the span of the token requests, when a span is
+	// recorded for them, for authorization servers logging a correlation ID rather than the W3C headers.
+	CorrelationHeader string `mapstructure:"correlation_header,omitempty"`
+
+	// TokenRequestsPerSecond caps the rate of the requests sent to the authorization server, retries included, e.g. to
+	// stay within the share of a fleet-wide quota of the client. Requests beyond it wait for their turn. Zero disables it.
+	TokenRequestsPerSecond float64 `mapstructure:"token_requests_per_second,omitempty"`
+
+	// TokenRequestsBurst is the number of requests that may be sent at once above TokenRequestsPerSecond.
+	// Defaults to 1.
+	TokenRequestsBurst int `mapstructure:"token_requests_burst,omitempty"`
+
+	// RevocationEndpoint is the URL of the token revocation endpoint of the authorization server. When set, the tokens
+	// that haven't expired yet are revoked when the extension shuts down.
+	// See https://datatracker.ietf.org/doc/html/rfc7009
+	RevocationEndpoint string `mapstructure:"revocation_endpoint,omitempty"`
+
+	// AuditLogFile is the path of a file every token request is recorded in, as a JSON line holding its time,
+	// the token URL, its outcome and the error code of failures. Tokens and client credentials are never recorded.
+	AuditLogFile string `mapstructure:"audit_log_file,omitempty"`
+
+	// FetchEventHistory is the number of latest token requests kept in memory, with their time, outcome, latency
+	// and error code, and rendered by the ZPageHandler of the extension. No history is kept when 0.
+	FetchEventHistory int `mapstructure:"fetch_event_history,omitempty"`
+
+	// TokenEndpointAddress is the host:port connections for token requests are established with, in place of the
+	// address TokenURL resolves to. The TLS certificate of the server is still verified against the host of TokenURL.
+	TokenEndpointAddress string `mapstructure:"token_endpoint_address,omitempty"`
+
+	// TokenUnixSocket is the path of the Unix domain socket connections for token requests are established with,
+	// for authorization servers like sidecar brokers not listening on TCP. TokenURL still gives the request URL.
+	TokenUnixSocket string `mapstructure:"token_unix_socket,omitempty"`
+
+	// TokenSRV is the name of a DNS SRV record, e.g. "_oauth._tcp.idp.example.com", whose target gives the host and
+	// port token requests are sent to, in place of the ones of TokenURL. The TLS certificate of the server is verified
+	// against the target host. The record is resolved again every 5 minutes.
+	TokenSRV string `mapstructure:"token_srv,omitempty"`
+
+	// CredentialsFile is the path of a JSON or YAML file providing the client_id, client_secret, token_url
+	// and scopes settings, so that they can be kept out of the collector configuration. It is loaded when
+	// the extension starts and the settings it provides take precedence over the ones of this configuration.
+	CredentialsFile string `mapstructure:"credentials_file,omitempty"`
+
 	// Scope specifies optional requested permissions.
 	// See https://datatracker.ietf.org/doc/html/rfc6749#section-3.3
 	Scopes []string `mapstructure:"scopes,omitempty"`
 
+	// HTTPScopes replace Scopes for the tokens of the HTTP exporters, which are then cached apart from the tokens of
+	// the gRPC exporters. Profiles without scopes of their own inherit them.
+	HTTPScopes []string `mapstructure:"http_scopes,omitempty"`
+
+	// GRPCScopes replace Scopes for the tokens of the gRPC exporters, which are then cached apart from the tokens of
+	// the HTTP exporters. Profiles without scopes of their own inherit them.
+	GRPCScopes []string `mapstructure:"grpc_scopes,omitempty"`
+
+	// DefaultScopes are requested along with Scopes or the scopes of the selected profile, duplicates being dropped.
+	DefaultScopes []string `mapstructure:"default_scopes,omitempty"`
+
+	// RequiredScopes must all be granted to the tokens, which are otherwise rejected. With ValidateOnStart, the
+	// extension fails to start when they aren't granted.
+	RequiredScopes []string `mapstructure:"required_scopes,omitempty"`
+
+	// Profiles defines named token profiles, each requesting tokens for its own audience and scopes.
+	// Requests select a profile with ContextWithProfile.
+	Profiles map[string]TokenProfile `mapstructure:"profiles,omitempty"`
+
+	// ProfileAttribute is the attribute whose value selects the profile of the requests carrying attributes,
+	// e.g. a resource attribute of logs naming their tenant. Attributes are set with ContextWithAttributes.
+	ProfileAttribute string `mapstructure:"profile_attribute,omitempty"`
+
+	// MaxCachedTokenSources bounds the number of profile token sources, along with their token, kept by each
+	// exporter. The least recently used ones are dropped first. Defaults to 100.
+	MaxCachedTokenSources int `mapstructure:"max_cached_token_sources,omitempty"`
+
+	// TokenLocation is where the token is attached to the requests of HTTP exporters, either the Authorization
+	// "header" (default) or the access_token "query" parameter.
+	TokenLocation string `mapstructure:"token_location,omitempty"`
+
+	// FailOpen makes the requests and RPCs of the exporters be sent without authorization when no token can be
+	// obtained, instead of failing. Meant for migrations to authenticated endpoints, it must not be relied upon otherwise.
+	FailOpen bool `mapstructure:"fail_open,omitempty"`
+
+	// AnonymousOnStatus lists the status codes of the responses to the requests of the HTTP exporters that have the
+	// requests sent again, once, without authorization. Meant for migrations from anonymous endpoints.
+	AnonymousOnStatus []int `mapstructure:"anonymous_on_status,omitempty"`
+
+	// LogTokenMasked logs the first and last few characters of the tokens handed out to the exporters at debug level,
+	// e.g. "abcd…wxyz", to compare them with the tokens of the authorization server when debugging an integration.
+	LogTokenMasked bool `mapstructure:"log_token_masked,omitempty"`
+
+	// VerifyAudienceAgainstHost fails the requests of HTTP exporters sent to a host the aud claim of the JWT access
+	// token doesn't designate, to catch tokens requested for the audience of another endpoint.
+	VerifyAudienceAgainstHost bool `mapstructure:"verify_audience_against_host,omitempty"`
+
+	// MaxConcurrentRequests bounds the number of requests of each HTTP exporter in flight, a request being in flight
+	// until its response body is closed. Zero disables the limit.
+	MaxConcurrentRequests int `mapstructure:"max_concurrent_requests,omitempty"`
+
+	// OnLimit is what happens to the requests beyond MaxConcurrentRequests: they either "queue" (default) until
+	// a request completes, or "fail" right away.
+	OnLimit string `mapstructure:"on_limit,omitempty"`
+
+	// GRPCMetadata is static metadata attached to the RPCs alongside the token, e.g. routing headers required
+	// by a service mesh. It can't replace the authorization metadata.
+	GRPCMetadata map[string]string `mapstructure:"grpc_metadata,omitempty"`
+
+	// ValidateOnStart makes the extension fetch a token for this configuration and for every profile when it starts,
+	// failing to start when any of them can't be obtained. The tokens are then used by the exporters.
+	ValidateOnStart bool `mapstructure:"validate_on_start,omitempty"`
+
+	// StartGracePeriod is how long the extension keeps fetching the tokens on start with ValidateOnStart when they
+	// fail to be obtained with temporary errors, e.g. while the authorization server is rolled out, before failing.
+	StartGracePeriod time.Duration `mapstructure:"start_grace_period,omitempty"`
+
+	// BootstrapAccessTokenEnv is the environment variable providing an access token, e.g. a short-lived token injected
+	// by a CI system, handed out until BootstrapExpiry before tokens are requested as usual.
+	BootstrapAccessTokenEnv string `mapstructure:"bootstrap_access_token_env,omitempty"`
+
+	// BootstrapExpiry is the time after the start of the extension the bootstrap access token expires.
+	BootstrapExpiry time.Duration `mapstructure:"bootstrap_expiry,omitempty"`
+
+	// VerifyCertificateBinding rejects the tokens whose cnf.x5t#S256 confirmation claim doesn't match the client
+	// certificate, for authorization servers issuing certificate-bound access tokens.
+	// See https://datatracker.ietf.org/doc/html/rfc8705#section-3
+	VerifyCertificateBinding bool `mapstructure:"verify_certificate_binding,omitempty"`
+
 	// TLSSetting struct exposes TLS client configuration for the underneath client to authorization server.
-	TLSSetting configtls.TLSClientSetting `mapstructure:"tls,omitempty"`
+	TLSSetting TLSClientSetting `mapstructure:"tls,omitempty"`
+
+	// ReuseBaseTransportTLS makes the token requests use the TLS configuration of the exporter the extension authenticates,
+	// in place of TLSSetting, when its base transport is an *http.Transport. TLSSetting still applies otherwise,
+	// e.g. to gRPC exporters. With several exporters, the TLS configuration of the first one is used.
+	ReuseBaseTransportTLS bool `mapstructure:"reuse_base_transport_tls,omitempty"`
 
 	// Timeout parameter configures `http.Client.Timeout` for the underneath client to authorization
 	// server while fetching and refreshing tokens.
 	Timeout time.Duration `mapstructure:"timeout,omitempty"`
+
+	// DialTimeout bounds how long the underneath client waits for a connection to the authorization
+	// server to be established. Unlike Timeout, it does not cover reading the response.
+	DialTimeout time.Duration `mapstructure:"dial_timeout,omitempty"`
+
+	// DialNetwork is the network connections to the authorization server are established with: "tcp" (default)
+	// for both IPv4 and IPv6, "tcp4" for IPv4 only or "tcp6" for IPv6 only.
+	DialNetwork string `mapstructure:"dial_network,omitempty"`
+
+	// LocalAddress is the source IP address of the connections to the authorization server, e.g. to egress through
+	// the interface allowed by firewall rules on a multi-homed host. The system picks it when empty.
+	LocalAddress string `mapstructure:"local_address,omitempty"`
+
+	// ForceHTTP1 disables HTTP/2 for the token requests, for load balancers in front of the authorization server
+	// misbehaving with it. "h2" is then left out of tls.next_protos.
+	ForceHTTP1 bool `mapstructure:"force_http1,omitempty"`
+
+	// DisableAutoRefresh makes the extension obtain a single token and keep using it after it expired,
+	// instead of refreshing it. The expiry is left for the server receiving the token to handle.
+	DisableAutoRefresh bool `mapstructure:"disable_auto_refresh,omitempty"`
+
+	// RefreshSchedule makes the extension obtain new tokens on a schedule, regardless of the expiry of the cached ones,
+	// either a duration, e.g. "6h", or a 5 fields cron expression evaluated in UTC, e.g. "0 3 * * 0".
+	// Expiry-driven refresh still applies unless DisableAutoRefresh is set.
+	RefreshSchedule string `mapstructure:"refresh_schedule,omitempty"`
+
+	// MinRemainingValidity is the lifetime a cached token must have left to be handed out. Tokens closer to
+	// their expiry are refreshed first, so that they don't expire while in use, e.g. during a long-lived stream.
+	// Tokens living shorter are refreshed once half of their lifetime has passed.
+	MinRemainingValidity time.Duration `mapstructure:"min_remaining_validity,omitempty"`
+
+	// StaleWhileRevalidate is the window, before MinRemainingValidity is reached, during which the cached token is
+	// still handed out while a new one is obtained in the background, so that requests don't wait for refreshes.
+	// Zero disables background refreshes.
+	StaleWhileRevalidate time.Duration `mapstructure:"stale_while_revalidate,omitempty"`
+
+	// MaxTokenLifetime caps the lifetime of the tokens, so that tokens with an absurd expiry are still refreshed.
+	// Zero disables the cap.
+	MaxTokenLifetime time.Duration `mapstructure:"max_token_lifetime,omitempty"`
+
+	// MaxRefreshesPerMinute fails the token requests of a token source beyond that many in the last minute, without
+	// sending them, to break refresh loops, e.g. with an authorization server issuing expired tokens. Zero disables it.
+	MaxRefreshesPerMinute int `mapstructure:"max_refreshes_per_minute,omitempty"`
+
+	// NotBefore handles the JWT access tokens with an nbf claim in the future: "wait" holds them back until nbf plus
+	// NotBeforeClockSkew has passed, "warn" logs a warning. Ignored when empty.
+	NotBefore string `mapstructure:"not_before,omitempty"`
+
+	// NotBeforeClockSkew is added to the nbf claim of the tokens held back, for the clocks of the servers receiving them.
+	NotBeforeClockSkew time.Duration `mapstructure:"not_before_clock_skew,omitempty"`
+
+	// ServeStaleOnRefreshFailure keeps handing out the last token when it can't be refreshed, as long as it expired
+	// less than MaxStale ago, so that exports keep working during short outages of the authorization server.
+	ServeStaleOnRefreshFailure bool `mapstructure:"serve_stale_on_refresh_failure,omitempty"`
+
+	// MaxStale is how long after its expiry the last token is handed out when it can't be refreshed.
+	MaxStale time.Duration `mapstructure:"max_stale,omitempty"`
+
+	// HonorHTTPCacheHeaders shortens the lifetime of the tokens to the freshness lifetime of the token response,
+	// given by its Cache-Control max-age directive or its Expires header.
+	HonorHTTPCacheHeaders bool `mapstructure:"honor_http_cache_headers,omitempty"`
+
+	// ResponseFieldMap locates the fields of token responses not following the standard shape.
+	ResponseFieldMap ResponseFieldMap `mapstructure:"response_field_map"`
+
+	// CircuitBreaker stops sending requests to a failing authorization server for a while.
+	CircuitBreaker CircuitBreakerSettings `mapstructure:"circuit_breaker"`
+
+	// Retry configures how failed requests to the authorization server are retried.
+	Retry RetrySettings `mapstructure:"retry"`
+
+	// RequestSigning signs the token requests with a shared key.
+	RequestSigning RequestSigningSettings `mapstructure:"request_signing"`
+
+	// SignedHeader adds a JWT signed for each request of the HTTP exporters alongside their access token.
+	SignedHeader SignedHeaderSettings `mapstructure:"signed_header"`
+}
+
+// ResponseFieldMap gives the dot-separated JSON paths of the fields of token responses, for authorization servers
+// not returning them at the top level of the response. Unset fields are read from their standard location.
+type ResponseFieldMap struct {
+	// AccessToken is the path of the access token, e.g. data.token.
+	AccessToken string `mapstructure:"access_token"`
+
+	// TokenType is the path of the token type.
+	TokenType string `mapstructure:"token_type"`
+
+	// ExpiresIn is the path of the lifetime of the token, in seconds.
+	ExpiresIn string `mapstructure:"expires_in"`
+}
+
+// TokenProfile overrides the audience and scopes of the token requests of the requests selecting it.
+type TokenProfile struct {
+	// Audience is sent as the audience parameter of the token requests.
+	Audience string `mapstructure:"audience,omitempty"`
+
+	// Scopes replaces the scopes of the extension configuration when set.
+	Scopes []string `mapstructure:"scopes,omitempty"`
+}
+
+// RetrySettings defines retries of token requests failing with a connection error, a timeout or a retryable status code.
+type RetrySettings struct {
+	// MaxRetries is the maximum number of times a failed token request is retried.
+	// Zero disables retries, unless MaxElapsedTime is set.
+	MaxRetries int `mapstructure:"max_retries"`
+
+	// MaxElapsedTime bounds the time spent retrying a failed token request, from its first attempt: retries stop once
+	// the next one would start after it. The deadline of the request context still applies when earlier. When MaxRetries
+	// is zero, failed requests are retried until MaxElapsedTime is reached, otherwise the first limit reached applies.
+	MaxElapsedTime time.Duration `mapstructure:"max_elapsed_time"`
+
+	// InitialInterval is the time to wait before the first retry. The interval doubles after each retry.
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+
+	// MaxInterval is the upper bound on the time to wait between retries.
+	MaxInterval time.Duration `mapstructure:"max_interval"`
+
+	// JitterStrategy randomizes the time to wait between retries, so that clients failing together don't retry
+	// together: "full" (default) waits anywhere up to the interval, "equal" waits at least half of it and "none" waits
+	// for the interval exactly.
+	JitterStrategy string `mapstructure:"jitter_strategy,omitempty"`
+
+	// ResetOnSuccess restarts the backoff from InitialInterval once a token request succeeds. Otherwise, the interval
+	// keeps growing across the token requests of a token source.
+	ResetOnSuccess bool `mapstructure:"reset_on_success"`
+
+	// RetryableStatusCodes lists the token endpoint response codes that are retried.
+	// Defaults to 429, 500, 502, 503 and 504.
+	RetryableStatusCodes []int `mapstructure:"retryable_status_codes"`
+
+	// MaxDNSRetries is the maximum number of times resolving the host of the token endpoint is retried when it fails,
+	// e.g. with NXDOMAIN during a DNS failover, waiting InitialInterval in between. Zero disables these retries.
+	MaxDNSRetries int `mapstructure:"max_dns_retries,omitempty"`
+
+	// BudgetPerSecond caps the rate of the retries of all the token requests of the extension, whatever their profile
+	// or exporter, so that many token sources failing together don't overwhelm the authorization server with retries.
+	// Failures are reported without being retried once the budget is spent. Zero disables the budget.
+	BudgetPerSecond float64 `mapstructure:"budget_per_second,omitempty"`
+
+	// BudgetBurst is the number of retries that may be sent at once above BudgetPerSecond. Defaults to 1.
+	BudgetBurst int `mapstructure:"budget_burst,omitempty"`
+}
+
+// RequestSigningSettings signs the token requests, for gateways in front of the authorization server
+// authenticating them with a shared key.
+type RequestSigningSettings struct {
+	// HMACKey is the shared key of the HMAC signature of the request body. Requests aren't signed when empty.
+	HMACKey string `mapstructure:"hmac_key,omitempty"`
+
+	// HMACHeader is the header holding the hex encoded signature. Defaults to "X-Signature".
+	HMACHeader string `mapstructure:"hmac_header,omitempty"`
+
+	// HMACAlgorithm is the hash function of the signature, either "sha256" (default), "sha384" or "sha512".
+	HMACAlgorithm string `mapstructure:"hmac_algorithm,omitempty"`
+}
+
+// SignedHeaderSettings adds a JWT signed for each request to the requests of the HTTP exporters, alongside the
+// access token, for backends checking the integrity of the requests. Its claims are computed from the request.
+type SignedHeaderSettings struct {
+	// KeyFile is the PEM private key signing the JWTs, an RSA key (RS256) or a P-256 ECDSA key (ES256).
+	// Requests aren't signed when empty.
+	KeyFile string `mapstructure:"key_file,omitempty"`
+
+	// Header is the header holding the JWT. Defaults to "X-Request-Signature".
+	Header string `mapstructure:"header,omitempty"`
+
+	// Claims are the claims of the JWT, as Go templates of the attributes of the request: .Method, .Host, .Path,
+	// .Query and .BodySHA256, the base64url encoded SHA-256 digest of the body. The iat, exp and jti claims are
+	// always set.
+	Claims map[string]string `mapstructure:"claims,omitempty"`
+}
+
+// TLSClientSetting extends the TLS client configuration with settings specific to the connections to the
+// authorization server.
+type TLSClientSetting struct {
+	configtls.TLSClientSetting `mapstructure:",squash"`
+
+	// NextProtos lists the application protocols offered during the TLS handshake (ALPN), in order of preference.
+	// HTTP/2 is used for token requests when the server selects "h2".
+	NextProtos []string `mapstructure:"next_protos,omitempty"`
+
+	// KeyPassphrase decrypts the key file, stored as an encrypted PKCS#8 PEM file ("ENCRYPTED PRIVATE KEY").
+	KeyPassphrase string `mapstructure:"key_passphrase,omitempty"`
+
+	// IntermediatesFile is a PEM file of intermediate certificates completing the certificate chains sent by
+	// authorization servers, for servers relying on clients to fetch the missing ones.
+	IntermediatesFile string `mapstructure:"intermediates_file,omitempty"`
+
+	// RequireServerAuthEKU rejects the certificates of authorization servers without the TLS server authentication
+	// extended key usage, which crypto/tls accepts when the certificate has no extended key usage at all.
+	RequireServerAuthEKU bool `mapstructure:"require_server_auth_eku,omitempty"`
+}
+
+// loadTLSConfig returns the TLS configuration for the connections to the authorization server.
+func (c TLSClientSetting) loadTLSConfig() (*tls.Config, error) {
+	settings := c.TLSClientSetting
+	if c.KeyPassphrase != "" {
+		// configtls can't decrypt the key, the key pair is loaded below instead
+		settings.CertFile, settings.KeyFile = "", ""
+	}
+	tlsCfg, err := settings.LoadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.KeyPassphrase != "" && tlsCfg != nil {
+		cert, err := loadEncryptedKeyPair(c.CertFile, c.KeyFile, c.KeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS config: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(c.NextProtos) == 0 && c.IntermediatesFile == "" && !c.RequireServerAuthEKU {
+		return tlsCfg, nil
+	}
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+	if err = c.applyTo(tlsCfg); err != nil {
+		return nil, err
+	}
+	return tlsCfg, nil
+}
+
+// applyTo applies the settings specific to the connections to the authorization server, next_protos,
+// intermediates_file and require_server_auth_eku, to tlsCfg.
+func (c TLSClientSetting) applyTo(tlsCfg *tls.Config) error {
+	if len(c.NextProtos) > 0 {
+		tlsCfg.NextProtos = c.NextProtos
+	}
+	if c.IntermediatesFile != "" {
+		intermediates, err := loadIntermediates(c.IntermediatesFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS config: %w", err)
+		}
+		withIntermediates(tlsCfg, intermediates)
+	}
+	if c.RequireServerAuthEKU {
+		withServerAuthEKU(tlsCfg)
+	}
+	return nil
+}
+
+// CircuitBreakerSettings defines when token requests stop being sent to a failing authorization server.
+type CircuitBreakerSettings struct {
+	// FailureThreshold is the number of consecutive failed token requests opening the circuit, failing further
+	// token requests right away. Zero disables the circuit breaker.
+	FailureThreshold int `mapstructure:"failure_threshold"`
+
+	// Window is the period the consecutive failed token requests must fall within to open the circuit, the count
+	// starting over once it has passed since the first of them. Zero counts failures regardless of when they occur.
+	Window time.Duration `mapstructure:"window,omitempty"`
+
+	// Cooldown is how long the circuit stays open before a trial token request is sent.
+	Cooldown time.Duration `mapstructure:"cooldown"`
 }
 
 var _ config.Extension = (*Config)(nil)
 
+// changedNonReloadableSetting returns the name of the first setting differing between cfg and reloaded that Reload
+// can't apply, either because it's wired into the RoundTripper and PerRPCCredentials already handed out, or because
+// it's set up once, on start. Whether profiles and grpc_metadata are configured at all can't change either, the
+// RoundTripper and PerRPCCredentials handling them only when they are. An empty string is returned when there is none.
+func changedNonReloadableSetting(cfg, reloaded *Config) string {
+	previous, current := cfg.Effective(), reloaded.Effective()
+	settings := []struct {
+		name              string
+		previous, current interface{}
+	}{
+		{"token_location", previous.TokenLocation, current.TokenLocation},
+		{"fail_open", previous.FailOpen, current.FailOpen},
+		{"anonymous_on_status", previous.AnonymousOnStatus, current.AnonymousOnStatus},
+		{"log_token_masked", previous.LogTokenMasked, current.LogTokenMasked},
+		{"max_cached_token_sources", previous.MaxCachedTokenSources, current.MaxCachedTokenSources},
+		{"verify_audience_against_host", previous.VerifyAudienceAgainstHost, current.VerifyAudienceAgainstHost},
+		{"max_concurrent_requests", previous.MaxConcurrentRequests, current.MaxConcurrentRequests},
+		{"on_limit", previous.OnLimit, current.OnLimit},
+		{"profile_attribute", previous.ProfileAttribute, current.ProfileAttribute},
+		{"audit_log_file", previous.AuditLogFile, current.AuditLogFile},
+		{"fetch_event_history", previous.FetchEventHistory, current.FetchEventHistory},
+		{"refresh_schedule", previous.RefreshSchedule, current.RefreshSchedule},
+		{"signed_header", previous.SignedHeader, current.SignedHeader},
+		{"profiles", len(previous.Profiles) > 0, len(current.Profiles) > 0},
+		{"grpc_metadata", len(previous.GRPCMetadata) > 0, len(current.GRPCMetadata) > 0},
+	}
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.previous, setting.current) {
+			return setting.name
+		}
+	}
+	return ""
+}
+
+// Effective returns a copy of the configuration with the defaults applied by the extension at runtime filled in,
+// e.g. for tools generating documentation or checking configurations. The credentials file isn't loaded.
+func (cfg *Config) Effective() *Config {
+	effective := *cfg
+	if effective.Mode == "" {
+		effective.Mode = modeOAuth2
+	}
+	if effective.Mode == modeBroker && effective.BrokerTokenTTL == 0 {
+		effective.BrokerTokenTTL = defaultBrokerTokenTTL
+	}
+	if effective.GrantType == "" {
+		effective.GrantType = grantTypeClientCredentials
+	}
+	if effective.GrantType == grantTypeTokenExchange && effective.SubjectTokenFile == "" {
+		effective.SubjectTokenFile = defaultSubjectTokenFile
+	}
+	if effective.MaxCachedTokenSources == 0 {
+		effective.MaxCachedTokenSources = defaultMaxCachedTokenSources
+	}
+	if effective.DialNetwork == "" {
+		effective.DialNetwork = dialNetworkTCP
+	}
+	if effective.TokenLocation == "" {
+		effective.TokenLocation = tokenLocationHeader
+	}
+	if effective.OnLimit == "" {
+		effective.OnLimit = onLimitQueue
+	}
+	if effective.TokenRequestsPerSecond > 0 && effective.TokenRequestsBurst == 0 {
+		effective.TokenRequestsBurst = 1
+	}
+	if effective.Retry.BudgetPerSecond > 0 && effective.Retry.BudgetBurst == 0 {
+		effective.Retry.BudgetBurst = 1
+	}
+	if effective.RequestSigning.HMACHeader == "" {
+		effective.RequestSigning.HMACHeader = defaultHMACHeader
+	}
+	if effective.RequestSigning.HMACAlgorithm == "" {
+		effective.RequestSigning.HMACAlgorithm = hmacSHA256
+	}
+	if effective.SignedHeader.Header == "" {
+		effective.SignedHeader.Header = defaultSignedHeader
+	}
+	if effective.Retry.JitterStrategy == "" {
+		effective.Retry.JitterStrategy = jitterFull
+	}
+	if len(effective.Retry.RetryableStatusCodes) == 0 {
+		effective.Retry.RetryableStatusCodes = append([]int(nil), defaultRetryableStatusCodes...)
+	}
+	if len(cfg.Profiles) > 0 {
+		effective.Profiles = make(map[string]TokenProfile, len(cfg.Profiles))
+		for name, profile := range cfg.Profiles {
+			if len(profile.Scopes) == 0 {
+				profile.Scopes = cfg.Scopes
+			}
+			effective.Profiles[name] = profile
+		}
+	}
+	return &effective
+}
+
 // Validate checks if the extension configuration is valid
 func (cfg *Config) Validate() error {
+	switch cfg.Mode {
+	case "", modeOAuth2:
+	case modeBroker:
+		if cfg.BrokerURL == "" {
+			return errNoBrokerURL
+		}
+		if len(cfg.Profiles) > 0 {
+			return errBrokerWithProfiles
+		}
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedMode, cfg.Mode)
+	}
+	if cfg.BrokerTokenTTL < 0 {
+		return errNegativeBrokerTTL
+	}
+	// the credentials may also be provided by the credentials file, they are validated once it is loaded
+	if cfg.CredentialsFile == "" && cfg.Mode != modeBroker {
+		if err := cfg.validateCredentials(); err != nil {
+			return err
+		}
+	}
+	switch cfg.GrantType {
+	case "", grantTypeClientCredentials:
+	case grantTypeSAML2Bearer:
+		if cfg.SAMLAssertionFile == "" {
+			return errNoSAMLAssertionFile
+		}
+	case grantTypeTokenExchange:
+	default:
+		if grantHandler(cfg.GrantType) == nil {
+			return fmt.Errorf("%w: %q", errUnsupportedGrantType, cfg.GrantType)
+		}
+	}
+	if cfg.Retry.MaxRetries < 0 {
+		return errNegativeMaxRetries
+	}
+	if cfg.Retry.MaxElapsedTime < 0 {
+		return errNegativeMaxElapsedTime
+	}
+	if cfg.Retry.MaxDNSRetries < 0 {
+		return errNegativeMaxDNSRetries
+	}
+	if (cfg.Retry.MaxRetries > 0 || cfg.Retry.MaxElapsedTime > 0 || cfg.Retry.MaxDNSRetries > 0) && cfg.Retry.InitialInterval <= 0 {
+		// the retries would be sent right away
+		return errNoInitialInterval
+	}
+	if cfg.Retry.BudgetPerSecond < 0 {
+		return errNegativeRetryBudget
+	}
+	if cfg.Retry.BudgetBurst < 0 {
+		return errNegativeRetryBudgetBurst
+	}
+	switch cfg.Retry.JitterStrategy {
+	case "", jitterFull, jitterEqual, jitterNone:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedJitter, cfg.Retry.JitterStrategy)
+	}
+	if cfg.TLSSetting.KeyPassphrase != "" && (cfg.TLSSetting.CertFile == "" || cfg.TLSSetting.KeyFile == "") {
+		return errKeyPassphraseWithoutKey
+	}
+	if cfg.VerifyCertificateBinding && (cfg.TLSSetting.CertFile == "" || cfg.TLSSetting.KeyFile == "") {
+		return errBindingWithoutCert
+	}
+	if cfg.TokenEndpointAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.TokenEndpointAddress); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidEndpointAddress, err)
+		}
+		if cfg.TokenUnixSocket != "" {
+			return errUnixSocketWithAddress
+		}
+	}
+	if cfg.TokenSRV != "" && (cfg.TokenEndpointAddress != "" || cfg.TokenUnixSocket != "") {
+		return errSRVWithAddress
+	}
+	if cfg.LocalAddress != "" {
+		if net.ParseIP(cfg.LocalAddress) == nil {
+			return fmt.Errorf("%w: %q", errInvalidLocalAddress, cfg.LocalAddress)
+		}
+		if cfg.TokenUnixSocket != "" {
+			return errLocalAddressWithSocket
+		}
+	}
+	switch cfg.DialNetwork {
+	case "", dialNetworkTCP, dialNetworkTCP4, dialNetworkTCP6:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedDialNetwork, cfg.DialNetwork)
+	}
+	switch cfg.TokenRequestCompression {
+	case "", compressionGzip, compressionDeflate:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedCompression, cfg.TokenRequestCompression)
+	}
+	if cfg.MinRemainingValidity < 0 {
+		return errNegativeMinValidity
+	}
+	if cfg.StaleWhileRevalidate < 0 {
+		return errNegativeRevalidate
+	}
+	if cfg.MaxTokenLifetime < 0 {
+		return errNegativeMaxLifetime
+	}
+	if cfg.MaxRefreshesPerMinute < 0 {
+		return errNegativeMaxRefreshes
+	}
+	switch cfg.NotBefore {
+	case "", notBeforeWait, notBeforeWarn:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedNotBefore, cfg.NotBefore)
+	}
+	if cfg.NotBeforeClockSkew < 0 {
+		return errNegativeClockSkew
+	}
+	switch cfg.TokenLocation {
+	case "", tokenLocationHeader, tokenLocationQuery:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedTokenLocation, cfg.TokenLocation)
+	}
+	if cfg.MaxCachedTokenSources < 0 {
+		return errNegativeMaxCached
+	}
+	if cfg.ServeStaleOnRefreshFailure && cfg.MaxStale <= 0 {
+		return errNoMaxStale
+	}
+	for k := range cfg.GRPCMetadata {
+		if strings.EqualFold(k, "authorization") {
+			return errAuthorizationMetadata
+		}
+	}
+	if cfg.BootstrapAccessTokenEnv != "" {
+		if cfg.BootstrapExpiry <= 0 {
+			return errNoBootstrapExpiry
+		}
+		// both provide the initial token
+		if cfg.ValidateOnStart {
+			return errBootstrapWithValidate
+		}
+	}
+	if cfg.StartGracePeriod < 0 {
+		return errNegativeStartGrace
+	}
+	if cfg.StartGracePeriod > 0 && !cfg.ValidateOnStart {
+		return errGraceWithoutValidate
+	}
+	if cfg.TokenRequestsPerSecond < 0 {
+		return errNegativeRequestRate
+	}
+	if cfg.TokenRequestsBurst < 0 {
+		return errNegativeRequestBurst
+	}
+	if cfg.MaxConcurrentRequests < 0 {
+		return errNegativeMaxConcurrent
+	}
+	for _, status := range cfg.AnonymousOnStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("%w: %d", errInvalidAnonymousStatus, status)
+		}
+	}
+	if cfg.SessionPrimingURL != "" {
+		if u, err := url.Parse(cfg.SessionPrimingURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errInvalidPrimingURL
+		}
+	}
+	if hmacHash(cfg.RequestSigning.HMACAlgorithm) == nil {
+		return fmt.Errorf("%w: %q", errUnsupportedHMACAlgorithm, cfg.RequestSigning.HMACAlgorithm)
+	}
+	if _, err := parseClaimsTemplates(cfg.SignedHeader.Claims); err != nil {
+		return err
+	}
+	if cfg.ProfileAttribute != "" && len(cfg.Profiles) == 0 {
+		return errAttributeWithoutProfiles
+	}
+	if cfg.FetchEventHistory < 0 {
+		return errNegativeEventHistory
+	}
+	if cfg.RefreshSchedule != "" {
+		if _, err := parseRefreshSchedule(cfg.RefreshSchedule); err != nil {
+			return err
+		}
+	}
+	switch cfg.OnLimit {
+	case "", onLimitQueue, onLimitFail:
+	default:
+		return fmt.Errorf("%w: %q", errUnsupportedOnLimit, cfg.OnLimit)
+	}
+	for _, path := range cfg.ResponseFieldMap.fieldPaths() {
+		if !validPath(path) {
+			return fmt.Errorf("%w: %q", errInvalidFieldPath, path)
+		}
+	}
+	if cfg.CircuitBreaker.FailureThreshold < 0 {
+		return errNegativeThreshold
+	}
+	if cfg.CircuitBreaker.FailureThreshold > 0 && cfg.CircuitBreaker.Cooldown <= 0 {
+		return errNoCooldown
+	}
+	if cfg.CircuitBreaker.Window < 0 {
+		return errNegativeCircuitWindow
+	}
+	return nil
+}
+
+// validateCredentials checks the settings that can be provided by the credentials file.
+func (cfg *Config) validateCredentials() error {
 	if cfg.ClientID == "" {
 		return errNoClientIDProvided
 	}
-	if cfg.ClientSecret == "" {
+	if cfg.ClientSecret == "" && cfg.GrantType != grantTypeSAML2Bearer && cfg.GrantType != grantTypeTokenExchange {
 		return errNoClientSecretProvided
 	}
 	if cfg.TokenURL == "" {
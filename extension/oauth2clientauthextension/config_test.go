@@ -47,16 +47,50 @@ func TestLoadConfig(t *testing.T) {
 	ext := cfg.Extensions[config.NewComponentIDWithName(typeStr, "1")]
 	assert.Equal(t,
 		&Config{
-			ExtensionSettings: config.NewExtensionSettings(config.NewComponentIDWithName(typeStr, "1")),
-			ClientSecret:      "someclientsecret",
-			ClientID:          "someclientid",
-			Scopes:            []string{"api.metrics"},
-			TokenURL:          "https://example.com/oauth2/default/v1/token",
-			Timeout:           time.Second,
+			ExtensionSettings:     config.NewExtensionSettings(config.NewComponentIDWithName(typeStr, "1")),
+			ClientSecret:          "someclientsecret",
+			ClientID:              "someclientid",
+			Scopes:                []string{"api.metrics"},
+			TokenURL:              "https://example.com/oauth2/default/v1/token",
+			Timeout:               time.Second,
+			GrantType:             expected.GrantType,
+			Mode:                  expected.Mode,
+			MaxCachedTokenSources: expected.MaxCachedTokenSources,
+			DialNetwork:           expected.DialNetwork,
+			TokenLocation:         expected.TokenLocation,
+			OnLimit:               expected.OnLimit,
+			MaxTokenLifetime:      expected.MaxTokenLifetime,
+			CircuitBreaker:        expected.CircuitBreaker,
+			Retry:                 expected.Retry,
+			RequestSigning:        expected.RequestSigning,
+			SignedHeader:          expected.SignedHeader,
 		},
 		ext)
 
-	assert.Equal(t, 2, len(cfg.Service.Extensions))
+	ext3 := cfg.Extensions[config.NewComponentIDWithName(typeStr, "withretry")]
+	assert.Equal(t,
+		RetrySettings{
+			MaxRetries:           3,
+			InitialInterval:      200 * time.Millisecond,
+			MaxInterval:          5 * time.Second,
+			JitterStrategy:       "equal",
+			ResetOnSuccess:       true,
+			RetryableStatusCodes: []int{408, 425, 503},
+		},
+		ext3.(*Config).Retry)
+
+	ext4 := cfg.Extensions[config.NewComponentIDWithName(typeStr, "withcredentialsfile")]
+	assert.Equal(t, "/var/lib/oauth2/credentials.json", ext4.(*Config).CredentialsFile)
+
+	ext5 := cfg.Extensions[config.NewComponentIDWithName(typeStr, "withprofiles")]
+	assert.Equal(t,
+		map[string]TokenProfile{
+			"billing":   {Audience: "https://billing.example.com"},
+			"inventory": {Audience: "https://inventory.example.com", Scopes: []string{"inventory.write"}},
+		},
+		ext5.(*Config).Profiles)
+
+	assert.Equal(t, 5, len(cfg.Service.Extensions))
 	assert.Equal(t, config.NewComponentIDWithName(typeStr, "1"), cfg.Service.Extensions[0])
 }
 
@@ -74,15 +108,18 @@ func TestConfigTLSSettings(t *testing.T) {
 	ext2 := cfg.Extensions[config.NewComponentIDWithName(typeStr, "withtls")]
 
 	cfg2 := ext2.(*Config)
-	assert.Equal(t, cfg2.TLSSetting, configtls.TLSClientSetting{
-		TLSSetting: configtls.TLSSetting{
-			CAFile:   "cafile",
-			CertFile: "certfile",
-			KeyFile:  "keyfile",
-		},
-		Insecure:           true,
-		InsecureSkipVerify: false,
-		ServerName:         "",
+	assert.Equal(t, cfg2.TLSSetting, TLSClientSetting{
+		TLSClientSetting: configtls.TLSClientSetting{
+			TLSSetting: configtls.TLSSetting{
+				CAFile:   "cafile",
+				CertFile: "certfile",
+				KeyFile:  "keyfile",
+			},
+			Insecure:           true,
+			InsecureSkipVerify: false,
+			ServerName:         "",
+		},
+		NextProtos: []string{"h2", "http/1.1"},
 	})
 }
 
@@ -106,6 +143,214 @@ func TestLoadConfigError(t *testing.T) {
 			"missingsecret",
 			errNoClientSecretProvided,
 		},
+		{
+			"negativeretries",
+			errNegativeMaxRetries,
+		},
+		{
+			"passphrasewithoutkey",
+			errKeyPassphraseWithoutKey,
+		},
+		{
+			"authorizationmetadata",
+			errAuthorizationMetadata,
+		},
+		{
+			"invalidendpointaddress",
+			errInvalidEndpointAddress,
+		},
+		{
+			"unixsocketwithaddress",
+			errUnixSocketWithAddress,
+		},
+		{
+			"unsupporteddialnetwork",
+			errUnsupportedDialNetwork,
+		},
+		{
+			"invalidfieldpath",
+			errInvalidFieldPath,
+		},
+		{
+			"negativemaxconcurrent",
+			errNegativeMaxConcurrent,
+		},
+		{
+			"unsupportedonlimit",
+			errUnsupportedOnLimit,
+		},
+		{
+			"negativeeventhistory",
+			errNegativeEventHistory,
+		},
+		{
+			"invalidrefreshschedule",
+			errInvalidRefreshSchedule,
+		},
+		{
+			"attributewithoutprofiles",
+			errAttributeWithoutProfiles,
+		},
+		{
+			"negativemaxelapsedtime",
+			errNegativeMaxElapsedTime,
+		},
+		{
+			"unsupportedhmacalgorithm",
+			errUnsupportedHMACAlgorithm,
+		},
+		{
+			"srvwithaddress",
+			errSRVWithAddress,
+		},
+		{
+			"unsupportedjitter",
+			errUnsupportedJitter,
+		},
+		{
+			"negativerequestrate",
+			errNegativeRequestRate,
+		},
+		{
+			"negativerequestburst",
+			errNegativeRequestBurst,
+		},
+		{
+			"invalidlocaladdress",
+			errInvalidLocalAddress,
+		},
+		{
+			"localaddresswithsocket",
+			errLocalAddressWithSocket,
+		},
+		{
+			"negativerevalidate",
+			errNegativeRevalidate,
+		},
+		{
+			"negativemaxdnsretries",
+			errNegativeMaxDNSRetries,
+		},
+		{
+			"negativeretrybudget",
+			errNegativeRetryBudget,
+		},
+		{
+			"negativeretrybudgetburst",
+			errNegativeRetryBudgetBurst,
+		},
+		{
+			"unsupportednotbefore",
+			errUnsupportedNotBefore,
+		},
+		{
+			"negativeclockskew",
+			errNegativeClockSkew,
+		},
+		{
+			"negativemaxrefreshes",
+			errNegativeMaxRefreshes,
+		},
+		{
+			"unsupportedmode",
+			errUnsupportedMode,
+		},
+		{
+			"nobrokerurl",
+			errNoBrokerURL,
+		},
+		{
+			"brokerwithprofiles",
+			errBrokerWithProfiles,
+		},
+		{
+			"negativebrokerttl",
+			errNegativeBrokerTTL,
+		},
+		{
+			"negativestartgrace",
+			errNegativeStartGrace,
+		},
+		{
+			"gracewithoutvalidate",
+			errGraceWithoutValidate,
+		},
+		{
+			"invalidanonymousstatus",
+			errInvalidAnonymousStatus,
+		},
+		{
+			"invalidclaimstemplate",
+			errInvalidClaimsTemplate,
+		},
+		{
+			"reservedsignedclaim",
+			errReservedSignedClaim,
+		},
+		{
+			"invalidprimingurl",
+			errInvalidPrimingURL,
+		},
+		{
+			"negativecircuitwindow",
+			errNegativeCircuitWindow,
+		},
+		{
+			"noinitialinterval",
+			errNoInitialInterval,
+		},
+		{
+			"nobootstrapexpiry",
+			errNoBootstrapExpiry,
+		},
+		{
+			"bootstrapwithvalidate",
+			errBootstrapWithValidate,
+		},
+		{
+			"bindingwithoutcert",
+			errBindingWithoutCert,
+		},
+		{
+			"unsupportedcompression",
+			errUnsupportedCompression,
+		},
+		{
+			"negativeminvalidity",
+			errNegativeMinValidity,
+		},
+		{
+			"negativemaxlifetime",
+			errNegativeMaxLifetime,
+		},
+		{
+			"unsupportedtokenlocation",
+			errUnsupportedTokenLocation,
+		},
+		{
+			"negativemaxcached",
+			errNegativeMaxCached,
+		},
+		{
+			"nomaxstale",
+			errNoMaxStale,
+		},
+		{
+			"negativethreshold",
+			errNegativeThreshold,
+		},
+		{
+			"nocooldown",
+			errNoCooldown,
+		},
+		{
+			"unsupportedgrant",
+			errUnsupportedGrantType,
+		},
+		{
+			"missingsamlassertion",
+			errNoSAMLAssertionFile,
+		},
 	}
 	for _, tt := range tests {
 		factory := NewFactory()
@@ -0,0 +1,73 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileCredentials are the settings that can be provided by the credentials file.
+// Being a superset of JSON, YAML parsing handles both formats.
+type fileCredentials struct {
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	TokenURL     string   `yaml:"token_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// loadCredentialsFile merges the settings of the credentials file into the client credentials configuration,
+// the ones set in the file taking precedence.
+func (o *ClientCredentialsAuthenticator) loadCredentialsFile() error {
+	content, err := ioutil.ReadFile(o.credentialsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var creds fileCredentials
+	if err = yaml.UnmarshalStrict(content, &creds); err != nil {
+		return fmt.Errorf("failed to parse credentials file %s: %w", o.credentialsFile, err)
+	}
+
+	merged := Config{
+		ClientID:     o.clientCredentials.ClientID,
+		ClientSecret: o.clientCredentials.ClientSecret,
+		TokenURL:     o.clientCredentials.TokenURL,
+		Scopes:       o.clientCredentials.Scopes,
+		GrantType:    o.grantType,
+	}
+	if creds.ClientID != "" {
+		merged.ClientID = creds.ClientID
+	}
+	if creds.ClientSecret != "" {
+		merged.ClientSecret = creds.ClientSecret
+	}
+	if creds.TokenURL != "" {
+		merged.TokenURL = creds.TokenURL
+	}
+	if len(creds.Scopes) > 0 {
+		merged.Scopes = creds.Scopes
+	}
+	if err = merged.validateCredentials(); err != nil {
+		return fmt.Errorf("invalid credentials file %s: %w", o.credentialsFile, err)
+	}
+
+	o.clientCredentials.ClientID = merged.ClientID
+	o.clientCredentials.ClientSecret = merged.ClientSecret
+	o.clientCredentials.TokenURL = merged.TokenURL
+	o.clientCredentials.Scopes = merged.Scopes
+	return nil
+}
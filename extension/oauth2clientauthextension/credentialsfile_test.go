@@ -0,0 +1,154 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	tests := []struct {
+		name           string
+		settings       *Config
+		content        string
+		expectedID     string
+		expectedSecret string
+		expectedURL    string
+		expectedScopes []string
+		expectedErr    error
+		shouldError    bool
+	}{
+		{
+			name:           "json_file",
+			settings:       &Config{},
+			content:        `{"client_id": "fileid", "client_secret": "filesecret", "token_url": "https://example.com/v1/token", "scopes": ["resource.read"]}`,
+			expectedID:     "fileid",
+			expectedSecret: "filesecret",
+			expectedURL:    "https://example.com/v1/token",
+			expectedScopes: []string{"resource.read"},
+		},
+		{
+			name:     "yaml_file",
+			settings: &Config{},
+			content: `client_id: fileid
+client_secret: filesecret
+token_url: https://example.com/v1/token
+scopes: [resource.read, resource.write]
+`,
+			expectedID:     "fileid",
+			expectedSecret: "filesecret",
+			expectedURL:    "https://example.com/v1/token",
+			expectedScopes: []string{"resource.read", "resource.write"},
+		},
+		{
+			name: "file_overrides_inline_settings",
+			settings: &Config{
+				ClientID: "inlineid",
+				TokenURL: "https://inline.example.com/v1/token",
+				Scopes:   []string{"inline.scope"},
+			},
+			content:        `{"client_id": "fileid", "client_secret": "filesecret"}`,
+			expectedID:     "fileid",
+			expectedSecret: "filesecret",
+			expectedURL:    "https://inline.example.com/v1/token",
+			expectedScopes: []string{"inline.scope"},
+		},
+		{
+			name:        "missing_token_url",
+			settings:    &Config{},
+			content:     `{"client_id": "fileid", "client_secret": "filesecret"}`,
+			expectedErr: errNoTokenURLProvided,
+			shouldError: true,
+		},
+		{
+			name:        "unknown_field",
+			settings:    &Config{},
+			content:     `{"client_id": "fileid", "client_secret": "filesecret", "token_uri": "https://example.com/v1/token"}`,
+			shouldError: true,
+		},
+		{
+			name:        "malformed_content",
+			settings:    &Config{},
+			content:     `{"client_id": "fileid",`,
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			credentialsFile := filepath.Join(t.TempDir(), "credentials")
+			require.NoError(t, ioutil.WriteFile(credentialsFile, []byte(test.content), 0600))
+			test.settings.CredentialsFile = credentialsFile
+
+			oauth2Authenticator, err := newClientCredentialsExtension(test.settings, zap.NewNop())
+			require.NoError(t, err)
+
+			err = oauth2Authenticator.Start(context.Background(), nil)
+			if test.shouldError {
+				assert.Error(t, err)
+				if test.expectedErr != nil {
+					assert.ErrorIs(t, err, test.expectedErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedID, oauth2Authenticator.clientCredentials.ClientID)
+			assert.Equal(t, test.expectedSecret, oauth2Authenticator.clientCredentials.ClientSecret)
+			assert.Equal(t, test.expectedURL, oauth2Authenticator.clientCredentials.TokenURL)
+			assert.Equal(t, test.expectedScopes, oauth2Authenticator.clientCredentials.Scopes)
+		})
+	}
+}
+
+func TestCredentialsFileUsedForTokenRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "fileid", clientID)
+		assert.Equal(t, "filesecret", clientSecret)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, ioutil.WriteFile(credentialsFile,
+		[]byte(`{"client_id": "fileid", "client_secret": "filesecret", "token_url": "`+server.URL+`"}`), 0600))
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{CredentialsFile: credentialsFile}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+}
+
+func TestCredentialsFileMissing(t *testing.T) {
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		CredentialsFile: filepath.Join(t.TempDir(), "missing.json"),
+	}, zap.NewNop())
+	require.NoError(t, err)
+	assert.Error(t, oauth2Authenticator.Start(context.Background(), nil))
+}
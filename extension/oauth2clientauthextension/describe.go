@@ -0,0 +1,155 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/tls"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"golang.org/x/oauth2"
+)
+
+const redacted = "[REDACTED]"
+
+// Auth styles of Description, how the client credentials are sent to the authorization server.
+const (
+	authStyleAutoDetect = "auto_detect"
+	authStyleInHeader   = "in_header"
+	authStyleInParams   = "in_params"
+)
+
+// Description is a snapshot of the grant the extension is currently configured with, for diagnostics, e.g. to be
+// attached to support tickets. Secrets are redacted.
+type Description struct {
+	// GrantType is the flow tokens are obtained with.
+	GrantType string
+	// TokenURL is the token endpoint, without user information.
+	TokenURL string
+	// ClientID is the client identifier.
+	ClientID string
+	// ClientSecret is redacted, it is empty when no client secret is configured.
+	ClientSecret string
+	// Scopes are the scopes requested, including the default scopes.
+	Scopes []string
+	// AuthStyle is how the client credentials are sent: "auto_detect", "in_header" or "in_params".
+	AuthStyle string
+	// MutualTLS tells whether a client certificate is presented to the authorization server.
+	MutualTLS bool
+	// Profiles are the names of the token profiles, sorted.
+	Profiles []string
+	// LastRefresh is the outcome of the latest token request, nil when no token was requested yet.
+	LastRefresh *RefreshStatus
+}
+
+// RefreshStatus is the outcome of a token request. Like audit entries, it never holds the token.
+type RefreshStatus struct {
+	Time      time.Time
+	Profile   string
+	Succeeded bool
+	// ErrorCode is the OAuth2 error code of a failed request, or the code of FailedToGetSecurityTokenError.
+	ErrorCode string
+}
+
+// Describe returns a snapshot of the grant the extension is currently configured with, and of the outcome of its
+// latest token request.
+func (o *ClientCredentialsAuthenticator) Describe() Description {
+	o.mu.RLock()
+	conf := o.clientCredentials
+	if o.clientIDField != "" || o.clientSecretField != "" {
+		conf = withCredentialFields(conf, o.clientIDField, o.clientSecretField)
+	}
+	description := Description{
+		GrantType: o.grantType,
+		TokenURL:  redactURL(conf.TokenURL),
+		ClientID:  o.clientCredentials.ClientID,
+		Scopes:    mergeScopes(o.defaultScopes, conf.Scopes),
+		AuthStyle: authStyleName(conf.AuthStyle),
+		MutualTLS: o.mutualTLS,
+	}
+	if description.GrantType == "" {
+		description.GrantType = grantTypeClientCredentials
+	}
+	if o.clientCredentials.ClientSecret != "" {
+		description.ClientSecret = redacted
+	}
+	for name := range o.profiles {
+		description.Profiles = append(description.Profiles, name)
+	}
+	o.mu.RUnlock()
+
+	sort.Strings(description.Profiles)
+	description.LastRefresh = o.lastRefresh.get()
+	return description
+}
+
+// presentsClientCertificate reports whether connections made with tlsCfg present a client certificate.
+func presentsClientCertificate(tlsCfg *tls.Config) bool {
+	return tlsCfg != nil && (len(tlsCfg.Certificates) > 0 || tlsCfg.GetClientCertificate != nil)
+}
+
+func authStyleName(style oauth2.AuthStyle) string {
+	switch style {
+	case oauth2.AuthStyleInHeader:
+		return authStyleInHeader
+	case oauth2.AuthStyleInParams:
+		return authStyleInParams
+	default:
+		return authStyleAutoDetect
+	}
+}
+
+// lastRefresh holds the outcome of the latest token request of any profile.
+type lastRefresh struct {
+	mu     sync.Mutex
+	status *RefreshStatus
+}
+
+func (l *lastRefresh) set(status RefreshStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = &status
+}
+
+func (l *lastRefresh) get() *RefreshStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.status == nil {
+		return nil
+	}
+	status := *l.status
+	return &status
+}
+
+// lastRefreshTokenSource records the outcome of every token request.
+type lastRefreshTokenSource struct {
+	ts      oauth2.TokenSource
+	last    *lastRefresh
+	id      config.ComponentID
+	profile string
+	now     func() time.Time
+}
+
+func (s *lastRefreshTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	status := RefreshStatus{Time: s.now(), Profile: s.profile, Succeeded: err == nil}
+	if err != nil {
+		status.ErrorCode = (&FailedToGetSecurityTokenError{id: s.id, err: err}).ErrorCode()
+	}
+	s.last.set(status)
+	return token, err
+}
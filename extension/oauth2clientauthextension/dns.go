@@ -0,0 +1,101 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// hostResolver resolves host names, like net.Resolver.
+type hostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsRetryDialer resolves the host of the addresses it dials, retrying up to retries times when the resolution fails,
+// e.g. with NXDOMAIN while the DNS records of the authorization server are being failed over. The resolved addresses
+// are dialed in turn until a connection is established.
+type dnsRetryDialer struct {
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	resolver hostResolver
+	retries  int
+	interval time.Duration
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+func newDNSRetryDialer(dial func(ctx context.Context, network, address string) (net.Conn, error), retries int, interval time.Duration) *dnsRetryDialer {
+	return &dnsRetryDialer{
+		dial:     dial,
+		resolver: net.DefaultResolver,
+		retries:  retries,
+		interval: interval,
+		sleep:    sleep,
+	}
+}
+
+func (d *dnsRetryDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil || !strings.HasPrefix(network, "tcp") {
+		return d.dial(ctx, network, address)
+	}
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var firstErr error
+	for _, addr := range addrs {
+		ipv4 := addr.IP.To4() != nil
+		if (network == dialNetworkTCP4 && !ipv4) || (network == dialNetworkTCP6 && ipv4) {
+			continue
+		}
+		conn, err := d.dial(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, firstErr
+}
+
+// resolve returns the addresses of host, the last DNS error being reported along with the number of attempts when
+// the host can't be resolved.
+func (d *dnsRetryDialer) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	for attempt := 1; ; attempt++ {
+		addrs, err := d.resolver.LookupIPAddr(ctx, host)
+		if err == nil {
+			return addrs, nil
+		}
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || attempt > d.retries || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to resolve the token endpoint host after %d attempts: %w", attempt, err)
+		}
+		if err = d.sleep(ctx, d.interval); err != nil {
+			return nil, err
+		}
+	}
+}
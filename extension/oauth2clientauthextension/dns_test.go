@@ -0,0 +1,128 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubHostResolver fails the first lookups with NXDOMAIN, then resolves every host to the given addresses.
+type stubHostResolver struct {
+	failures int
+	addrs    []net.IPAddr
+	lookups  int
+}
+
+func (r *stubHostResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	if r.lookups <= r.failures {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return r.addrs, nil
+}
+
+func TestDNSRetryDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		assert.NoError(t, err)
+		// the connection is established with the resolved address, the host name is kept in the request
+		assert.Equal(t, "auth.example.com", host)
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		failures        int
+		expectedLookups int
+		shouldError     bool
+	}{
+		{
+			name:            "resolved",
+			expectedLookups: 1,
+		},
+		{
+			name:            "nxdomain_then_resolved",
+			failures:        2,
+			expectedLookups: 3,
+		},
+		{
+			name:            "nxdomain_beyond_retries",
+			failures:        3,
+			expectedLookups: 3,
+			shouldError:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &stubHostResolver{
+				failures: test.failures,
+				// the first address doesn't accept connections, the next one is dialed
+				addrs: []net.IPAddr{{IP: net.ParseIP("::1")}, {IP: net.ParseIP("127.0.0.1")}},
+			}
+			var waits []time.Duration
+			dialer := newDNSRetryDialer((&net.Dialer{}).DialContext, 2, 100*time.Millisecond)
+			dialer.resolver = resolver
+			dialer.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+
+			resp, err := client.Get("http://" + net.JoinHostPort("auth.example.com", port) + "/v1/token")
+			assert.Equal(t, test.expectedLookups, resolver.lookups)
+			assert.Len(t, waits, test.expectedLookups-1)
+			if test.shouldError {
+				require.Error(t, err)
+				var dnsErr *net.DNSError
+				assert.True(t, errors.As(err, &dnsErr))
+				assert.Contains(t, err.Error(), "failed to resolve the token endpoint host after 3 attempts")
+				assert.Contains(t, err.Error(), "lookup auth.example.com: no such host")
+				// an unknown host isn't a temporary DNS failure
+				assert.False(t, (&FailedToGetSecurityTokenError{err: err}).Temporary())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+		})
+	}
+}
+
+func TestDNSRetryDialerKeepsIPAddresses(t *testing.T) {
+	resolver := &stubHostResolver{}
+	var dialed []string
+	dialer := newDNSRetryDialer(func(_ context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return nil, errors.New("connection refused")
+	}, 2, time.Millisecond)
+	dialer.resolver = resolver
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "10.0.0.5:443")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"10.0.0.5:443"}, dialed)
+	assert.Zero(t, resolver.lookups)
+}
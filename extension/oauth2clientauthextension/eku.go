@@ -0,0 +1,55 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var errMissingServerAuthEKU = errors.New("tls: the certificate of the authorization server doesn't have the server authentication extended key usage")
+
+// withServerAuthEKU makes tlsCfg reject the certificates of the servers that don't list the TLS server authentication
+// extended key usage. crypto/tls accepts certificates without the extended key usage extension, as per RFC 5280.
+func withServerAuthEKU(tlsCfg *tls.Config) {
+	verify := tlsCfg.VerifyPeerCertificate
+	tlsCfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: server didn't provide a certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("tls: failed to parse the certificate of the authorization server: %w", err)
+		}
+		if !hasServerAuthEKU(cert) {
+			return fmt.Errorf("%w: %s", errMissingServerAuthEKU, cert.Subject)
+		}
+		if verify != nil {
+			return verify(rawCerts, verifiedChains)
+		}
+		return nil
+	}
+}
+
+func hasServerAuthEKU(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			return true
+		}
+	}
+	return false
+}
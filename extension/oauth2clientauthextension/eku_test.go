@@ -0,0 +1,123 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+)
+
+// newSelfSignedServerCert returns a self-signed certificate for 127.0.0.1 with the given extended key usages, PEM
+// encoded, along with the certificate and its key.
+func newSelfSignedServerCert(t *testing.T, extKeyUsage []x509.ExtKeyUsage) ([]byte, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  extKeyUsage,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestRequireServerAuthEKU(t *testing.T) {
+	tests := []struct {
+		name        string
+		extKeyUsage []x509.ExtKeyUsage
+		requireEKU  bool
+		expectedErr error
+	}{
+		{
+			name:        "with_eku",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			requireEKU:  true,
+		},
+		{
+			name:        "with_several_ekus",
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+			requireEKU:  true,
+		},
+		{
+			name:        "without_eku",
+			requireEKU:  true,
+			expectedErr: errMissingServerAuthEKU,
+		},
+		{
+			name: "without_eku_not_required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			caPEM, cert := newSelfSignedServerCert(t, test.extKeyUsage)
+			caFile := filepath.Join(t.TempDir(), "ca.pem")
+			require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			server.StartTLS()
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL + "/v1/token",
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: caFile},
+					},
+					RequireServerAuthEKU: test.requireEKU,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
@@ -16,10 +16,18 @@ package oauth2clientauthextension // import "github.com/open-telemetry/opentelem
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/config/configauth"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
@@ -30,73 +38,799 @@ import (
 // ClientCredentialsAuthenticator provides implementation for providing client authentication using OAuth2 client credentials
 // workflow for both gRPC and HTTP clients.
 type ClientCredentialsAuthenticator struct {
-	clientCredentials *clientcredentials.Config
+	id                config.ComponentID
 	logger            *zap.Logger
+	contextDecorators []ContextDecorator
+	onSuccess         []func(TokenAcquisition)
+	onFailure         []func(error)
+	maxCachedSources  int
+	profileAttr       string
+	tokenLocation     string
+	failOpen          bool
+	logTokenMasked    bool
+	insecure          []insecureOption
+	anonymousStatuses map[int]bool
+	verifyAudience    bool
+	issued            *issuedTokens
+	audit             *auditLog
+	fetchEvents       *fetchEvents
+	lastRefresh       *lastRefresh
+	tracer            trace.Tracer
+	maxConcurrent     int
+	onLimit           string
+	schedule          refreshSchedule
+	stopSchedule      chan struct{}
+	scheduleDone      chan struct{}
+	// cachedSources is the number of token sources cached for the profiles, by all the exporters.
+	cachedSources int32
+	// cfg is the configuration the extension was created with, for Reload to reject the changes it can't apply.
+	cfg *Config
+	// lifetime is cancelled on shutdown, cancelling the token requests in flight.
+	lifetime    context.Context
+	endLifetime context.CancelFunc
+
+	// mu guards the settings below, which are replaced by Reload.
+	mu                sync.RWMutex
+	generation        uint64
+	clientCredentials *clientcredentials.Config
+	clientIDField     string
+	clientSecretField string
+	clientIDInBody    bool
+	defaultScopes     []string
+	requiredScopes    []string
+	protocolScopes    map[string][]string
+	profiles          map[string]TokenProfile
+	validateOnStart   bool
+	startGrace        time.Duration
+	startRetry        RetrySettings
+	bootstrapEnv      string
+	bootstrapExpiry   time.Duration
+	bootstrapUntil    time.Time
+	warmTokens        map[string]*oauth2.Token
+	grpcMetadata      map[string]string
+	mode              string
+	brokerURL         string
+	brokerTokenTTL    time.Duration
+	grantType         string
+	samlAssertionFile string
+	subjectTokenFile  string
+	credentialsFile   string
+	disableRefresh    bool
+	minValidity       time.Duration
+	revalidateWindow  time.Duration
+	maxLifetime       time.Duration
+	maxRefreshes      int
+	notBefore         string
+	notBeforeSkew     time.Duration
+	certThumbprint    string
+	mutualTLS         bool
+	revocationURL     string
+	maxStale          time.Duration
+	honorCacheHeaders bool
 	client            *http.Client
+	breaker           *circuitBreaker
+	signer            *requestSigner
+	srvResolver       srvResolver
+	// with reuse_base_transport_tls, newClient builds the client for the TLS configuration of the base transport of
+	// the first exporter, baseTLS, that exposes one.
+	reuseBaseTLS bool
+	newClient    func(tlsCfg *tls.Config) *http.Client
+	baseTLS      *tls.Config
 }
 
 // ClientCredentialsAuthenticator implements ClientAuthenticator
 var _ configauth.ClientAuthenticator = (*ClientCredentialsAuthenticator)(nil)
 
-func newClientCredentialsExtension(cfg *Config, logger *zap.Logger) (*ClientCredentialsAuthenticator, error) {
-	if cfg.ClientID == "" {
-		return nil, errNoClientIDProvided
-	}
-	if cfg.ClientSecret == "" {
-		return nil, errNoClientSecretProvided
-	}
-	if cfg.TokenURL == "" {
-		return nil, errNoTokenURLProvided
+func newClientCredentialsExtension(cfg *Config, logger *zap.Logger, opts ...Option) (*ClientCredentialsAuthenticator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-
-	tlsCfg, err := cfg.TLSSetting.LoadTLSConfig()
+	tlsCfg, err := cfg.TLSSetting.loadTLSConfig()
 	if err != nil {
 		return nil, err
 	}
-	transport.TLSClientConfig = tlsCfg
+	// the client may be rebuilt with the TLS configuration of an exporter, from a copy of cfg left unchanged by the caller
+	clientCfg := *cfg
 
-	return &ClientCredentialsAuthenticator{
+	o := &ClientCredentialsAuthenticator{
+		id:  cfg.ID(),
+		cfg: &clientCfg,
 		clientCredentials: &clientcredentials.Config{
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
 			TokenURL:     cfg.TokenURL,
 			Scopes:       cfg.Scopes,
 		},
-		logger: logger,
-		client: &http.Client{
-			Transport: transport,
-			Timeout:   cfg.Timeout,
-		},
-	}, nil
+		clientIDField:     cfg.ClientIDField,
+		clientSecretField: cfg.ClientSecretField,
+		clientIDInBody:    cfg.AlwaysSendClientIDInBody,
+		defaultScopes:     cfg.DefaultScopes,
+		requiredScopes:    cfg.RequiredScopes,
+		protocolScopes:    protocolScopes(cfg),
+		profiles:          cfg.Profiles,
+		validateOnStart:   cfg.ValidateOnStart,
+		startGrace:        cfg.StartGracePeriod,
+		startRetry:        cfg.Retry,
+		bootstrapEnv:      cfg.BootstrapAccessTokenEnv,
+		bootstrapExpiry:   cfg.BootstrapExpiry,
+		maxCachedSources:  cfg.MaxCachedTokenSources,
+		profileAttr:       cfg.ProfileAttribute,
+		tokenLocation:     cfg.TokenLocation,
+		failOpen:          cfg.FailOpen,
+		logTokenMasked:    cfg.LogTokenMasked,
+		insecure:          insecureOptions(cfg),
+		anonymousStatuses: anonymousStatuses(cfg.AnonymousOnStatus),
+		verifyAudience:    cfg.VerifyAudienceAgainstHost,
+		grpcMetadata:      normalizeMetadata(cfg.GRPCMetadata),
+		mode:              cfg.Mode,
+		brokerURL:         cfg.BrokerURL,
+		brokerTokenTTL:    cfg.BrokerTokenTTL,
+		grantType:         cfg.GrantType,
+		samlAssertionFile: cfg.SAMLAssertionFile,
+		subjectTokenFile:  cfg.SubjectTokenFile,
+		credentialsFile:   cfg.CredentialsFile,
+		disableRefresh:    cfg.DisableAutoRefresh,
+		minValidity:       cfg.MinRemainingValidity,
+		revalidateWindow:  cfg.StaleWhileRevalidate,
+		maxLifetime:       cfg.MaxTokenLifetime,
+		maxRefreshes:      cfg.MaxRefreshesPerMinute,
+		notBefore:         cfg.NotBefore,
+		notBeforeSkew:     cfg.NotBeforeClockSkew,
+		revocationURL:     cfg.RevocationEndpoint,
+		issued:            newIssuedTokens(),
+		lastRefresh:       &lastRefresh{},
+		mutualTLS:         presentsClientCertificate(tlsCfg),
+		maxConcurrent:     cfg.MaxConcurrentRequests,
+		onLimit:           cfg.OnLimit,
+		honorCacheHeaders: cfg.HonorHTTPCacheHeaders,
+		logger:            logger,
+		reuseBaseTLS:      cfg.ReuseBaseTransportTLS,
+	}
+	o.newClient = func(tlsCfg *tls.Config) *http.Client {
+		return newTokenClient(&clientCfg, tlsCfg, o.srvResolver)
+	}
+	o.lifetime, o.endLifetime = context.WithCancel(context.Background())
+	if cfg.ServeStaleOnRefreshFailure {
+		o.maxStale = cfg.MaxStale
+	}
+	if o.subjectTokenFile == "" {
+		o.subjectTokenFile = defaultSubjectTokenFile
+	}
+	if cfg.AuditLogFile != "" {
+		o.audit = newAuditLog(cfg.AuditLogFile, logger)
+	}
+	if cfg.RefreshSchedule != "" {
+		if o.schedule, err = parseRefreshSchedule(cfg.RefreshSchedule); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.FetchEventHistory > 0 {
+		o.fetchEvents = newFetchEvents(cfg.FetchEventHistory)
+	}
+	if cfg.VerifyCertificateBinding {
+		o.certThumbprint = certificateThumbprint(tlsCfg)
+	}
+	if cfg.CircuitBreaker.FailureThreshold > 0 {
+		o.breaker = newCircuitBreaker(cfg.CircuitBreaker)
+	}
+	if cfg.SignedHeader.KeyFile != "" {
+		if o.signer, err = newRequestSigner(cfg.SignedHeader); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.client = o.newClient(tlsCfg)
+	return o, nil
+}
+
+// newTokenClient returns the client sending the token requests for cfg, connecting to the authorization server
+// with tlsCfg. The SRV record of token_srv is resolved with resolver, net.DefaultResolver when nil.
+func newTokenClient(cfg *Config, tlsCfg *tls.Config, resolver srvResolver) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	if cfg.ForceHTTP1 {
+		// a non-nil empty TLSNextProto disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		transport.TLSClientConfig = withoutHTTP2(tlsCfg)
+	}
+
+	dialNetwork := cfg.DialNetwork
+	if dialNetwork == dialNetworkTCP {
+		dialNetwork = ""
+	}
+	if cfg.DialTimeout > 0 || cfg.TokenEndpointAddress != "" || cfg.TokenUnixSocket != "" || dialNetwork != "" || cfg.LocalAddress != "" ||
+		cfg.Retry.MaxDNSRetries > 0 {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		if cfg.DialTimeout > 0 {
+			dialer.Timeout = cfg.DialTimeout
+		}
+		if cfg.LocalAddress != "" {
+			// validated by Config.Validate, the port is picked by the system
+			dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(cfg.LocalAddress)}
+		}
+		dial := dialer.DialContext
+		if cfg.Retry.MaxDNSRetries > 0 {
+			dial = newDNSRetryDialer(dialer.DialContext, cfg.Retry.MaxDNSRetries, cfg.Retry.InitialInterval).DialContext
+		}
+		transport.DialContext = dial
+		if dialNetwork != "" {
+			transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+				return dial(ctx, dialNetwork, address)
+			}
+		}
+		if address := cfg.TokenEndpointAddress; address != "" {
+			// connections go straight to the given address, the token URL host is still used for TLS verification
+			transport.Proxy = nil
+			transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				if dialNetwork != "" {
+					network = dialNetwork
+				}
+				return dial(ctx, network, address)
+			}
+		}
+		if socket := cfg.TokenUnixSocket; socket != "" {
+			transport.Proxy = nil
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			}
+		}
+	}
+
+	var tokenTransport http.RoundTripper = transport
+	if cfg.TokenSRV != "" {
+		tokenTransport = newSRVRoundTripper(tokenTransport, cfg.TokenSRV, resolver)
+	}
+	if cfg.TokenRequestsPerSecond > 0 {
+		tokenTransport = newRateLimitRoundTripper(tokenTransport, cfg.TokenRequestsPerSecond, cfg.TokenRequestsBurst)
+	}
+	if cfg.Retry.MaxRetries > 0 || cfg.Retry.MaxElapsedTime > 0 {
+		tokenTransport = newRetryRoundTripper(tokenTransport, cfg.Retry)
+	}
+	if cfg.SessionPrimingURL != "" {
+		// below the idempotency key, so that the session priming requests don't carry one
+		tokenTransport = newSessionPrimingRoundTripper(tokenTransport, cfg.SessionPrimingURL)
+	}
+	if cfg.IdempotencyKeyHeader != "" {
+		tokenTransport = &idempotencyKeyRoundTripper{base: tokenTransport, header: cfg.IdempotencyKeyHeader}
+	}
+	if cfg.TokenRequestHost != "" {
+		tokenTransport = &hostRoundTripper{base: tokenTransport, host: cfg.TokenRequestHost}
+	}
+	if cfg.RequestSigning.HMACKey != "" {
+		tokenTransport = newHMACSigningRoundTripper(tokenTransport, cfg.RequestSigning)
+	}
+	if cfg.TokenRequestCompression != "" {
+		tokenTransport = &compressionRoundTripper{base: tokenTransport, encoding: cfg.TokenRequestCompression}
+	}
+	if len(cfg.TokenRequestParameterOrder) > 0 {
+		tokenTransport = &parameterOrderRoundTripper{base: tokenTransport, order: cfg.TokenRequestParameterOrder}
+	}
+	if paths := cfg.ResponseFieldMap.fieldPaths(); len(paths) > 0 {
+		tokenTransport = &fieldMapRoundTripper{base: tokenTransport, paths: paths}
+	}
+	if cfg.PropagateTraceContext || cfg.CorrelationHeader != "" {
+		tokenTransport = &traceContextRoundTripper{
+			base:              tokenTransport,
+			propagate:         cfg.PropagateTraceContext,
+			correlationHeader: cfg.CorrelationHeader,
+		}
+	}
+	tokenTransport = &errorResponseRoundTripper{base: tokenTransport, tlsCfg: tlsCfg}
+	tokenTransport = &acceptJSONRoundTripper{base: tokenTransport}
+	if cfg.HonorHTTPCacheHeaders {
+		tokenTransport = &responseHeadersRoundTripper{base: tokenTransport}
+	}
+	return &http.Client{
+		Transport: tokenTransport,
+		Timeout:   cfg.Timeout,
+	}
+}
+
+// withoutHTTP2 returns a copy of tlsCfg offering the application protocols of tlsCfg but "h2" during the TLS
+// handshake, HTTP/1.1 when there are none left.
+func withoutHTTP2(tlsCfg *tls.Config) *tls.Config {
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{}
+	}
+	tlsCfg = tlsCfg.Clone()
+	var protos []string
+	for _, proto := range tlsCfg.NextProtos {
+		if proto != "h2" {
+			protos = append(protos, proto)
+		}
+	}
+	if len(protos) == 0 {
+		protos = []string{"http/1.1"}
+	}
+	tlsCfg.NextProtos = protos
+	return tlsCfg
 }
 
-// Start for ClientCredentialsAuthenticator extension does nothing
-func (o *ClientCredentialsAuthenticator) Start(_ context.Context, _ component.Host) error {
+// Start for ClientCredentialsAuthenticator extension loads the credentials file, checks that the SAML assertion or
+// the subject token, if any, can be read and, with validate_on_start, that tokens can be obtained, retrying temporary
+// failures for up to start_grace_period. It then starts refreshing the tokens on the refresh_schedule, if any.
+// A warning is logged for each insecure option enabled.
+func (o *ClientCredentialsAuthenticator) Start(ctx context.Context, _ component.Host) error {
+	warnInsecureOptions(o.logger, o.insecure)
+	if err := o.load(ctx, o.startGrace); err != nil {
+		return err
+	}
+	if o.schedule != nil {
+		o.stopSchedule = make(chan struct{})
+		o.scheduleDone = make(chan struct{})
+		go func() {
+			defer close(o.scheduleDone)
+			o.runRefreshSchedule(o.schedule, o.stopSchedule)
+		}()
+	}
+	return nil
+}
+
+// load prepares the configuration of the extension for use, on start and on reload. With validate_on_start, the
+// tokens are fetched again on temporary failures until startGrace elapsed.
+func (o *ClientCredentialsAuthenticator) load(ctx context.Context, startGrace time.Duration) error {
+	if o.credentialsFile != "" {
+		if err := o.loadCredentialsFile(); err != nil {
+			return err
+		}
+	}
+	if o.grantType == grantTypeSAML2Bearer {
+		if _, err := readSAMLAssertion(o.samlAssertionFile); err != nil {
+			return err
+		}
+	}
+	if o.grantType == grantTypeTokenExchange {
+		if _, err := readSubjectToken(o.subjectTokenFile); err != nil {
+			return err
+		}
+	}
+	if o.bootstrapEnv != "" {
+		o.bootstrap()
+	}
+	if o.validateOnStart {
+		return o.warmUpWithin(ctx, startGrace)
+	}
 	return nil
 }
 
-// Shutdown for ClientCredentialsAuthenticator extension does nothing
-func (o *ClientCredentialsAuthenticator) Shutdown(_ context.Context) error {
+// Reload replaces the configuration of the extension, which is left unchanged when the new configuration is invalid,
+// or changes a setting that can't be reloaded, like token_location or refresh_schedule, the error naming that setting.
+// The RoundTripper and PerRPCCredentials already handed out keep working: from their next request on, they drop the
+// tokens obtained with the previous configuration and fetch new ones with the new configuration. Requests in flight
+// complete with the previous configuration. A warning is logged for each insecure option of the new configuration.
+func (o *ClientCredentialsAuthenticator) Reload(cfg *Config) error {
+	if setting := changedNonReloadableSetting(o.cfg, cfg); setting != "" {
+		return fmt.Errorf("%w: %s", errNonReloadableSetting, setting)
+	}
+	reloaded, err := newClientCredentialsExtension(cfg, o.logger, withSRVResolver(o.srvResolver))
+	if err != nil {
+		return err
+	}
+	// only the settings of the new instance are kept, the token requests are bound to the lifetime of o
+	defer reloaded.endLifetime()
+	o.mu.RLock()
+	reloaded.bootstrapUntil = o.bootstrapUntil
+	o.mu.RUnlock()
+	if err = reloaded.load(context.Background(), 0); err != nil {
+		return err
+	}
+	warnInsecureOptions(o.logger, reloaded.insecure)
+
+	o.mu.Lock()
+	var baseTLS *tls.Config
+	if reloaded.reuseBaseTLS && o.baseTLS != nil {
+		if baseTLS, err = baseTransportTLS(o.baseTLS, reloaded.cfg.TLSSetting); err != nil {
+			o.mu.Unlock()
+			return err
+		}
+	}
+	previousClient := o.client
+	o.cfg = reloaded.cfg
+	o.insecure = reloaded.insecure
+	o.clientCredentials = reloaded.clientCredentials
+	o.clientIDField = reloaded.clientIDField
+	o.clientSecretField = reloaded.clientSecretField
+	o.clientIDInBody = reloaded.clientIDInBody
+	o.defaultScopes = reloaded.defaultScopes
+	o.requiredScopes = reloaded.requiredScopes
+	o.protocolScopes = reloaded.protocolScopes
+	o.profiles = reloaded.profiles
+	o.validateOnStart = reloaded.validateOnStart
+	o.bootstrapEnv = reloaded.bootstrapEnv
+	o.bootstrapExpiry = reloaded.bootstrapExpiry
+	o.bootstrapUntil = reloaded.bootstrapUntil
+	o.warmTokens = reloaded.warmTokens
+	o.grpcMetadata = reloaded.grpcMetadata
+	o.mode = reloaded.mode
+	o.brokerURL = reloaded.brokerURL
+	o.brokerTokenTTL = reloaded.brokerTokenTTL
+	o.grantType = reloaded.grantType
+	o.samlAssertionFile = reloaded.samlAssertionFile
+	o.subjectTokenFile = reloaded.subjectTokenFile
+	o.credentialsFile = reloaded.credentialsFile
+	o.disableRefresh = reloaded.disableRefresh
+	o.minValidity = reloaded.minValidity
+	o.revalidateWindow = reloaded.revalidateWindow
+	o.maxLifetime = reloaded.maxLifetime
+	o.maxRefreshes = reloaded.maxRefreshes
+	o.notBefore = reloaded.notBefore
+	o.notBeforeSkew = reloaded.notBeforeSkew
+	o.certThumbprint = reloaded.certThumbprint
+	o.mutualTLS = reloaded.mutualTLS
+	o.revocationURL = reloaded.revocationURL
+	o.maxStale = reloaded.maxStale
+	o.honorCacheHeaders = reloaded.honorCacheHeaders
+	o.client = reloaded.client
+	o.reuseBaseTLS = reloaded.reuseBaseTLS
+	o.newClient = reloaded.newClient
+	if o.reuseBaseTLS && o.baseTLS != nil {
+		// the exporters keep their TLS configuration
+		o.client = o.newClient(baseTLS)
+		o.mutualTLS = presentsClientCertificate(baseTLS)
+	}
+	o.breaker = reloaded.breaker
+	o.generation++
+	o.mu.Unlock()
+
+	previousClient.CloseIdleConnections()
 	return nil
 }
 
+// Shutdown for ClientCredentialsAuthenticator extension cancels the token requests in flight and stops the scheduled
+// refreshes, waiting for the refresh in progress, if any, to return until ctx is done. It then revokes the tokens that
+// haven't expired yet when a revocation endpoint is configured, and closes the audit log. Revocation failures are logged
+// and don't fail the shutdown. When ctx is done before the refresh in progress returned, the tokens are still revoked
+// and the audit log closed, and the error is returned along with the one closing the audit log.
+func (o *ClientCredentialsAuthenticator) Shutdown(ctx context.Context) error {
+	o.endLifetime()
+	var errs error
+	if o.stopSchedule != nil {
+		close(o.stopSchedule)
+		select {
+		case <-o.scheduleDone:
+		case <-ctx.Done():
+			errs = fmt.Errorf("failed to wait for the scheduled refresh to return: %w", ctx.Err())
+		}
+		o.stopSchedule = nil
+	}
+	revocationCtx := ctx
+	if errs != nil {
+		// the revocation is bounded by revocationTimeout
+		revocationCtx = context.Background()
+	}
+	o.revokeTokens(revocationCtx)
+	if o.audit != nil {
+		errs = multierr.Append(errs, o.audit.close())
+	}
+	return errs
+}
+
 // RoundTripper returns oauth2.Transport, an http.RoundTripper that performs "client-credential" OAuth flow and
 // also auto refreshes OAuth tokens as needed.
+// When token profiles are configured, the token of the profile selected by the request context is used.
+// When max_concurrent_requests is set, it bounds the number of requests in flight through the RoundTripper.
+// With reuse_base_transport_tls, the token requests use the TLS configuration of base if it's an *http.Transport.
+// With signed_header, the requests also carry a JWT signed for each of them.
 func (o *ClientCredentialsAuthenticator) RoundTripper(base http.RoundTripper) (http.RoundTripper, error) {
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, o.client)
-	return &oauth2.Transport{
-		Source: o.clientCredentials.TokenSource(ctx),
-		Base:   base,
-	}, nil
+	o.useBaseTransportTLS(base)
+	if o.signer != nil {
+		base = &signedHeaderRoundTripper{base: base, signer: o.signer, tokenInQuery: o.tokenLocation == tokenLocationQuery}
+	}
+	var rt http.RoundTripper
+	if o.hasProfiles() {
+		rt = &profileRoundTripper{sources: newProfileTokenSources(o, o.maxCachedSources, protocolHTTP), base: base}
+	} else {
+		rt = o.tokenTransport(o.protocolTokenSource(protocolHTTP, ""), base)
+	}
+	if o.maxConcurrent > 0 {
+		rt = newLimitRoundTripper(rt, o.maxConcurrent, o.onLimit)
+	}
+	return rt, nil
+}
+
+// useBaseTransportTLS makes the token requests use the TLS configuration of base with reuse_base_transport_tls,
+// once base is an *http.Transport. The TLS configuration of the first such exporter is kept for the next ones.
+func (o *ClientCredentialsAuthenticator) useBaseTransportTLS(base http.RoundTripper) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.reuseBaseTLS || o.baseTLS != nil {
+		return
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		o.logger.Debug("The base transport isn't an *http.Transport, the token requests use the tls settings of the extension")
+		return
+	}
+	exporterTLS := transport.TLSClientConfig.Clone()
+	if exporterTLS == nil {
+		// the transport uses the default TLS configuration
+		exporterTLS = &tls.Config{}
+	}
+	tlsCfg, err := baseTransportTLS(exporterTLS, o.cfg.TLSSetting)
+	if err != nil {
+		o.logger.Warn("Failed to apply the tls settings of the extension to the TLS configuration of the exporter, "+
+			"the token requests use the tls settings of the extension", zap.Error(err))
+		return
+	}
+	o.baseTLS = exporterTLS
+	previousClient := o.client
+	o.client = o.newClient(tlsCfg)
+	o.mutualTLS = presentsClientCertificate(tlsCfg)
+	// the token sources in use are rebuilt with the new client
+	o.generation++
+	previousClient.CloseIdleConnections()
+}
+
+// baseTransportTLS returns the TLS configuration of the token requests with reuse_base_transport_tls: the one of the
+// exporter, without its server name, which is the one of the backend of the exporter, and with the tls settings
+// specific to the authorization server, like intermediates_file or require_server_auth_eku, applied on top of it.
+func baseTransportTLS(exporterTLS *tls.Config, settings TLSClientSetting) (*tls.Config, error) {
+	tlsCfg := exporterTLS.Clone()
+	tlsCfg.ServerName = ""
+	if err := settings.applyTo(tlsCfg); err != nil {
+		return nil, err
+	}
+	return tlsCfg, nil
+}
+
+// tokenTransport returns an http.RoundTripper authorizing requests with the tokens of ts, at the configured location.
+// With verify_audience_against_host, requests to a host the token isn't issued for fail.
+// With fail_open, requests are sent without authorization when no token can be obtained.
+// With anonymous_on_status, requests getting one of its status codes are sent again without authorization.
+func (o *ClientCredentialsAuthenticator) tokenTransport(ts oauth2.TokenSource, base http.RoundTripper) http.RoundTripper {
+	if o.tracer != nil {
+		base = &tokenObtainedRoundTripper{base: base}
+	}
+	var rt http.RoundTripper
+	if o.tokenLocation == tokenLocationQuery {
+		rt = &queryTokenRoundTripper{source: ts, base: base}
+	} else {
+		rt = &oauth2.Transport{
+			Source: ts,
+			Base:   base,
+		}
+	}
+	if o.verifyAudience {
+		rt = &audienceRoundTripper{source: ts, base: rt}
+	}
+	if o.failOpen {
+		rt = &failOpenRoundTripper{source: ts, authorized: rt, base: base, logger: o.logger}
+	}
+	if len(o.anonymousStatuses) > 0 {
+		rt = &anonymousRoundTripper{authorized: rt, base: base, statuses: o.anonymousStatuses, logger: o.logger}
+	}
+	if triggers := refreshTriggersOf(ts); triggers != nil {
+		// the spans of the token requests of ts are linked to the spans of the requests waiting for them
+		rt = &triggerRoundTripper{base: rt, triggers: triggers, now: time.Now}
+	}
+	return rt
 }
 
 // PerRPCCredentials returns gRPC PerRPCCredentials that supports "client-credential" OAuth flow. The underneath
 // oauth2.clientcredentials.Config instance will manage tokens performing auto refresh as necessary.
+// When token profiles are configured, the token of the profile selected by the RPC context is used.
+// When static gRPC metadata is configured, it is attached alongside the token.
+// With fail_open, RPCs are sent without authorization when no token can be obtained.
 func (o *ClientCredentialsAuthenticator) PerRPCCredentials() (credentials.PerRPCCredentials, error) {
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, o.client)
-	return grpcOAuth.TokenSource{
-		TokenSource: o.clientCredentials.TokenSource(ctx),
-	}, nil
+	var creds credentials.PerRPCCredentials
+	if o.hasProfiles() {
+		creds = &profilePerRPCCredentials{sources: newProfileTokenSources(o, o.maxCachedSources, protocolGRPC)}
+	} else {
+		creds = grpcOAuth.TokenSource{
+			TokenSource: o.protocolTokenSource(protocolGRPC, ""),
+		}
+	}
+	if o.failOpen {
+		creds = &failOpenPerRPCCredentials{PerRPCCredentials: creds, logger: o.logger}
+	}
+	if len(o.staticMetadata()) > 0 {
+		creds = &metadataPerRPCCredentials{PerRPCCredentials: creds, o: o}
+	}
+	return creds, nil
+}
+
+// tokenSource returns a new oauth2.TokenSource for the configured grant and the named profile, the empty name
+// standing for the extension configuration, caching tokens until they expire.
+func (o *ClientCredentialsAuthenticator) tokenSource(profile string) oauth2.TokenSource {
+	return o.protocolTokenSource("", profile)
+}
+
+// protocolTokenSource returns a new oauth2.TokenSource for the exporters of the given protocol, requesting the scopes
+// configured for that protocol, if any.
+func (o *ClientCredentialsAuthenticator) protocolTokenSource(protocol, profile string) oauth2.TokenSource {
+	var triggers *refreshTriggers
+	if o.tracer != nil {
+		triggers = newRefreshTriggers()
+	}
+	return &errorWrappingTokenSource{
+		ts:        &reloadingTokenSource{o: o, profile: profile, protocol: protocol, triggers: triggers},
+		id:        o.id,
+		profile:   profile,
+		logger:    o.logger,
+		logMasked: o.logTokenMasked,
+	}
+}
+
+// hasProfiles reports whether token profiles are configured.
+func (o *ClientCredentialsAuthenticator) hasProfiles() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.profiles) > 0
+}
+
+// currentGeneration returns the number of times the configuration was reloaded.
+func (o *ClientCredentialsAuthenticator) currentGeneration() uint64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.generation
+}
+
+// Protocols of the exporters, whose tokens may be requested with scopes of their own.
+const (
+	protocolHTTP = "http"
+	protocolGRPC = "grpc"
+)
+
+// protocolScopes returns the scopes replacing the scopes of cfg for the exporters of each protocol.
+func protocolScopes(cfg *Config) map[string][]string {
+	scopes := map[string][]string{}
+	if len(cfg.HTTPScopes) > 0 {
+		scopes[protocolHTTP] = cfg.HTTPScopes
+	}
+	if len(cfg.GRPCScopes) > 0 {
+		scopes[protocolGRPC] = cfg.GRPCScopes
+	}
+	return scopes
+}
+
+// newTokenSource returns a token source of the named profile for the exporters of the given protocol and the current
+// configuration, along with the generation of that configuration.
+func (o *ClientCredentialsAuthenticator) newTokenSource(profile, protocol string, triggers *refreshTriggers) (oauth2.TokenSource, uint64, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	base := o.clientCredentials
+	scopes, protocolScoped := o.protocolScopes[protocol]
+	if protocolScoped {
+		withScopes := *base
+		withScopes.Scopes = scopes
+		base = &withScopes
+	}
+	conf, err := o.profileConfig(base, profile)
+	if err != nil {
+		return nil, o.generation, err
+	}
+	if len(o.defaultScopes) > 0 {
+		withDefaults := *conf
+		withDefaults.Scopes = mergeScopes(o.defaultScopes, conf.Scopes)
+		conf = &withDefaults
+	}
+	if o.clientIDInBody {
+		conf = withClientIDInBody(conf)
+	}
+	if o.clientIDField != "" || o.clientSecretField != "" {
+		conf = withCredentialFields(conf, o.clientIDField, o.clientSecretField)
+	}
+
+	ctx := context.WithValue(o.lifetime, oauth2.HTTPClient, o.client)
+	// the retry backoff is kept across the token requests of this token source
+	ctx = context.WithValue(ctx, retryBackoffKey{}, &retryBackoff{})
+	for _, decorate := range o.contextDecorators {
+		ctx = decorate(ctx)
+	}
+	span := &refreshSpan{}
+	ctx = context.WithValue(ctx, refreshSpanKey{}, span)
+	var headers *responseHeaders
+	if o.honorCacheHeaders {
+		headers = &responseHeaders{}
+		ctx = context.WithValue(ctx, responseHeadersKey{}, headers)
+	}
+	var ts oauth2.TokenSource
+	if o.mode == modeBroker {
+		ttl := o.brokerTokenTTL
+		if ttl == 0 {
+			ttl = defaultBrokerTokenTTL
+		}
+		ts = &brokerTokenSource{ctx: ctx, url: o.brokerURL, ttl: ttl, now: time.Now}
+	} else if o.grantType == grantTypeSAML2Bearer {
+		ts = &samlBearerTokenSource{
+			ctx:           ctx,
+			conf:          conf,
+			assertionFile: o.samlAssertionFile,
+		}
+	} else if o.grantType == grantTypeTokenExchange {
+		ts = &tokenExchangeTokenSource{
+			ctx:              ctx,
+			conf:             conf,
+			subjectTokenFile: o.subjectTokenFile,
+		}
+	} else {
+		ts, err = grantHandler(o.grantType).TokenSource(conf, contextClient(ctx))
+		if err != nil {
+			return nil, o.generation, err
+		}
+	}
+
+	ts = &nonEmptyTokenSource{ts: ts}
+	if o.notBefore != "" {
+		ts = &notBeforeTokenSource{
+			ts:        ts,
+			ctx:       o.lifetime,
+			mode:      o.notBefore,
+			clockSkew: o.notBeforeSkew,
+			now:       time.Now,
+			sleep:     sleep,
+			logger:    o.logger,
+		}
+	}
+	if o.certThumbprint != "" {
+		ts = &certificateBoundTokenSource{ts: ts, thumbprint: o.certThumbprint}
+	}
+	if len(o.requiredScopes) > 0 {
+		ts = &requiredScopesTokenSource{ts: ts, required: o.requiredScopes, requested: conf.Scopes}
+	}
+	if o.maxLifetime > 0 {
+		ts = &maxLifetimeTokenSource{ts: ts, maxLifetime: o.maxLifetime, now: time.Now, logger: o.logger}
+	}
+	if o.maxRefreshes > 0 {
+		ts = &refreshGuardTokenSource{ts: ts, maxRefreshes: o.maxRefreshes, now: time.Now}
+	}
+	if o.revocationURL != "" {
+		ts = &trackingTokenSource{ts: ts, issued: o.issued}
+	}
+
+	if o.breaker != nil {
+		ts = &circuitBreakerTokenSource{ts: ts, breaker: o.breaker}
+	}
+	if headers != nil {
+		ts = &cacheHeadersTokenSource{ts: ts, headers: headers, logger: o.logger}
+	}
+	if len(o.onSuccess) > 0 || len(o.onFailure) > 0 {
+		ts = &callbackTokenSource{
+			ts:        ts,
+			id:        o.id,
+			profile:   profile,
+			scopes:    conf.Scopes,
+			onSuccess: o.onSuccess,
+			onFailure: o.onFailure,
+		}
+	}
+
+	if o.audit != nil {
+		ts = &auditTokenSource{ts: ts, log: o.audit, id: o.id, profile: profile, tokenURL: conf.TokenURL}
+	}
+	ts = &lastRefreshTokenSource{ts: ts, last: o.lastRefresh, id: o.id, profile: profile, now: time.Now}
+	if o.fetchEvents != nil {
+		ts = &fetchEventsTokenSource{ts: ts, events: o.fetchEvents, id: o.id, profile: profile, now: time.Now}
+	}
+	if o.tracer != nil {
+		ts = &tracingTokenSource{ts: ts, tracer: o.tracer, triggers: triggers, span: span, id: o.id, profile: profile}
+	}
+
+	warm := o.warmTokens[profile]
+	if protocolScoped {
+		// the warm tokens are obtained with the scopes shared by the protocols
+		warm = nil
+	}
+	if o.disableRefresh {
+		ts = &singleTokenSource{ts: ts, token: warm, seeded: warm != nil}
+	} else if o.revalidateWindow > 0 {
+		ts = &revalidatingTokenSource{ts: ts, minValidity: o.minValidity, window: o.revalidateWindow, now: time.Now,
+			logger: o.logger, lifetime: o.lifetime, token: warm}
+	} else if o.minValidity > 0 {
+		ts = &minValidityTokenSource{ts: ts, minValidity: o.minValidity, now: time.Now, token: warm}
+	} else {
+		ts = newReuseTokenSource(warm, ts)
+	}
+	if o.maxStale > 0 && !o.disableRefresh {
+		ts = &staleTokenSource{ts: ts, maxStale: o.maxStale, now: time.Now, logger: o.logger}
+	}
+	return ts, o.generation, nil
 }
@@ -16,10 +16,26 @@ package oauth2clientauthextension
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/config/configtls"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
@@ -48,14 +64,16 @@ func TestOAuthClientSettings(t *testing.T) {
 				TokenURL:     "https://example.com/v1/token",
 				Scopes:       []string{"resource.read"},
 				Timeout:      2,
-				TLSSetting: configtls.TLSClientSetting{
-					TLSSetting: configtls.TLSSetting{
-						CAFile:   testCAFile,
-						CertFile: testCertFile,
-						KeyFile:  testKeyFile,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{
+							CAFile:   testCAFile,
+							CertFile: testCertFile,
+							KeyFile:  testKeyFile,
+						},
+						Insecure:           false,
+						InsecureSkipVerify: false,
 					},
-					Insecure:           false,
-					InsecureSkipVerify: false,
 				},
 			},
 			shouldError:   false,
@@ -69,14 +87,16 @@ func TestOAuthClientSettings(t *testing.T) {
 				TokenURL:     "https://example.com/v1/token",
 				Scopes:       []string{"resource.read"},
 				Timeout:      2,
-				TLSSetting: configtls.TLSClientSetting{
-					TLSSetting: configtls.TLSSetting{
-						CAFile:   testCAFile,
-						CertFile: "doestexist.cert",
-						KeyFile:  testKeyFile,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{
+							CAFile:   testCAFile,
+							CertFile: "doestexist.cert",
+							KeyFile:  testKeyFile,
+						},
+						Insecure:           false,
+						InsecureSkipVerify: false,
 					},
-					Insecure:           false,
-					InsecureSkipVerify: false,
 				},
 			},
 			shouldError:   true,
@@ -102,6 +122,27 @@ func TestOAuthClientSettings(t *testing.T) {
 			shouldError:   true,
 			expectedError: errNoClientSecretProvided.Error(),
 		},
+		{
+			name: "unsupported_grant_type",
+			settings: &Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				GrantType:    "password",
+				TokenURL:     "https://example.com/v1/token",
+			},
+			shouldError:   true,
+			expectedError: errUnsupportedGrantType.Error(),
+		},
+		{
+			name: "missing_saml_assertion_file",
+			settings: &Config{
+				ClientID:  "testclientid",
+				GrantType: grantTypeSAML2Bearer,
+				TokenURL:  "https://example.com/v1/token",
+			},
+			shouldError:   true,
+			expectedError: errNoSAMLAssertionFile.Error(),
+		},
 		{
 			name: "missing_token_url",
 			settings: &Config{
@@ -130,15 +171,20 @@ func TestOAuthClientSettings(t *testing.T) {
 			assert.Equal(t, test.settings.Timeout, rc.client.Timeout)
 
 			// test tls settings
-			transport := rc.client.Transport.(*http.Transport)
+			transport := rc.client.Transport.(*acceptJSONRoundTripper).base.(*errorResponseRoundTripper).base.(*http.Transport)
 			tlsClientConfig := transport.TLSClientConfig
-			tlsTestSettingConfig, err := test.settings.TLSSetting.LoadTLSConfig()
+			tlsTestSettingConfig, err := test.settings.TLSSetting.loadTLSConfig()
 			assert.Nil(t, err)
 			assert.Equal(t, tlsClientConfig.Certificates, tlsTestSettingConfig.Certificates)
 		})
 	}
 }
 
+// fetchToken retrieves a token the same way the RoundTripper and PerRPCCredentials of the authenticator do.
+func fetchToken(o *ClientCredentialsAuthenticator) (*oauth2.Token, error) {
+	return o.tokenSource("").Token()
+}
+
 type testRoundTripper struct {
 	testString string
 }
@@ -272,3 +318,689 @@ func TestOAuthExtensionShutdown(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Nil(t, oAuthExtensionAuth.Shutdown(context.Background()))
 }
+
+func TestDialTimeout(t *testing.T) {
+	// 10.255.255.1 is not routable, connecting to it hangs until the dialer gives up.
+	const unroutable = "10.255.255.1:80"
+	dialTimeout := 100 * time.Millisecond
+
+	conn, err := (&net.Dialer{Timeout: dialTimeout}).Dial("tcp", unroutable)
+	if err == nil {
+		conn.Close()
+		t.Skip("network does not drop packets to unroutable addresses")
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Skipf("network rejects unroutable addresses instead of dropping them: %v", err)
+	}
+
+	oauth2Authenticator, err := newClientCredentialsExtension(
+		&Config{
+			ClientID:     "testclientid",
+			ClientSecret: "testsecret",
+			TokenURL:     "http://" + unroutable + "/v1/token",
+			Timeout:      10 * time.Second,
+			DialTimeout:  dialTimeout,
+		}, zap.NewNop())
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = fetchToken(oauth2Authenticator)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "i/o timeout")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDialNetwork(t *testing.T) {
+	// the token endpoint only listens on an IPv4 address
+	server, _ := newFlakyTokenServer(t)
+
+	tests := []struct {
+		network     string
+		shouldError bool
+	}{
+		{network: "tcp"},
+		{network: "tcp4"},
+		{network: "tcp6", shouldError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				DialNetwork:  test.network,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "dial "+test.network)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLocalAddress(t *testing.T) {
+	remoteAddrs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddrs <- r.RemoteAddr
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	// the whole 127.0.0.0/8 range is assigned to the loopback interface on Linux, not on every system
+	conn, err := (&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}).Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Skipf("127.0.0.2 can't be bound: %v", err)
+	}
+	require.NoError(t, conn.Close())
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		LocalAddress: "127.0.0.2",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	host, _, err := net.SplitHostPort(<-remoteAddrs)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.2", host)
+}
+
+// newTestCA returns a self-signed CA certificate, PEM encoded, along with a server certificate for 127.0.0.1
+// and auth.example.com it issued.
+func newTestCA(t *testing.T, name string) ([]byte, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"auth.example.com"},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+}
+
+func TestCABundle(t *testing.T) {
+	currentCA, currentCert := newTestCA(t, "current issuing CA")
+	nextCA, nextCert := newTestCA(t, "next issuing CA")
+	_, untrustedCert := newTestCA(t, "untrusted CA")
+
+	bundle := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, append(currentCA, nextCA...), 0600))
+
+	tests := []struct {
+		name        string
+		cert        tls.Certificate
+		shouldError bool
+	}{
+		{
+			name: "first_ca_of_bundle",
+			cert: currentCert,
+		},
+		{
+			name: "second_ca_of_bundle",
+			cert: nextCert,
+		},
+		{
+			name:        "ca_missing_from_bundle",
+			cert:        untrustedCert,
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{test.cert}}
+			server.StartTLS()
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: bundle},
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestTokenEndpointAddress(t *testing.T) {
+	caPEM, cert := newTestCA(t, "test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		host        string
+		shouldError bool
+	}{
+		{
+			name: "certificate_matches_hostname",
+			host: "auth.example.com",
+		},
+		{
+			name:        "certificate_does_not_match_hostname",
+			host:        "other.example.com",
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the host names don't resolve, the connection can only be established through the address override
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:             "testclientid",
+				ClientSecret:         "testsecret",
+				TokenURL:             "https://" + net.JoinHostPort(test.host, port) + "/v1/token",
+				TokenEndpointAddress: server.Listener.Addr().String(),
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: caFile},
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "certificate is valid for")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestTokenRequestALPN(t *testing.T) {
+	var mu sync.Mutex
+	var offeredProtos [][]string
+	var protoMajor int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protoMajor = r.ProtoMajor
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			offeredProtos = append(offeredProtos, hello.SupportedProtos)
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		TLSSetting: TLSClientSetting{
+			TLSClientSetting: configtls.TLSClientSetting{InsecureSkipVerify: true},
+			NextProtos:       []string{"h2"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, offeredProtos)
+	assert.Equal(t, "h2", offeredProtos[0][0])
+	assert.Equal(t, 2, protoMajor)
+}
+
+func TestForceHTTP1(t *testing.T) {
+	var mu sync.Mutex
+	var offeredProtos [][]string
+	var protoMajor int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protoMajor = r.ProtoMajor
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			offeredProtos = append(offeredProtos, hello.SupportedProtos)
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		ForceHTTP1:   true,
+		TLSSetting: TLSClientSetting{
+			TLSClientSetting: configtls.TLSClientSetting{InsecureSkipVerify: true},
+			NextProtos:       []string{"h2", "http/1.1"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, offeredProtos)
+	assert.Equal(t, []string{"http/1.1"}, offeredProtos[0])
+	assert.Equal(t, 1, protoMajor)
+}
+
+func TestReload(t *testing.T) {
+	var mu sync.Mutex
+	var fetchedBy []string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, _ := r.BasicAuth()
+		mu.Lock()
+		fetchedBy = append(fetchedBy, clientID)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"token-of-` + clientID + `","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+
+	newConfig := func(clientID string) *Config {
+		return &Config{
+			ClientID:     clientID,
+			ClientSecret: "testsecret",
+			TokenURL:     tokenServer.URL,
+		}
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(newConfig("client-a"), zap.NewNop())
+	require.NoError(t, err)
+	roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+	client := &http.Client{Transport: roundTripper}
+
+	authorization := func() string {
+		resp, err := client.Get(backend.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Equal(t, "Bearer token-of-client-a", authorization())
+	assert.Equal(t, "Bearer token-of-client-a", authorization())
+
+	require.NoError(t, oauth2Authenticator.Reload(newConfig("client-b")))
+	assert.Equal(t, "Bearer token-of-client-b", authorization())
+
+	// an invalid configuration is rejected and the current one is kept
+	assert.ErrorIs(t, oauth2Authenticator.Reload(newConfig("")), errNoClientIDProvided)
+	assert.Equal(t, "Bearer token-of-client-b", authorization())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"client-a", "client-b"}, fetchedBy)
+}
+
+func TestReloadNonReloadableSetting(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		assert.Empty(t, r.URL.Query().Get("access_token"))
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+
+	reloaded := *cfg
+	reloaded.TokenLocation = tokenLocationQuery
+	err = oauth2Authenticator.Reload(&reloaded)
+	assert.ErrorIs(t, err, errNonReloadableSetting)
+	assert.Contains(t, err.Error(), "token_location")
+
+	// the token is still sent in the Authorization header
+	resp, err := (&http.Client{Transport: roundTripper}).Get(backend.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// the default value of a setting isn't a change
+	reloaded.TokenLocation = tokenLocationHeader
+	assert.NoError(t, oauth2Authenticator.Reload(&reloaded))
+}
+
+func TestReloadProfilesAndMetadata(t *testing.T) {
+	cfg := &Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/v1/token",
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	reloaded := *cfg
+	reloaded.Profiles = map[string]TokenProfile{"billing": {Scopes: []string{"billing"}}}
+	err = oauth2Authenticator.Reload(&reloaded)
+	assert.ErrorIs(t, err, errNonReloadableSetting)
+	assert.Contains(t, err.Error(), "profiles")
+
+	reloaded = *cfg
+	reloaded.GRPCMetadata = map[string]string{"x-tenant": "acme"}
+	err = oauth2Authenticator.Reload(&reloaded)
+	assert.ErrorIs(t, err, errNonReloadableSetting)
+	assert.Contains(t, err.Error(), "grpc_metadata")
+
+	// changing configured profiles and metadata is reloaded
+	cfg.Profiles = map[string]TokenProfile{"billing": {Scopes: []string{"billing"}}}
+	cfg.GRPCMetadata = map[string]string{"x-tenant": "acme"}
+	oauth2Authenticator, err = newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	reloaded = *cfg
+	reloaded.Profiles = map[string]TokenProfile{"billing": {Scopes: []string{"billing"}}, "audit": {Scopes: []string{"audit"}}}
+	reloaded.GRPCMetadata = map[string]string{"x-tenant": "globex"}
+	require.NoError(t, oauth2Authenticator.Reload(&reloaded))
+	assert.Equal(t, map[string]string{"x-tenant": "globex"}, oauth2Authenticator.staticMetadata())
+	reloaded.Profiles = nil
+	err = oauth2Authenticator.Reload(&reloaded)
+	assert.ErrorIs(t, err, errNonReloadableSetting)
+	assert.Contains(t, err.Error(), "profiles")
+}
+
+func TestReloadDuringRequests(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	cfg := &Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	ts := oauth2Authenticator.tokenSource("")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := ts.Token()
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, oauth2Authenticator.Reload(cfg))
+	}
+	wg.Wait()
+}
+
+func TestTokenUnixSocket(t *testing.T) {
+	// socket paths are limited to around 100 bytes, which t.TempDir may exceed
+	dir, err := ioutil.TempDir("", "oauth2")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "idp.sock")
+
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &httptest.Server{
+		Listener: listener,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/token" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+		})},
+	}
+	server.Start()
+	defer server.Close()
+
+	// localhost:1 doesn't listen, the connection can only be established through the socket
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:        "testclientid",
+		ClientSecret:    "testsecret",
+		TokenURL:        "http://localhost:1/v1/token",
+		TokenUnixSocket: socket,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+}
+
+func TestReuseBaseTransportTLS(t *testing.T) {
+	caPEM, cert := newTestCA(t, "test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		reuse       bool
+		base        http.RoundTripper
+		caFile      string
+		shouldError bool
+	}{
+		{
+			name:  "base_transport_tls_reused",
+			reuse: true,
+			base:  &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		{
+			// the certificate of the authorization server isn't checked against the host of the backend
+			name:  "base_transport_server_name_ignored",
+			reuse: true,
+			base:  &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "backend.example.com"}},
+		},
+		{
+			name:        "base_transport_tls_not_reused",
+			base:        &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			shouldError: true,
+		},
+		{
+			// the tls settings of the extension are used
+			name:   "base_not_a_transport",
+			reuse:  true,
+			base:   &testRoundTripper{},
+			caFile: caFile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:              "testclientid",
+				ClientSecret:          "testsecret",
+				TokenURL:              server.URL,
+				ReuseBaseTransportTLS: test.reuse,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: test.caFile},
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = oauth2Authenticator.RoundTripper(test.base)
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "certificate signed by unknown authority")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestReuseBaseTransportTLSWithExtensionSettings(t *testing.T) {
+	caPEM, cert := newSelfSignedServerCert(t, nil)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:              "testclientid",
+		ClientSecret:          "testsecret",
+		TokenURL:              server.URL,
+		ReuseBaseTransportTLS: true,
+		TLSSetting: TLSClientSetting{
+			RequireServerAuthEKU: true,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	_, err = oauth2Authenticator.RoundTripper(&http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}})
+	require.NoError(t, err)
+
+	// the certificate is trusted by the exporter, but lacks the server authentication EKU required by the extension
+	_, err = fetchToken(oauth2Authenticator)
+	assert.ErrorIs(t, err, errMissingServerAuthEKU)
+}
+
+func TestReuseBaseTransportTLSKeptOnReload(t *testing.T) {
+	caPEM, cert := newTestCA(t, "test CA")
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caPEM))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	cfg := &Config{
+		ClientID:              "testclientid",
+		ClientSecret:          "testsecret",
+		TokenURL:              server.URL,
+		ReuseBaseTransportTLS: true,
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	_, err = oauth2Authenticator.RoundTripper(&http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}})
+	require.NoError(t, err)
+	// the TLS configuration of the first exporter is kept
+	_, err = oauth2Authenticator.RoundTripper(&http.Transport{})
+	require.NoError(t, err)
+
+	cfg.Scopes = []string{"resource.read"}
+	require.NoError(t, oauth2Authenticator.Reload(cfg))
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+}
@@ -16,7 +16,9 @@ package oauth2clientauthextension // import "github.com/open-telemetry/opentelem
 
 import (
 	"context"
+	"time"
 
+	"go.opencensus.io/stats/view"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/extension/extensionhelper"
@@ -28,19 +30,48 @@ const (
 )
 
 // NewFactory creates a factory for the OIDC Authenticator extension.
-func NewFactory() component.ExtensionFactory {
+// The options are applied to every extension created by the factory.
+func NewFactory(opts ...Option) component.ExtensionFactory {
+	_ = view.Register(MetricViews()...)
+
 	return extensionhelper.NewFactory(
 		typeStr,
 		createDefaultConfig,
-		createExtension)
+		func(ctx context.Context, set component.ExtensionCreateSettings, cfg config.Extension) (component.Extension, error) {
+			return createExtension(ctx, set, cfg, opts...)
+		})
 }
 
 func createDefaultConfig() config.Extension {
 	return &Config{
-		ExtensionSettings: config.NewExtensionSettings(config.NewComponentID(typeStr)),
+		ExtensionSettings:     config.NewExtensionSettings(config.NewComponentID(typeStr)),
+		GrantType:             grantTypeClientCredentials,
+		Mode:                  modeOAuth2,
+		MaxCachedTokenSources: defaultMaxCachedTokenSources,
+		DialNetwork:           dialNetworkTCP,
+		TokenLocation:         tokenLocationHeader,
+		OnLimit:               onLimitQueue,
+		MaxTokenLifetime:      24 * time.Hour,
+		CircuitBreaker: CircuitBreakerSettings{
+			Window:   time.Minute,
+			Cooldown: 30 * time.Second,
+		},
+		Retry: RetrySettings{
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     5 * time.Second,
+			JitterStrategy:  jitterFull,
+			ResetOnSuccess:  true,
+		},
+		RequestSigning: RequestSigningSettings{
+			HMACHeader:    defaultHMACHeader,
+			HMACAlgorithm: hmacSHA256,
+		},
+		SignedHeader: SignedHeaderSettings{
+			Header: defaultSignedHeader,
+		},
 	}
 }
 
-func createExtension(_ context.Context, set component.ExtensionCreateSettings, cfg config.Extension) (component.Extension, error) {
-	return newClientCredentialsExtension(cfg.(*Config), set.Logger)
+func createExtension(_ context.Context, set component.ExtensionCreateSettings, cfg config.Extension, opts ...Option) (component.Extension, error) {
+	return newClientCredentialsExtension(cfg.(*Config), set.Logger, append([]Option{withTracerProvider(set.TracerProvider)}, opts...)...)
 }
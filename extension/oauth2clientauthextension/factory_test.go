@@ -17,6 +17,7 @@ package oauth2clientauthextension
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.opentelemetry.io/collector/component/componenttest"
@@ -27,7 +28,31 @@ import (
 func TestCreateDefaultConfig(t *testing.T) {
 	// prepare and test
 	expected := &Config{
-		ExtensionSettings: config.NewExtensionSettings(config.NewComponentID(typeStr)),
+		ExtensionSettings:     config.NewExtensionSettings(config.NewComponentID(typeStr)),
+		GrantType:             "client_credentials",
+		Mode:                  "oauth2",
+		MaxCachedTokenSources: 100,
+		DialNetwork:           "tcp",
+		TokenLocation:         "header",
+		OnLimit:               "queue",
+		MaxTokenLifetime:      24 * time.Hour,
+		CircuitBreaker: CircuitBreakerSettings{
+			Window:   time.Minute,
+			Cooldown: 30 * time.Second,
+		},
+		Retry: RetrySettings{
+			InitialInterval: 100 * time.Millisecond,
+			MaxInterval:     5 * time.Second,
+			JitterStrategy:  "full",
+			ResetOnSuccess:  true,
+		},
+		RequestSigning: RequestSigningSettings{
+			HMACHeader:    "X-Signature",
+			HMACAlgorithm: "sha256",
+		},
+		SignedHeader: SignedHeaderSettings{
+			Header: "X-Request-Signature",
+		},
 	}
 
 	// test
@@ -38,6 +63,59 @@ func TestCreateDefaultConfig(t *testing.T) {
 	assert.NoError(t, configtest.CheckConfigStruct(cfg))
 }
 
+func TestDefaultConfigIsEffective(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.ClientID = "someclientid"
+	cfg.ClientSecret = "someclientsecret"
+	cfg.TokenURL = "https://example.com/oauth2/default/v1/token"
+	assert.NoError(t, cfg.Validate())
+
+	// the defaults applied at runtime are all explicit in the default configuration, but for the slices,
+	// which would be merged with the configured values rather than replaced
+	effective := cfg.Effective()
+	assert.NoError(t, effective.Validate())
+	assert.Equal(t, []int{429, 500, 502, 503, 504}, effective.Retry.RetryableStatusCodes)
+	assert.Empty(t, cfg.Retry.RetryableStatusCodes)
+	effective.Retry.RetryableStatusCodes = nil
+	assert.Equal(t, cfg, effective)
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	cfg := &Config{
+		ClientID:     "someclientid",
+		ClientSecret: "someclientsecret",
+		TokenURL:     "https://example.com/oauth2/default/v1/token",
+		Scopes:       []string{"api.metrics"},
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "https://billing.example.com"},
+			"inventory": {Audience: "https://inventory.example.com", Scopes: []string{"inventory.write"}},
+		},
+		Retry:                  RetrySettings{RetryableStatusCodes: []int{503}, BudgetPerSecond: 5},
+		TokenRequestsPerSecond: 2,
+	}
+	effective := cfg.Effective()
+
+	assert.Equal(t, "client_credentials", effective.GrantType)
+	assert.Equal(t, 100, effective.MaxCachedTokenSources)
+	assert.Equal(t, "tcp", effective.DialNetwork)
+	assert.Equal(t, "header", effective.TokenLocation)
+	assert.Equal(t, "queue", effective.OnLimit)
+	assert.Equal(t, 1, effective.TokenRequestsBurst)
+	assert.Equal(t, "X-Signature", effective.RequestSigning.HMACHeader)
+	assert.Equal(t, "sha256", effective.RequestSigning.HMACAlgorithm)
+	assert.Equal(t, []int{503}, effective.Retry.RetryableStatusCodes)
+	assert.Equal(t, 1, effective.Retry.BudgetBurst)
+	assert.Equal(t, map[string]TokenProfile{
+		"billing":   {Audience: "https://billing.example.com", Scopes: []string{"api.metrics"}},
+		"inventory": {Audience: "https://inventory.example.com", Scopes: []string{"inventory.write"}},
+	}, effective.Profiles)
+
+	// the configuration itself is left unchanged
+	assert.Empty(t, cfg.GrantType)
+	assert.Empty(t, cfg.Profiles["billing"].Scopes)
+	assert.Equal(t, effective, effective.Effective())
+}
+
 func TestCreateExtension(t *testing.T) {
 	cfg := createDefaultConfig().(*Config)
 
@@ -0,0 +1,104 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/credentials"
+)
+
+// failOpenRoundTripper sends requests without authorization when no token can be obtained, instead of failing them.
+type failOpenRoundTripper struct {
+	source     oauth2.TokenSource
+	authorized http.RoundTripper
+	base       http.RoundTripper
+	logger     *zap.Logger
+}
+
+func (f *failOpenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the token is checked first, as the authorizing RoundTripper closes the request body when failing.
+	// The token is cached, so the authorizing RoundTripper doesn't request it again.
+	if _, err := f.source.Token(); err != nil {
+		f.logger.Warn("Failed to get security token, sending the request without authorization", zap.Error(err))
+		return f.base.RoundTrip(req)
+	}
+	return f.authorized.RoundTrip(req)
+}
+
+// anonymousStatuses returns the set of the status codes of anonymous_on_status, nil when there are none.
+func anonymousStatuses(codes []int) map[int]bool {
+	if len(codes) == 0 {
+		return nil
+	}
+	statuses := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		statuses[code] = true
+	}
+	return statuses
+}
+
+// anonymousRoundTripper sends requests again without authorization, once, when the authorized request gets one of
+// the given status codes. Requests whose body can't be sent again get the response of the authorized request.
+type anonymousRoundTripper struct {
+	authorized http.RoundTripper
+	base       http.RoundTripper
+	statuses   map[int]bool
+	logger     *zap.Logger
+}
+
+func (a *anonymousRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := a.authorized.RoundTrip(req)
+	if err != nil || !a.statuses[resp.StatusCode] {
+		return resp, err
+	}
+	req2 := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if req2.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	req2.Header.Del("Authorization")
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+	a.logger.Debug("Sending the request again without authorization", zap.Int("status_code", resp.StatusCode))
+	return a.base.RoundTrip(req2)
+}
+
+// failOpenPerRPCCredentials sends RPCs without authorization when no token can be obtained, instead of failing them.
+type failOpenPerRPCCredentials struct {
+	credentials.PerRPCCredentials
+	logger *zap.Logger
+}
+
+func (f *failOpenPerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md, err := f.PerRPCCredentials.GetRequestMetadata(ctx, uri...)
+	var tokenErr *FailedToGetSecurityTokenError
+	if errors.As(err, &tokenErr) {
+		// other errors, like an insecure connection, are still reported
+		f.logger.Warn("Failed to get security token, sending the RPC without authorization", zap.Error(err))
+		return map[string]string{}, nil
+	}
+	return md, err
+}
@@ -0,0 +1,234 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newFailingTokenServer returns a token endpoint rejecting the client credentials.
+func newFailingTokenServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFailOpenRoundTripper(t *testing.T) {
+	tests := []struct {
+		name          string
+		failOpen      bool
+		tokenFails    bool
+		shouldError   bool
+		expectedAuth  string
+		expectedWarns int
+	}{
+		{
+			name:         "fail_closed_token_obtained",
+			expectedAuth: "Bearer test-token",
+		},
+		{
+			name:        "fail_closed_token_failure",
+			tokenFails:  true,
+			shouldError: true,
+		},
+		{
+			name:         "fail_open_token_obtained",
+			failOpen:     true,
+			expectedAuth: "Bearer test-token",
+		},
+		{
+			name:          "fail_open_token_failure",
+			failOpen:      true,
+			tokenFails:    true,
+			expectedWarns: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newFailingTokenServer(t)
+			if !test.tokenFails {
+				tokenServer, _ = newFlakyTokenServer(t)
+			}
+
+			var authorization, body string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+			}))
+			defer backend.Close()
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     tokenServer.URL,
+				FailOpen:     test.failOpen,
+			}, zap.New(core))
+			require.NoError(t, err)
+			roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, backend.URL, strings.NewReader("payload"))
+			require.NoError(t, err)
+			resp, err := roundTripper.RoundTrip(req)
+			if test.shouldError {
+				var tokenErr *FailedToGetSecurityTokenError
+				assert.ErrorAs(t, err, &tokenErr)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.expectedAuth, authorization)
+			// the body is sent whether the request is authorized or not
+			assert.Equal(t, "payload", body)
+			assert.Equal(t, test.expectedWarns, logs.Len())
+		})
+	}
+}
+
+func TestAnonymousOnStatus(t *testing.T) {
+	tests := []struct {
+		name              string
+		rejectStatus      int
+		expectedStatus    int
+		expectedAuths     []string
+		expectedPayloads  []string
+		anonymousOnStatus []int
+	}{
+		{
+			name:              "sent_again_without_authorization",
+			rejectStatus:      http.StatusForbidden,
+			anonymousOnStatus: []int{http.StatusUnauthorized, http.StatusForbidden},
+			expectedStatus:    http.StatusOK,
+			expectedAuths:     []string{"Bearer test-token", ""},
+			expectedPayloads:  []string{"payload", "payload"},
+		},
+		{
+			name:              "other_status",
+			rejectStatus:      http.StatusInternalServerError,
+			anonymousOnStatus: []int{http.StatusForbidden},
+			expectedStatus:    http.StatusInternalServerError,
+			expectedAuths:     []string{"Bearer test-token"},
+			expectedPayloads:  []string{"payload"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer, _ := newFlakyTokenServer(t)
+
+			var auths, payloads []string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auths = append(auths, r.Header.Get("Authorization"))
+				b, _ := ioutil.ReadAll(r.Body)
+				payloads = append(payloads, string(b))
+				if r.Header.Get("Authorization") != "" {
+					w.WriteHeader(test.rejectStatus)
+				}
+			}))
+			defer backend.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:          "testclientid",
+				ClientSecret:      "testsecret",
+				TokenURL:          tokenServer.URL,
+				AnonymousOnStatus: test.anonymousOnStatus,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, backend.URL, strings.NewReader("payload"))
+			require.NoError(t, err)
+			resp, err := roundTripper.RoundTrip(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.expectedStatus, resp.StatusCode)
+			assert.Equal(t, test.expectedAuths, auths)
+			// the body is sent again along with the anonymous request
+			assert.Equal(t, test.expectedPayloads, payloads)
+		})
+	}
+}
+
+func TestFailOpenPerRPCCredentials(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     newFailingTokenServer(t).URL,
+		FailOpen:     true,
+		GRPCMetadata: map[string]string{"x-mesh-route": "backend-a"},
+	}, zap.New(core))
+	require.NoError(t, err)
+
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	md, err := perRPCCredentials.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	// the static metadata is still attached
+	assert.Equal(t, map[string]string{"x-mesh-route": "backend-a"}, md)
+	assert.Equal(t, 1, logs.Len())
+	assert.True(t, perRPCCredentials.RequireTransportSecurity())
+}
+
+func TestFailClosedPerRPCCredentials(t *testing.T) {
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     newFailingTokenServer(t).URL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	_, err = perRPCCredentials.GetRequestMetadata(context.Background())
+	var tokenErr *FailedToGetSecurityTokenError
+	assert.ErrorAs(t, err, &tokenErr)
+}
+
+func TestFailOpenReportsOtherErrors(t *testing.T) {
+	tokenServer, _ := newFlakyTokenServer(t)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+		FailOpen:     true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	// the token is obtained, but the context doesn't come from a secure connection
+	_, err = perRPCCredentials.GetRequestMetadata(context.Background())
+	assert.Error(t, err)
+}
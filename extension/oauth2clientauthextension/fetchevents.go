@@ -0,0 +1,144 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"golang.org/x/oauth2"
+)
+
+// fetchEvent describes a token request. Like audit entries, it never holds the token nor the client credentials.
+type fetchEvent struct {
+	Time      time.Time
+	Profile   string
+	Outcome   string
+	Latency   time.Duration
+	ErrorCode string
+}
+
+// fetchEvents keeps the latest token fetch events in a ring buffer, evicting the oldest one when full.
+type fetchEvents struct {
+	mu     sync.Mutex
+	events []fetchEvent
+	next   int
+	full   bool
+}
+
+func newFetchEvents(size int) *fetchEvents {
+	return &fetchEvents{events: make([]fetchEvent, size)}
+}
+
+func (f *fetchEvents) add(event fetchEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events[f.next] = event
+	f.next = (f.next + 1) % len(f.events)
+	if f.next == 0 {
+		f.full = true
+	}
+}
+
+// latest returns the recorded events, the most recent first.
+func (f *fetchEvents) latest() []fetchEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count := f.next
+	if f.full {
+		count = len(f.events)
+	}
+	latest := make([]fetchEvent, 0, count)
+	for i := 1; i <= count; i++ {
+		latest = append(latest, f.events[(f.next-i+len(f.events))%len(f.events)])
+	}
+	return latest
+}
+
+// fetchEventsTokenSource records an event for every token request.
+type fetchEventsTokenSource struct {
+	ts      oauth2.TokenSource
+	events  *fetchEvents
+	id      config.ComponentID
+	profile string
+	now     func() time.Time
+}
+
+func (s *fetchEventsTokenSource) Token() (*oauth2.Token, error) {
+	start := s.now()
+	token, err := s.ts.Token()
+	event := fetchEvent{
+		Time:    start,
+		Profile: s.profile,
+		Outcome: auditOutcomeSuccess,
+		Latency: s.now().Sub(start),
+	}
+	if err != nil {
+		event.Outcome = auditOutcomeFailure
+		event.ErrorCode = (&FailedToGetSecurityTokenError{id: s.id, err: err}).ErrorCode()
+	}
+	s.events.add(event)
+	return token, err
+}
+
+var fetchEventsPage = template.Must(template.New("fetchevents").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Extension}} token fetches</title></head>
+<body>
+<h1>{{.Extension}} token fetches</h1>
+{{with .Description}}<table>
+<tr><th>Grant type</th><td>{{.GrantType}}</td></tr>
+<tr><th>Token URL</th><td>{{.TokenURL}}</td></tr>
+<tr><th>Client ID</th><td>{{.ClientID}}</td></tr>
+<tr><th>Client secret</th><td>{{.ClientSecret}}</td></tr>
+<tr><th>Scopes</th><td>{{range $i, $scope := .Scopes}}{{if $i}} {{end}}{{$scope}}{{end}}</td></tr>
+<tr><th>Auth style</th><td>{{.AuthStyle}}</td></tr>
+<tr><th>Mutual TLS</th><td>{{.MutualTLS}}</td></tr>
+<tr><th>Profiles</th><td>{{range $i, $profile := .Profiles}}{{if $i}} {{end}}{{$profile}}{{end}}</td></tr>
+</table>{{end}}
+{{if .Events}}<table>
+<tr><th>Time</th><th>Profile</th><th>Outcome</th><th>Latency</th><th>Error code</th></tr>
+{{range .Events}}<tr><td>{{.Time.UTC.Format "2006-01-02T15:04:05.000Z07:00"}}</td><td>{{.Profile}}</td><td>{{.Outcome}}</td><td>{{.Latency}}</td><td>{{.ErrorCode}}</td></tr>
+{{end}}</table>{{else}}<p>No token fetches recorded.</p>{{end}}
+</body>
+</html>
+`))
+
+// ZPageHandler returns the handler rendering the description of the grant of the extension and its latest token
+// fetches, the most recent first, when fetch_event_history is set. The collector's zpages extension doesn't serve pages of other extensions,
+// so the handler is meant to be mounted by distributions next to it, e.g. on /debug/oauth2clientauth.
+func (o *ClientCredentialsAuthenticator) ZPageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Extension   string
+			Description Description
+			Events      []fetchEvent
+		}{Extension: o.id.String(), Description: o.Describe()}
+		if o.fetchEvents != nil {
+			data.Events = o.fetchEvents.latest()
+		}
+		var page bytes.Buffer
+		if err := fetchEventsPage.Execute(&page, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = page.WriteTo(w)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestFetchEventsEvictOldest(t *testing.T) {
+	events := newFetchEvents(3)
+	assert.Empty(t, events.latest())
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		events.add(fetchEvent{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	latest := events.latest()
+	require.Len(t, latest, 3)
+	for i, event := range latest {
+		assert.Equal(t, start.Add(time.Duration(4-i)*time.Second), event.Time)
+	}
+}
+
+func TestFetchEventHistory(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		// the token expires within the expiry delta of oauth2, so that the next request fetches a new one
+		_, _ = w.Write([]byte(`{"access_token":"secret-token","token_type":"bearer","expires_in":1}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ExtensionSettings: config.NewExtensionSettings(config.NewComponentIDWithName(typeStr, "debugged")),
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          server.URL,
+		FetchEventHistory: 2,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	for i := 0; i < 2; i++ {
+		_, err = fetchToken(oauth2Authenticator)
+		require.NoError(t, err)
+	}
+	fail = true
+	_, err = fetchToken(oauth2Authenticator)
+	require.Error(t, err)
+
+	events := oauth2Authenticator.fetchEvents.latest()
+	require.Len(t, events, 2)
+	assert.Equal(t, auditOutcomeFailure, events[0].Outcome)
+	assert.Equal(t, "invalid_client", events[0].ErrorCode)
+	assert.Equal(t, auditOutcomeSuccess, events[1].Outcome)
+	assert.Empty(t, events[1].ErrorCode)
+	assert.True(t, events[1].Time.Before(events[0].Time))
+
+	recorder := httptest.NewRecorder()
+	oauth2Authenticator.ZPageHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/oauth2clientauth", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	page := recorder.Body.String()
+	assert.Contains(t, page, "oauth2client/debugged token fetches")
+	assert.Contains(t, page, "invalid_client")
+	assert.NotContains(t, page, "secret-token")
+	assert.NotContains(t, page, "testsecret")
+}
+
+func TestZPageHandlerWithoutHistory(t *testing.T) {
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/v1/token",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	assert.Nil(t, oauth2Authenticator.fetchEvents)
+
+	recorder := httptest.NewRecorder()
+	oauth2Authenticator.ZPageHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/oauth2clientauth", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "No token fetches recorded.")
+}
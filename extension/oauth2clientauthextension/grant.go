@@ -0,0 +1,152 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// GrantHandler obtains tokens with an OAuth2 grant, selected by the grant_type setting of the extension.
+type GrantHandler interface {
+	// TokenSource returns a token source obtaining tokens with cfg, the client configuration of the extension or of
+	// one of its profiles, and sending the token requests with httpClient, which holds the TLS, proxy, retry and
+	// timeout settings of the extension. Tokens are cached by the extension, so the token source should request
+	// a new token on every call.
+	TokenSource(cfg *clientcredentials.Config, httpClient *http.Client) (oauth2.TokenSource, error)
+}
+
+var (
+	grantHandlersMu sync.RWMutex
+	grantHandlers   = map[string]GrantHandler{
+		grantTypeClientCredentials: clientCredentialsGrant{},
+	}
+)
+
+// RegisterGrantHandler registers the handler of a custom grant type, selected by setting grant_type to that type.
+// Configurations are validated against the registered grant types, so handlers must be registered before the
+// collector loads its configuration, typically from an init function. It panics when the grant type is empty,
+// built in, or already registered.
+func RegisterGrantHandler(grantType string, handler GrantHandler) {
+	grantHandlersMu.Lock()
+	defer grantHandlersMu.Unlock()
+	if grantType == "" || handler == nil {
+		panic("oauth2clientauthextension: RegisterGrantHandler with an empty grant type or a nil handler")
+	}
+	switch grantType {
+	case grantTypeClientCredentials, grantTypeSAML2Bearer, grantTypeTokenExchange:
+		panic(fmt.Sprintf("oauth2clientauthextension: RegisterGrantHandler called for the built-in grant type %q", grantType))
+	}
+	if _, ok := grantHandlers[grantType]; ok {
+		panic(fmt.Sprintf("oauth2clientauthextension: RegisterGrantHandler called twice for grant type %q", grantType))
+	}
+	grantHandlers[grantType] = handler
+}
+
+// grantHandler returns the handler registered for the grant type, or nil. The client credentials handler is
+// returned for an empty grant type.
+func grantHandler(grantType string) GrantHandler {
+	if grantType == "" {
+		grantType = grantTypeClientCredentials
+	}
+	grantHandlersMu.RLock()
+	defer grantHandlersMu.RUnlock()
+	return grantHandlers[grantType]
+}
+
+// clientCredentialsGrant is the handler of the client credentials grant.
+type clientCredentialsGrant struct{}
+
+func (clientCredentialsGrant) TokenSource(cfg *clientcredentials.Config, httpClient *http.Client) (oauth2.TokenSource, error) {
+	return &clientCredentialsTokenSource{
+		ctx:  context.WithValue(context.Background(), oauth2.HTTPClient, httpClient),
+		conf: cfg,
+	}, nil
+}
+
+// contextClient returns a copy of the oauth2.HTTPClient of ctx, or of the default client, whose requests carry the
+// values of ctx, like the retry backoff of the token source, so that handlers only given the client still see them.
+func contextClient(ctx context.Context) *http.Client {
+	client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	if !ok {
+		client = http.DefaultClient
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	withValues := *client
+	withValues.Transport = &contextValuesRoundTripper{base: base, values: ctx}
+	return &withValues
+}
+
+// contextValuesRoundTripper makes the values of a context visible to the requests it sends. The requests are cancelled
+// as well when that context is, e.g. when the extension shuts down.
+type contextValuesRoundTripper struct {
+	base   http.RoundTripper
+	values context.Context
+}
+
+func (c *contextValuesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	done := c.values.Done()
+	if done == nil {
+		return c.base.RoundTrip(req.WithContext(&valuesContext{Context: ctx, values: c.values}))
+	}
+	if err := c.values.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-stop:
+		}
+	}()
+	release := func() {
+		close(stop)
+		cancel()
+	}
+	resp, err := c.base.RoundTrip(req.WithContext(&valuesContext{Context: ctx, values: c.values}))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// the request stays cancellable until its response is read
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// valuesContext is a context looking up values in another context first. Its deadline and cancellation are the ones
+// of the embedded context.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (v *valuesContext) Value(key interface{}) interface{} {
+	if value := v.values.Value(key); value != nil {
+		return value
+	}
+	return v.Context.Value(key)
+}
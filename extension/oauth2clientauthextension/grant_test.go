@@ -0,0 +1,98 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const testGrantType = "urn:example:params:oauth:grant-type:in-house"
+
+// inHouseGrant sends the client ID in a header of a GET request, the token being returned in a response header.
+type inHouseGrant struct{}
+
+func (inHouseGrant) TokenSource(cfg *clientcredentials.Config, httpClient *http.Client) (oauth2.TokenSource, error) {
+	return &inHouseTokenSource{cfg: cfg, client: httpClient}, nil
+}
+
+type inHouseTokenSource struct {
+	cfg    *clientcredentials.Config
+	client *http.Client
+}
+
+func (s *inHouseTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, s.cfg.TokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Client-Id", s.cfg.ClientID)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return &oauth2.Token{AccessToken: resp.Header.Get("X-Token"), TokenType: "Bearer"}, nil
+}
+
+func init() {
+	RegisterGrantHandler(testGrantType, inHouseGrant{})
+}
+
+func TestRegisteredGrantHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Token", "token-of-"+r.Header.Get("X-Client-Id"))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		GrantType:    testGrantType,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "token-of-testclientid", token.AccessToken)
+}
+
+func TestUnregisteredGrantType(t *testing.T) {
+	cfg := &Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/v1/token",
+		GrantType:    "urn:example:params:oauth:grant-type:unknown",
+	}
+	assert.ErrorIs(t, cfg.Validate(), errUnsupportedGrantType)
+}
+
+func TestRegisterGrantHandlerTwice(t *testing.T) {
+	assert.PanicsWithValue(t, `oauth2clientauthextension: RegisterGrantHandler called twice for grant type "`+testGrantType+`"`,
+		func() { RegisterGrantHandler(testGrantType, inHouseGrant{}) })
+	for _, grantType := range []string{grantTypeClientCredentials, grantTypeSAML2Bearer, grantTypeTokenExchange} {
+		assert.PanicsWithValue(t, `oauth2clientauthextension: RegisterGrantHandler called for the built-in grant type "`+grantType+`"`,
+			func() { RegisterGrantHandler(grantType, inHouseGrant{}) })
+	}
+	assert.Panics(t, func() { RegisterGrantHandler("", inHouseGrant{}) })
+}
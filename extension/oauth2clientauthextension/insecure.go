@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// insecureOption is a setting weakening the security of the extension, reported on start with a warning code that
+// is kept stable across releases, so that security scanners can detect it in the logs.
+type insecureOption struct {
+	code    string
+	setting string
+	reason  string
+}
+
+// insecureOptions returns the insecure options enabled by cfg, in a stable order.
+func insecureOptions(cfg *Config) []insecureOption {
+	var options []insecureOption
+	if cfg.TLSSetting.InsecureSkipVerify {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_INSECURE_SKIP_VERIFY",
+			setting: "tls.insecure_skip_verify",
+			reason:  "the certificate of the authorization server isn't verified",
+		})
+	}
+	if plaintextTokenURL(cfg) {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_PLAINTEXT_TOKEN_URL",
+			setting: "token_url",
+			reason:  "the client credentials and the tokens are sent unencrypted",
+		})
+	}
+	if cfg.TokenLocation == tokenLocationQuery {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_TOKEN_IN_QUERY",
+			setting: "token_location",
+			reason:  "the tokens are sent in the URL of the requests, which proxies and servers often log",
+		})
+	}
+	if cfg.FailOpen {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_FAIL_OPEN",
+			setting: "fail_open",
+			reason:  "requests are sent without authorization when no token can be obtained",
+		})
+	}
+	if len(cfg.AnonymousOnStatus) > 0 {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_ANONYMOUS_ON_STATUS",
+			setting: "anonymous_on_status",
+			reason:  "rejected requests are sent again without authorization",
+		})
+	}
+	if cfg.LogTokenMasked {
+		options = append(options, insecureOption{
+			code:    "OAUTH2CLIENT_TOKEN_LOGGED",
+			setting: "log_token_masked",
+			reason:  "parts of the tokens are logged at debug level",
+		})
+	}
+	return options
+}
+
+// plaintextTokenURL reports whether the token requests of cfg are sent unencrypted to another host. Connections to
+// loopback addresses and Unix sockets, e.g. to sidecars, don't leave the host.
+func plaintextTokenURL(cfg *Config) bool {
+	u, err := url.Parse(cfg.TokenURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "http") || cfg.TokenUnixSocket != "" {
+		return false
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return !ip.IsLoopback()
+	}
+	return !strings.EqualFold(host, "localhost")
+}
+
+// warnInsecureOptions logs a warning for each insecure option enabled.
+func warnInsecureOptions(logger *zap.Logger, options []insecureOption) {
+	for _, option := range options {
+		logger.Warn("Insecure option enabled",
+			zap.String("warning_code", option.code),
+			zap.String("setting", option.setting),
+			zap.String("reason", option.reason))
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestInsecureOptionWarnings(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(cfg *Config)
+		expectedCodes []string
+	}{
+		{
+			name:      "none",
+			configure: func(cfg *Config) {},
+		},
+		{
+			name: "insecure_skip_verify",
+			configure: func(cfg *Config) {
+				cfg.TLSSetting.TLSClientSetting = configtls.TLSClientSetting{InsecureSkipVerify: true}
+			},
+			expectedCodes: []string{"OAUTH2CLIENT_INSECURE_SKIP_VERIFY"},
+		},
+		{
+			name:          "plaintext_token_url",
+			configure:     func(cfg *Config) { cfg.TokenURL = "http://example.com/oauth2/token" },
+			expectedCodes: []string{"OAUTH2CLIENT_PLAINTEXT_TOKEN_URL"},
+		},
+		{
+			// the connections don't leave the host
+			name:      "loopback_token_url",
+			configure: func(cfg *Config) { cfg.TokenURL = "http://127.0.0.1:8080/oauth2/token" },
+		},
+		{
+			name: "unix_socket",
+			configure: func(cfg *Config) {
+				cfg.TokenURL = "http://broker/oauth2/token"
+				cfg.TokenUnixSocket = "/var/run/broker.sock"
+			},
+		},
+		{
+			name:          "token_in_query",
+			configure:     func(cfg *Config) { cfg.TokenLocation = tokenLocationQuery },
+			expectedCodes: []string{"OAUTH2CLIENT_TOKEN_IN_QUERY"},
+		},
+		{
+			name:          "fail_open",
+			configure:     func(cfg *Config) { cfg.FailOpen = true },
+			expectedCodes: []string{"OAUTH2CLIENT_FAIL_OPEN"},
+		},
+		{
+			name:          "anonymous_on_status",
+			configure:     func(cfg *Config) { cfg.AnonymousOnStatus = []int{403} },
+			expectedCodes: []string{"OAUTH2CLIENT_ANONYMOUS_ON_STATUS"},
+		},
+		{
+			name:          "log_token_masked",
+			configure:     func(cfg *Config) { cfg.LogTokenMasked = true },
+			expectedCodes: []string{"OAUTH2CLIENT_TOKEN_LOGGED"},
+		},
+		{
+			name: "several",
+			configure: func(cfg *Config) {
+				cfg.TokenURL = "http://example.com/oauth2/token"
+				cfg.FailOpen = true
+			},
+			expectedCodes: []string{"OAUTH2CLIENT_PLAINTEXT_TOKEN_URL", "OAUTH2CLIENT_FAIL_OPEN"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     "https://example.com/oauth2/token",
+			}
+			test.configure(cfg)
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.New(core))
+			require.NoError(t, err)
+			// only reported on start
+			assert.Zero(t, logs.Len())
+			require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+
+			var codes []string
+			for _, entry := range logs.FilterMessage("Insecure option enabled").All() {
+				fields := entry.ContextMap()
+				codes = append(codes, fields["warning_code"].(string))
+				assert.NotEmpty(t, fields["setting"])
+				assert.NotEmpty(t, fields["reason"])
+			}
+			assert.Equal(t, test.expectedCodes, codes)
+		})
+	}
+}
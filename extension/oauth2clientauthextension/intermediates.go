@@ -0,0 +1,92 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+var (
+	errNoIntermediates = errors.New("tls.intermediates_file holds no PEM certificate")
+	errNoServerName    = errors.New("tls: no server name to verify the certificate against, set tls.server_name_override")
+)
+
+// loadIntermediates returns the certificates of a PEM file.
+func loadIntermediates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS intermediates: %w", err)
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS intermediates: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoIntermediates, path)
+	}
+	return certs, nil
+}
+
+// withIntermediates makes tlsCfg verify the certificate chains of the servers with the given intermediate
+// certificates in addition to the ones sent by the servers, for servers sending incomplete chains.
+// Go doesn't fetch missing intermediates from the Authority Information Access extension of the certificates.
+// The handshake fails when there is no name to verify the certificate against: crypto/tls doesn't send the IP
+// addresses as server names, so servers reached by IP address need the server name to be set.
+func withIntermediates(tlsCfg *tls.Config, intermediates []*x509.Certificate) {
+	if tlsCfg.InsecureSkipVerify {
+		return
+	}
+	roots := tlsCfg.RootCAs
+	serverName := tlsCfg.ServerName
+	// the verification done by crypto/tls is replaced by the one below, which is equivalent but for the intermediates
+	tlsCfg.InsecureSkipVerify = true // #nosec
+	tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("tls: server didn't provide a certificate")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		if opts.DNSName == "" {
+			opts.DNSName = cs.ServerName
+		}
+		if opts.DNSName == "" {
+			return errNoServerName
+		}
+		for _, cert := range intermediates {
+			opts.Intermediates.AddCert(cert)
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+)
+
+// newTestChain returns a root CA certificate and an intermediate CA certificate, PEM encoded, along with a server
+// certificate for 127.0.0.1 and auth.example.com issued by the intermediate CA, without the intermediate in its chain.
+func newTestChain(t *testing.T) (rootPEM []byte, intermediatePEM []byte, server tls.Certificate) {
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		return key
+	}
+	issue := func(template, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) ([]byte, *x509.Certificate) {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		return der, cert
+	}
+
+	rootKey, intermediateKey, serverKey := newKey(), newKey(), newKey()
+	rootDER, root := issue(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, rootKey, rootKey)
+	intermediateDER, intermediate := issue(&x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "test intermediate CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, root, intermediateKey, rootKey)
+	serverDER, _ := issue(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"auth.example.com"},
+	}, intermediate, serverKey, intermediateKey)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediateDER}),
+		tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+}
+
+func TestIntermediatesFile(t *testing.T) {
+	rootPEM, intermediatePEM, cert := newTestChain(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, rootPEM, 0600))
+	intermediatesFile := filepath.Join(dir, "intermediates.pem")
+	require.NoError(t, ioutil.WriteFile(intermediatesFile, intermediatePEM, 0600))
+
+	// the server only sends its own certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		host              string
+		serverName        string
+		intermediatesFile string
+		expectedErr       string
+	}{
+		{
+			name:        "incomplete_chain",
+			host:        "127.0.0.1",
+			expectedErr: "certificate signed by unknown authority",
+		},
+		{
+			name:              "chain_completed_with_intermediates",
+			host:              "127.0.0.1",
+			serverName:        "127.0.0.1",
+			intermediatesFile: intermediatesFile,
+		},
+		{
+			name:              "no_server_name",
+			host:              "127.0.0.1",
+			intermediatesFile: intermediatesFile,
+			expectedErr:       errNoServerName.Error(),
+		},
+		{
+			name:              "ip_address_still_checked",
+			host:              "127.0.0.1",
+			serverName:        "127.0.0.2",
+			intermediatesFile: intermediatesFile,
+			expectedErr:       "certificate is valid for",
+		},
+		{
+			name:              "hostname_verified",
+			host:              "auth.example.com",
+			intermediatesFile: intermediatesFile,
+		},
+		{
+			name:              "hostname_still_checked",
+			host:              "other.example.com",
+			intermediatesFile: intermediatesFile,
+			expectedErr:       "certificate is valid for",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:             "testclientid",
+				ClientSecret:         "testsecret",
+				TokenURL:             "https://" + net.JoinHostPort(test.host, port) + "/v1/token",
+				TokenEndpointAddress: server.Listener.Addr().String(),
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: caFile},
+						ServerName: test.serverName,
+					},
+					IntermediatesFile: test.intermediatesFile,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestIntermediatesFileWithoutCertificates(t *testing.T) {
+	intermediatesFile := filepath.Join(t.TempDir(), "intermediates.pem")
+	require.NoError(t, ioutil.WriteFile(intermediatesFile, []byte("not a certificate"), 0600))
+
+	_, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/v1/token",
+		TLSSetting:   TLSClientSetting{IntermediatesFile: intermediatesFile},
+	}, zap.NewNop())
+	assert.ErrorIs(t, err, errNoIntermediates)
+}
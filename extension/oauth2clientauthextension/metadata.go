@@ -0,0 +1,59 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// metadataPerRPCCredentials attaches the static gRPC metadata of the extension configuration alongside
+// the metadata of the wrapped credentials, which carry the bearer token.
+type metadataPerRPCCredentials struct {
+	credentials.PerRPCCredentials
+	o *ClientCredentialsAuthenticator
+}
+
+func (c *metadataPerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md, err := c.PerRPCCredentials.GetRequestMetadata(ctx, uri...)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.o.staticMetadata() {
+		md[k] = v
+	}
+	return md, nil
+}
+
+// staticMetadata returns the static gRPC metadata of the current configuration.
+func (o *ClientCredentialsAuthenticator) staticMetadata() map[string]string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.grpcMetadata
+}
+
+// normalizeMetadata lowercases the metadata keys, as gRPC does.
+func normalizeMetadata(md map[string]string) map[string]string {
+	if len(md) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(md))
+	for k, v := range md {
+		normalized[strings.ToLower(k)] = v
+	}
+	return normalized
+}
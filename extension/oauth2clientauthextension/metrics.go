@@ -0,0 +1,92 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/collector/config"
+	"golang.org/x/oauth2"
+)
+
+var (
+	tagExtension = tag.MustNewKey("extension")
+	// tagProfile is only set for the tokens of the profiles named in the configuration, bounding its cardinality.
+	tagProfile = tag.MustNewKey("profile")
+
+	mTokenExpiry = stats.Float64("oauth2_token_expiry_seconds", "Seconds until the token in use expires, zero when no token could be obtained", stats.UnitSeconds)
+
+	mCachedTokenSources  = stats.Int64("oauth2_cached_token_sources", "Number of token sources cached for the token profiles", stats.UnitDimensionless)
+	mEvictedTokenSources = stats.Int64("oauth2_evicted_token_sources", "Number of token sources evicted from the cache of the token profiles", stats.UnitDimensionless)
+)
+
+// MetricViews returns the metrics views of the extension.
+func MetricViews() []*view.View {
+	return []*view.View{
+		{
+			Name:        mTokenExpiry.Name(),
+			Measure:     mTokenExpiry,
+			Description: mTokenExpiry.Description(),
+			TagKeys:     []tag.Key{tagExtension, tagProfile},
+			Aggregation: view.LastValue(),
+		},
+		{
+			Name:        mCachedTokenSources.Name(),
+			Measure:     mCachedTokenSources,
+			Description: mCachedTokenSources.Description(),
+			TagKeys:     []tag.Key{tagExtension},
+			Aggregation: view.LastValue(),
+		},
+		{
+			Name:        mEvictedTokenSources.Name(),
+			Measure:     mEvictedTokenSources,
+			Description: mEvictedTokenSources.Description(),
+			TagKeys:     []tag.Key{tagExtension},
+			Aggregation: view.Sum(),
+		},
+	}
+}
+
+// recordTokenExpiry records the remaining lifetime of the token handed out by the extension for the profile, which is
+// negative for an expired token and zero when no token could be obtained. Tokens without expiry are not recorded.
+func recordTokenExpiry(id config.ComponentID, profile string, token *oauth2.Token) {
+	var seconds float64
+	if token != nil {
+		if token.Expiry.IsZero() {
+			return
+		}
+		seconds = time.Until(token.Expiry).Seconds()
+	}
+	mutators := []tag.Mutator{tag.Upsert(tagExtension, id.String())}
+	if profile != "" {
+		mutators = append(mutators, tag.Upsert(tagProfile, profile))
+	}
+	_ = stats.RecordWithTags(context.Background(), mutators, mTokenExpiry.M(seconds))
+}
+
+// recordCachedTokenSources records the number of token sources cached for the profiles by the extension, across
+// the caches of all its exporters, and counts the evicted ones.
+func recordCachedTokenSources(id config.ComponentID, cached int32, evicted bool) {
+	mutators := []tag.Mutator{tag.Upsert(tagExtension, id.String())}
+	measurements := []stats.Measurement{mCachedTokenSources.M(int64(cached))}
+	if evicted {
+		measurements = append(measurements, mEvictedTokenSources.M(1))
+	}
+	_ = stats.RecordWithTags(context.Background(), mutators, measurements...)
+}
@@ -0,0 +1,180 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestMetricViews(t *testing.T) {
+	views := MetricViews()
+	require.Len(t, views, 3)
+	assert.Equal(t, "oauth2_token_expiry_seconds", views[0].Name)
+	assert.Equal(t, "oauth2_cached_token_sources", views[1].Name)
+	assert.Equal(t, "oauth2_evicted_token_sources", views[2].Name)
+}
+
+// lastTokenExpiry returns the last value recorded for the token expiry of the given extension and profile.
+func lastTokenExpiry(t *testing.T, id config.ComponentID, profile string) float64 {
+	rows, err := view.RetrieveData(mTokenExpiry.Name())
+	require.NoError(t, err)
+	for _, row := range rows {
+		tags := map[tag.Key]string{}
+		for _, tag := range row.Tags {
+			tags[tag.Key] = tag.Value
+		}
+		if tags[tagExtension] == id.String() && tags[tagProfile] == profile {
+			return row.Data.(*view.LastValueData).Value
+		}
+	}
+	require.Fail(t, "no token expiry recorded", "%s %q", id.String(), profile)
+	return 0
+}
+
+func TestTokenExpiryMetric(t *testing.T) {
+	// the views may already have been registered by the factory
+	_ = view.Register(MetricViews()...)
+
+	id := config.NewComponentIDWithName(typeStr, "metrics")
+	var tokenErr error
+	ts := &errorWrappingTokenSource{
+		id:     id,
+		logger: zap.NewNop(),
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			if tokenErr != nil {
+				return nil, tokenErr
+			}
+			return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+		}),
+	}
+
+	_, err := ts.Token()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), lastTokenExpiry(t, id, ""), 60)
+
+	tokenErr = errors.New("connection refused")
+	_, err = ts.Token()
+	require.Error(t, err)
+	assert.Equal(t, float64(0), lastTokenExpiry(t, id, ""))
+}
+
+func TestTokenExpiryMetricExpiredToken(t *testing.T) {
+	// the views may already have been registered by the factory
+	_ = view.Register(MetricViews()...)
+
+	id := config.NewComponentIDWithName(typeStr, "expired")
+	recordTokenExpiry(id, "", &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(-time.Minute)})
+	assert.Less(t, lastTokenExpiry(t, id, ""), float64(0))
+}
+
+func TestTokenExpiryMetricProfileLabel(t *testing.T) {
+	// the views may already have been registered by the factory
+	_ = view.Register(MetricViews()...)
+
+	server, _ := newProfileTokenServer(t)
+
+	id := config.NewComponentIDWithName(typeStr, "profiles")
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ExtensionSettings: config.NewExtensionSettings(id),
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          server.URL,
+		Profiles: map[string]TokenProfile{
+			"logs-backend": {Audience: "logs"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	sources := newProfileTokenSources(oauth2Authenticator, 0, protocolHTTP)
+	_, err = sources.forProfile("").Token()
+	require.NoError(t, err)
+	_, err = sources.forProfile("logs-backend").Token()
+	require.NoError(t, err)
+	_, err = sources.forProfile("unknown-backend").Token()
+	require.ErrorIs(t, err, errUnknownProfile)
+
+	assert.Greater(t, lastTokenExpiry(t, id, ""), float64(0))
+	assert.Greater(t, lastTokenExpiry(t, id, "logs-backend"), float64(0))
+
+	// profiles missing from the configuration don't add label values
+	rows, err := view.RetrieveData(mTokenExpiry.Name())
+	require.NoError(t, err)
+	for _, row := range rows {
+		for _, tag := range row.Tags {
+			assert.NotEqual(t, "unknown-backend", tag.Value)
+		}
+	}
+}
+
+// extensionMetric returns the data recorded by the view of the measure for the given extension.
+func extensionMetric(t *testing.T, measure string, id config.ComponentID) view.AggregationData {
+	rows, err := view.RetrieveData(measure)
+	require.NoError(t, err)
+	for _, row := range rows {
+		if len(row.Tags) == 1 && row.Tags[0].Key == tagExtension && row.Tags[0].Value == id.String() {
+			return row.Data
+		}
+	}
+	require.Fail(t, "no metric recorded", "%s %s", measure, id.String())
+	return nil
+}
+
+func TestCachedTokenSourcesMetrics(t *testing.T) {
+	// the views may already have been registered by the factory
+	_ = view.Register(MetricViews()...)
+
+	id := config.NewComponentIDWithName(typeStr, "cachedsources")
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ExtensionSettings: config.NewExtensionSettings(id),
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          "https://example.com/token",
+		Profiles: map[string]TokenProfile{
+			"logs-backend":   {Audience: "logs"},
+			"traces-backend": {Audience: "traces"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	// the caches of two exporters
+	httpSources := newProfileTokenSources(oauth2Authenticator, 2, protocolHTTP)
+	grpcSources := newProfileTokenSources(oauth2Authenticator, 2, protocolGRPC)
+	httpSources.forProfile("")
+	httpSources.forProfile("logs-backend")
+	grpcSources.forProfile("")
+	// cached already
+	httpSources.forProfile("")
+	assert.Equal(t, float64(3), extensionMetric(t, mCachedTokenSources.Name(), id).(*view.LastValueData).Value)
+
+	// evicts the source of the logs-backend profile
+	httpSources.forProfile("traces-backend")
+	assert.Equal(t, float64(3), extensionMetric(t, mCachedTokenSources.Name(), id).(*view.LastValueData).Value)
+	assert.Equal(t, float64(1), extensionMetric(t, mEvictedTokenSources.Name(), id).(*view.SumData).Value)
+
+	httpSources.forProfile("logs-backend")
+	assert.Equal(t, float64(3), extensionMetric(t, mCachedTokenSources.Name(), id).(*view.LastValueData).Value)
+	assert.Equal(t, float64(2), extensionMetric(t, mEvictedTokenSources.Name(), id).(*view.SumData).Value)
+}
@@ -0,0 +1,87 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// Handling of the nbf claim of the tokens, NotBefore of Config.
+const (
+	notBeforeWait = "wait"
+	notBeforeWarn = "warn"
+)
+
+// maxNotBeforeWait bounds the time a token not valid yet is held back, so that a token with an absurd nbf claim
+// fails the requests instead of blocking them.
+const maxNotBeforeWait = time.Minute
+
+var errNotBeforeTooFar = errors.New("the access token isn't valid before too long")
+
+// notBeforeTokenSource handles the JWT access tokens issued with an nbf claim in the future, e.g. by an
+// authorization server whose clock is ahead: they are held back until nbf, plus clockSkew, has passed, or a warning
+// is logged. Other tokens are handed out as is.
+// See https://datatracker.ietf.org/doc/html/rfc7519#section-4.1.5
+type notBeforeTokenSource struct {
+	ts        oauth2.TokenSource
+	ctx       context.Context
+	mode      string
+	clockSkew time.Duration
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+	logger    *zap.Logger
+}
+
+func (s *notBeforeTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	var claims struct {
+		NotBefore int64 `json:"nbf"`
+	}
+	if jwtClaims(token.AccessToken, &claims) != nil || claims.NotBefore == 0 {
+		return token, nil
+	}
+	notBefore := time.Unix(claims.NotBefore, 0)
+	validFrom := notBefore
+	if s.mode == notBeforeWait {
+		validFrom = notBefore.Add(s.clockSkew)
+	}
+	now := s.now()
+	if !validFrom.After(now) {
+		return token, nil
+	}
+
+	if s.mode == notBeforeWarn {
+		s.logger.Warn("The security token isn't valid yet, the clocks of the collector and the authorization server may differ",
+			zap.Time("not_before", notBefore))
+		return token, nil
+	}
+	wait := validFrom.Sub(now)
+	if wait > maxNotBeforeWait {
+		return nil, fmt.Errorf("%w: nbf %v", errNotBeforeTooFar, notBefore)
+	}
+	if err = s.sleep(s.ctx, wait); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
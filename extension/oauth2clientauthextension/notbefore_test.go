@@ -0,0 +1,142 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/oauth2"
+)
+
+func TestNotBeforeTokenSource(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		name          string
+		mode          string
+		accessToken   string
+		clockSkew     time.Duration
+		expectedWait  time.Duration
+		expectedWarns int
+		expectedErr   error
+	}{
+		{
+			name:         "future_nbf_waited_for",
+			mode:         notBeforeWait,
+			accessToken:  newTestJWT(fmt.Sprintf(`{"nbf":%d}`, now.Add(2*time.Second).Unix())),
+			expectedWait: 3 * time.Second,
+		},
+		{
+			name:         "past_nbf_within_clock_skew",
+			mode:         notBeforeWait,
+			accessToken:  newTestJWT(fmt.Sprintf(`{"nbf":%d}`, now.Add(-time.Second).Unix())),
+			clockSkew:    3 * time.Second,
+			expectedWait: 2 * time.Second,
+		},
+		{
+			name:          "future_nbf_warned_about",
+			mode:          notBeforeWarn,
+			accessToken:   newTestJWT(fmt.Sprintf(`{"nbf":%d}`, now.Add(2*time.Second).Unix())),
+			expectedWarns: 1,
+		},
+		{
+			name:        "absurd_nbf",
+			mode:        notBeforeWait,
+			accessToken: newTestJWT(fmt.Sprintf(`{"nbf":%d}`, now.Add(time.Hour).Unix())),
+			expectedErr: errNotBeforeTooFar,
+		},
+		{
+			name:        "past_nbf",
+			mode:        notBeforeWait,
+			accessToken: newTestJWT(fmt.Sprintf(`{"nbf":%d}`, now.Add(-time.Minute).Unix())),
+		},
+		{
+			name:        "without_nbf",
+			mode:        notBeforeWait,
+			accessToken: newTestJWT(`{"sub":"collector"}`),
+		},
+		{
+			name:        "opaque_token",
+			mode:        notBeforeWait,
+			accessToken: "opaque-token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			clockSkew := time.Second
+			if test.clockSkew > 0 {
+				clockSkew = test.clockSkew
+			}
+			var waits []time.Duration
+			ts := &notBeforeTokenSource{
+				ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+					return &oauth2.Token{AccessToken: test.accessToken}, nil
+				}),
+				ctx:       context.Background(),
+				mode:      test.mode,
+				clockSkew: clockSkew,
+				now:       func() time.Time { return now },
+				sleep: func(_ context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				},
+				logger: zap.New(core),
+			}
+
+			token, err := ts.Token()
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				assert.Empty(t, waits)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.accessToken, token.AccessToken)
+			if test.expectedWait > 0 {
+				assert.Equal(t, []time.Duration{test.expectedWait}, waits)
+			} else {
+				assert.Empty(t, waits)
+			}
+			assert.Equal(t, test.expectedWarns, logs.Len())
+		})
+	}
+}
+
+func TestNotBeforeWaitCancelledOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ts := &notBeforeTokenSource{
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			return &oauth2.Token{AccessToken: newTestJWT(fmt.Sprintf(`{"nbf":%d}`, time.Now().Add(30*time.Second).Unix()))}, nil
+		}),
+		ctx:    ctx,
+		mode:   notBeforeWait,
+		now:    time.Now,
+		sleep:  sleep,
+		logger: zap.NewNop(),
+	}
+
+	_, err := ts.Token()
+	assert.ErrorIs(t, err, context.Canceled)
+}
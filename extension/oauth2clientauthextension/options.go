@@ -0,0 +1,66 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+)
+
+// Option customizes the ClientCredentialsAuthenticator created by the factory.
+type Option func(*ClientCredentialsAuthenticator)
+
+// ContextDecorator returns a copy of the given context carrying additional values.
+type ContextDecorator func(context.Context) context.Context
+
+// WithContextDecorator registers a ContextDecorator applied to the context used to fetch tokens.
+// That context carries the *http.Client of the extension as the oauth2.HTTPClient value, which decorators
+// may replace. Decorators are applied in the order they are registered.
+func WithContextDecorator(decorator ContextDecorator) Option {
+	return func(o *ClientCredentialsAuthenticator) {
+		o.contextDecorators = append(o.contextDecorators, decorator)
+	}
+}
+
+// TokenAcquisition describes a token obtained from the authorization server, without the token itself.
+type TokenAcquisition struct {
+	// ID is the ID of the extension instance that obtained the token.
+	ID config.ComponentID
+	// Profile is the profile the token was obtained for, empty for the extension configuration.
+	Profile string
+	// Expiry is the time the token expires at, zero when the token doesn't expire.
+	Expiry time.Time
+	// Scopes are the scopes granted by the authorization server or, when the token response doesn't
+	// list them, the requested scopes.
+	Scopes []string
+}
+
+// WithOnSuccess registers a callback invoked every time a token is obtained from the authorization server.
+// Callbacks run synchronously before the token is used, so they should return quickly.
+func WithOnSuccess(callback func(TokenAcquisition)) Option {
+	return func(o *ClientCredentialsAuthenticator) {
+		o.onSuccess = append(o.onSuccess, callback)
+	}
+}
+
+// WithOnFailure registers a callback invoked with a *FailedToGetSecurityTokenError every time a token can't be
+// obtained from the authorization server. Callbacks run synchronously, so they should return quickly.
+func WithOnFailure(callback func(error)) Option {
+	return func(o *ClientCredentialsAuthenticator) {
+		o.onFailure = append(o.onFailure, callback)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+type tenantKey struct{}
+
+func TestWithContextDecorator(t *testing.T) {
+	var tenants []interface{}
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		tenants = append(tenants, req.Context().Value(tenantKey{}))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"access_token":"tenant-token","token_type":"bearer","expires_in":3600}`)),
+		}, nil
+	})}
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/v1/token",
+	}, zap.NewNop(),
+		WithContextDecorator(func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, tenantKey{}, "tenant-a")
+		}),
+		WithContextDecorator(func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, oauth2.HTTPClient, client)
+		}))
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-token", token.AccessToken)
+	assert.Equal(t, []interface{}{"tenant-a"}, tenants)
+}
+
+func TestFactoryAppliesOptions(t *testing.T) {
+	decorator := func(ctx context.Context) context.Context { return ctx }
+	cfg := createDefaultConfig().(*Config)
+	cfg.ClientID = "testclientid"
+	cfg.ClientSecret = "testsecret"
+	cfg.TokenURL = "https://example.com/v1/token"
+
+	ext, err := NewFactory(WithContextDecorator(decorator)).CreateExtension(context.Background(), componenttest.NewNopExtensionCreateSettings(), cfg)
+	require.NoError(t, err)
+	assert.Len(t, ext.(*ClientCredentialsAuthenticator).contextDecorators, 1)
+}
+
+func TestWithOnSuccessAndOnFailure(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if fail {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"secret-token","token_type":"bearer","expires_in":1,"scope":"api.read"}`))
+	}))
+	defer server.Close()
+
+	var acquisitions []TokenAcquisition
+	var failures []error
+	id := config.NewComponentIDWithName(typeStr, "audited")
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ExtensionSettings: config.NewExtensionSettings(id),
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          server.URL,
+		Scopes:            []string{"api.read", "api.write"},
+	}, zap.NewNop(),
+		WithOnSuccess(func(acquisition TokenAcquisition) {
+			acquisitions = append(acquisitions, acquisition)
+		}),
+		WithOnFailure(func(err error) {
+			failures = append(failures, err)
+		}))
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	before := time.Now()
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	require.Len(t, acquisitions, 1)
+	assert.Empty(t, failures)
+	assert.Equal(t, id, acquisitions[0].ID)
+	assert.Empty(t, acquisitions[0].Profile)
+	// the granted scopes are reported
+	assert.Equal(t, []string{"api.read"}, acquisitions[0].Scopes)
+	assert.WithinDuration(t, before.Add(time.Second), acquisitions[0].Expiry, time.Second)
+	assert.NotContains(t, fmt.Sprintf("%+v", acquisitions[0]), "secret-token")
+
+	// the token expires within the expiry delta of oauth2, so the next request fetches a new one, which fails
+	fail = true
+	_, err = fetchToken(oauth2Authenticator)
+	require.Error(t, err)
+	assert.Len(t, acquisitions, 1)
+	require.Len(t, failures, 1)
+	var tokenErr *FailedToGetSecurityTokenError
+	require.True(t, errors.As(failures[0], &tokenErr))
+	assert.Equal(t, "invalid_client", tokenErr.ErrorCode())
+	assert.Contains(t, failures[0].Error(), "oauth2client/audited")
+}
+
+func TestWithOnSuccessReportsRequestedScopes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	var acquisitions []TokenAcquisition
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Scopes:       []string{"api.read", "api.write"},
+	}, zap.NewNop(), WithOnSuccess(func(acquisition TokenAcquisition) {
+		acquisitions = append(acquisitions, acquisition)
+	}))
+	require.NoError(t, err)
+
+	// cached tokens are not reported again
+	ts := oauth2Authenticator.tokenSource("")
+	for i := 0; i < 2; i++ {
+		_, err = ts.Token()
+		require.NoError(t, err)
+	}
+	require.Len(t, acquisitions, 1)
+	assert.Equal(t, []string{"api.read", "api.write"}, acquisitions[0].Scopes)
+}
@@ -0,0 +1,228 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+	grpcOAuth "google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/metadata"
+)
+
+// ProfileMetadataKey is the outgoing gRPC metadata key selecting the token profile of an RPC, for callers
+// that can't set the profile of the RPC context with ContextWithProfile. It is sent to the server along with the RPC.
+const ProfileMetadataKey = "oauth2-token-profile"
+
+var errUnknownProfile = errors.New("unknown token profile")
+
+type profileKey struct{}
+
+type attributesKey struct{}
+
+// ContextWithProfile returns a copy of ctx selecting the named token profile for the requests sent with it
+// through the RoundTripper or PerRPCCredentials of the extension. Requests without a profile use the
+// audience and scopes of the extension configuration.
+func ContextWithProfile(ctx context.Context, profile string) context.Context {
+	return context.WithValue(ctx, profileKey{}, profile)
+}
+
+// ContextWithAttributes returns a copy of ctx carrying attributes of the data sent with it, e.g. the resource
+// attributes of a batch of logs. With profile_attribute, the requests sent with it through the RoundTripper or
+// PerRPCCredentials of the extension use the token profile named by the value of that attribute, unless
+// a profile is selected with ContextWithProfile.
+func ContextWithAttributes(ctx context.Context, attributes map[string]string) context.Context {
+	return context.WithValue(ctx, attributesKey{}, attributes)
+}
+
+// profileFromContext returns the profile selected by ctx, either explicitly or by the value of the given attribute.
+func profileFromContext(ctx context.Context, attribute string) string {
+	if profile, _ := ctx.Value(profileKey{}).(string); profile != "" {
+		return profile
+	}
+	if attribute == "" {
+		return ""
+	}
+	attributes, _ := ctx.Value(attributesKey{}).(map[string]string)
+	return attributes[attribute]
+}
+
+// profileFromRPCContext returns the profile selected by the context of an RPC, falling back to the
+// ProfileMetadataKey outgoing metadata when the context doesn't select a profile.
+func profileFromRPCContext(ctx context.Context, attribute string) string {
+	if profile := profileFromContext(ctx, attribute); profile != "" {
+		return profile
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if values := md.Get(ProfileMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// apply returns a copy of conf requesting tokens for the audience and scopes of the profile.
+func (p TokenProfile) apply(conf *clientcredentials.Config) *clientcredentials.Config {
+	custom := *conf
+	if len(p.Scopes) > 0 {
+		custom.Scopes = p.Scopes
+	}
+	if p.Audience != "" {
+		custom.EndpointParams = url.Values{}
+		for k, v := range conf.EndpointParams {
+			custom.EndpointParams[k] = v
+		}
+		custom.EndpointParams.Set("audience", p.Audience)
+	}
+	return &custom
+}
+
+// defaultMaxCachedTokenSources is the number of profile token sources kept when max_cached_token_sources isn't set.
+const defaultMaxCachedTokenSources = 100
+
+// mergeScopes returns the union of the default scopes and the given ones, in order and without duplicates.
+func mergeScopes(defaults, scopes []string) []string {
+	merged := make([]string, 0, len(defaults)+len(scopes))
+	seen := make(map[string]bool, len(defaults)+len(scopes))
+	for _, list := range [][]string{defaults, scopes} {
+		for _, scope := range list {
+			if !seen[scope] {
+				seen[scope] = true
+				merged = append(merged, scope)
+			}
+		}
+	}
+	return merged
+}
+
+// profileTokenSources hands out one token source per profile, so that each profile caches its own token.
+// The least recently used token sources, along with their token, are dropped once there are more than max of them.
+type profileTokenSources struct {
+	o        *ClientCredentialsAuthenticator
+	max      int
+	protocol string
+
+	mu      sync.Mutex
+	sources map[string]*list.Element
+	// lru holds the cachedTokenSource values, most recently used first.
+	lru *list.List
+}
+
+type cachedTokenSource struct {
+	profile string
+	ts      oauth2.TokenSource
+}
+
+func newProfileTokenSources(o *ClientCredentialsAuthenticator, max int, protocol string) *profileTokenSources {
+	if max <= 0 {
+		max = defaultMaxCachedTokenSources
+	}
+	return &profileTokenSources{
+		o:        o,
+		max:      max,
+		protocol: protocol,
+		sources:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// forContext returns the token source of the profile selected by ctx.
+func (p *profileTokenSources) forContext(ctx context.Context) oauth2.TokenSource {
+	return p.forProfile(profileFromContext(ctx, p.o.profileAttr))
+}
+
+// forProfile returns the token source of the named profile. The token source of a profile that isn't configured,
+// failing token requests, isn't cached, so that unknown profile names, e.g. attribute values, don't evict the token
+// sources of the configured profiles.
+func (p *profileTokenSources) forProfile(profile string) oauth2.TokenSource {
+	if !p.o.hasProfile(profile) {
+		return p.o.protocolTokenSource(p.protocol, profile)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elem, ok := p.sources[profile]; ok {
+		p.lru.MoveToFront(elem)
+		return elem.Value.(*cachedTokenSource).ts
+	}
+
+	ts := p.o.protocolTokenSource(p.protocol, profile)
+	p.sources[profile] = p.lru.PushFront(&cachedTokenSource{profile: profile, ts: ts})
+	cached := atomic.AddInt32(&p.o.cachedSources, 1)
+	evict := p.lru.Len() > p.max
+	if evict {
+		evicted := p.lru.Remove(p.lru.Back()).(*cachedTokenSource)
+		delete(p.sources, evicted.profile)
+		cached = atomic.AddInt32(&p.o.cachedSources, -1)
+	}
+	recordCachedTokenSources(p.o.id, cached, evict)
+	return ts
+}
+
+// profileRoundTripper authorizes requests with a token of the profile selected by their context.
+type profileRoundTripper struct {
+	sources *profileTokenSources
+	base    http.RoundTripper
+}
+
+func (rt *profileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.sources.o.tokenTransport(rt.sources.forContext(req.Context()), rt.base).RoundTrip(req)
+}
+
+// profilePerRPCCredentials authorizes RPCs with a token of the profile selected by their context or their metadata.
+type profilePerRPCCredentials struct {
+	sources *profileTokenSources
+}
+
+var _ credentials.PerRPCCredentials = (*profilePerRPCCredentials)(nil)
+
+func (c *profilePerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return grpcOAuth.TokenSource{TokenSource: c.sources.forProfile(profileFromRPCContext(ctx, c.sources.o.profileAttr))}.GetRequestMetadata(ctx, uri...)
+}
+
+func (c *profilePerRPCCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// hasProfile reports whether the named profile is configured, the empty name standing for the extension configuration.
+func (o *ClientCredentialsAuthenticator) hasProfile(profile string) bool {
+	if profile == "" {
+		return true
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	_, ok := o.profiles[profile]
+	return ok
+}
+
+// profileConfig returns the token request configuration of the named profile.
+func (o *ClientCredentialsAuthenticator) profileConfig(conf *clientcredentials.Config, profile string) (*clientcredentials.Config, error) {
+	if profile == "" {
+		return conf, nil
+	}
+	p, ok := o.profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownProfile, profile)
+	}
+	return p.apply(conf), nil
+}
@@ -0,0 +1,473 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// newProfileTokenServer returns a token endpoint issuing tokens naming the requested audience and scopes,
+// and rejecting the audiences starting with "unknown", along with the number of token requests per audience.
+func newProfileTokenServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		audience := r.PostForm.Get("audience")
+		mu.Lock()
+		requests[audience]++
+		mu.Unlock()
+		if strings.HasPrefix(audience, "unknown") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_target"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		token := strings.Join([]string{audience, r.PostForm.Get("scope")}, "|")
+		_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":3600}`, token)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestProfileSelection(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Scopes:       []string{"default.read"},
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory", Scopes: []string{"inventory.write"}},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var authorizations []string
+	roundTripper, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+
+	for _, profile := range []string{"billing", "inventory", "", "billing", "inventory", ""} {
+		ctx := context.Background()
+		if profile != "" {
+			ctx = ContextWithProfile(ctx, profile)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		_, err = roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"Bearer billing|default.read",
+		"Bearer inventory|inventory.write",
+		"Bearer |default.read",
+		"Bearer billing|default.read",
+		"Bearer inventory|inventory.write",
+		"Bearer |default.read",
+	}, authorizations)
+	// every profile caches its own token
+	assert.Equal(t, map[string]int{"billing": 1, "inventory": 1, "": 1}, requests)
+}
+
+func TestUnknownProfile(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing": {Audience: "billing"},
+		},
+		MaxCachedTokenSources: 1,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	roundTripper, err := oauth2Authenticator.RoundTripper(&testRoundTripper{})
+	require.NoError(t, err)
+	send := func(profile string) error {
+		req, err := http.NewRequestWithContext(ContextWithProfile(context.Background(), profile),
+			http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		_, err = roundTripper.RoundTrip(req)
+		return err
+	}
+
+	require.NoError(t, send("billing"))
+	assert.ErrorIs(t, send("shipping"), errUnknownProfile)
+	assert.ErrorIs(t, send("receiving"), errUnknownProfile)
+
+	// unknown profiles don't evict the token source of the configured one
+	require.NoError(t, send("billing"))
+	assert.Equal(t, map[string]int{"billing": 1}, requests)
+	assert.EqualValues(t, 1, oauth2Authenticator.cachedSources)
+}
+
+func TestProfilePerRPCCredentials(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing": {Audience: "billing"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	profileCredentials, ok := perRPCCredentials.(*profilePerRPCCredentials)
+	require.True(t, ok)
+	assert.True(t, profileCredentials.RequireTransportSecurity())
+
+	ctx := ContextWithProfile(context.Background(), "billing")
+	for i := 0; i < 2; i++ {
+		token, err := profileCredentials.sources.forContext(ctx).Token()
+		require.NoError(t, err)
+		assert.Equal(t, "billing|", token.AccessToken)
+	}
+	assert.Equal(t, map[string]int{"billing": 1}, requests)
+}
+
+func TestProfileMetadataSelection(t *testing.T) {
+	tokenServer, requests := newProfileTokenServer(t)
+
+	// the bearer token is only sent over secure connections, borrow the certificate of a TLS test server
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := tlsServer.TLS.Certificates[0]
+	tlsServer.Close()
+
+	var mu sync.Mutex
+	var authorizations []string
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewServerTLSFromCert(&cert)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			mu.Lock()
+			authorizations = append(authorizations, md.Get("authorization")...)
+			mu.Unlock()
+			return handler(ctx, req)
+		}),
+	)
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	conn, err := grpc.Dial(lis.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})), // #nosec
+		grpc.WithPerRPCCredentials(perRPCCredentials))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	contexts := []context.Context{
+		metadata.AppendToOutgoingContext(context.Background(), ProfileMetadataKey, "billing"),
+		metadata.AppendToOutgoingContext(context.Background(), ProfileMetadataKey, "inventory"),
+		context.Background(),
+		// the profile of the context takes precedence
+		metadata.AppendToOutgoingContext(ContextWithProfile(context.Background(), "inventory"), ProfileMetadataKey, "billing"),
+	}
+	for _, ctx := range contexts {
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"Bearer billing|", "Bearer inventory|", "Bearer |", "Bearer inventory|"}, authorizations)
+	assert.Equal(t, map[string]int{"billing": 1, "inventory": 1, "": 1}, requests)
+
+	_, err = client.Check(metadata.AppendToOutgoingContext(context.Background(), ProfileMetadataKey, "unknown"),
+		&grpc_health_v1.HealthCheckRequest{})
+	assert.Error(t, err)
+}
+
+func TestProfileTokenSourcesEviction(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory"},
+			"shipping":  {Audience: "shipping"},
+		},
+		MaxCachedTokenSources: 2,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	sources := newProfileTokenSources(oauth2Authenticator, oauth2Authenticator.maxCachedSources, protocolHTTP)
+	fetch := func(profile string) {
+		_, err := sources.forContext(ContextWithProfile(context.Background(), profile)).Token()
+		require.NoError(t, err)
+	}
+
+	fetch("billing")
+	fetch("inventory")
+	// billing becomes the most recently used, inventory is evicted by shipping
+	fetch("billing")
+	fetch("shipping")
+	assert.Equal(t, 2, sources.lru.Len())
+	assert.Contains(t, sources.sources, "billing")
+	assert.Contains(t, sources.sources, "shipping")
+	assert.NotContains(t, sources.sources, "inventory")
+
+	// the token of the evicted profile was dropped along with its token source
+	fetch("inventory")
+	fetch("shipping")
+	assert.Equal(t, map[string]int{"billing": 1, "inventory": 2, "shipping": 1}, requests)
+}
+
+func TestProfileTokenSourcesDefaultBound(t *testing.T) {
+	sources := newProfileTokenSources(&ClientCredentialsAuthenticator{}, 0, protocolHTTP)
+	assert.Equal(t, defaultMaxCachedTokenSources, sources.max)
+}
+
+func TestDefaultScopes(t *testing.T) {
+	server, _ := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:      "testclientid",
+		ClientSecret:  "testsecret",
+		TokenURL:      server.URL,
+		DefaultScopes: []string{"openid", "telemetry.write"},
+		Scopes:        []string{"telemetry.write", "default.read"},
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory", Scopes: []string{"inventory.write", "openid"}},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	sources := newProfileTokenSources(oauth2Authenticator, 0, protocolHTTP)
+	tests := []struct {
+		profile       string
+		expectedToken string
+	}{
+		{
+			profile:       "",
+			expectedToken: "|openid telemetry.write default.read",
+		},
+		{
+			profile:       "billing",
+			expectedToken: "billing|openid telemetry.write default.read",
+		},
+		{
+			profile:       "inventory",
+			expectedToken: "inventory|openid telemetry.write inventory.write",
+		},
+	}
+	for _, test := range tests {
+		token, err := sources.forContext(ContextWithProfile(context.Background(), test.profile)).Token()
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedToken, token.AccessToken)
+	}
+}
+
+func TestMergeScopes(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, mergeScopes([]string{"a", "b", "a"}, []string{"b", "c", "c"}))
+	assert.Equal(t, []string{"a"}, mergeScopes(nil, []string{"a"}))
+	assert.Empty(t, mergeScopes(nil, nil))
+}
+
+func TestProfileAttributeSelection(t *testing.T) {
+	server, _ := newProfileTokenServer(t)
+
+	tests := []struct {
+		name             string
+		profileAttribute string
+		ctx              context.Context
+		expectedToken    string
+	}{
+		{
+			name:             "attribute_selects_profile",
+			profileAttribute: "tenant.id",
+			ctx:              ContextWithAttributes(context.Background(), map[string]string{"tenant.id": "billing", "host.name": "inventory"}),
+			expectedToken:    "billing|",
+		},
+		{
+			name:             "missing_attribute",
+			profileAttribute: "tenant.id",
+			ctx:              ContextWithAttributes(context.Background(), map[string]string{"host.name": "inventory"}),
+			expectedToken:    "|",
+		},
+		{
+			name:             "explicit_profile_takes_precedence",
+			profileAttribute: "tenant.id",
+			ctx:              ContextWithProfile(ContextWithAttributes(context.Background(), map[string]string{"tenant.id": "billing"}), "inventory"),
+			expectedToken:    "inventory|",
+		},
+		{
+			name:          "attributes_ignored_without_profile_attribute",
+			ctx:           ContextWithAttributes(context.Background(), map[string]string{"tenant.id": "billing"}),
+			expectedToken: "|",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				Profiles: map[string]TokenProfile{
+					"billing":   {Audience: "billing"},
+					"inventory": {Audience: "inventory"},
+				},
+				ProfileAttribute: test.profileAttribute,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			var authorization string
+			rt, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				authorization = req.Header.Get("Authorization")
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}))
+			require.NoError(t, err)
+
+			req, err := http.NewRequestWithContext(test.ctx, http.MethodPost, "https://logs.example.com/v1/logs", nil)
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, "Bearer "+test.expectedToken, authorization)
+		})
+	}
+}
+
+func TestProtocolScopes(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Scopes:       []string{"default.read"},
+		HTTPScopes:   []string{"http.write"},
+		GRPCScopes:   []string{"grpc.write"},
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory", Scopes: []string{"inventory.write"}},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var authorizations []string
+	roundTripper, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+	perRPCCredentials, err := oauth2Authenticator.PerRPCCredentials()
+	require.NoError(t, err)
+	grpcSources := perRPCCredentials.(*profilePerRPCCredentials).sources
+
+	for _, profile := range []string{"", "billing", "inventory", ""} {
+		ctx := context.Background()
+		if profile != "" {
+			ctx = ContextWithProfile(ctx, profile)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		_, err = roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+	}
+	var grpcTokens []string
+	for _, profile := range []string{"", "billing", "inventory", ""} {
+		token, err := grpcSources.forContext(ContextWithProfile(context.Background(), profile)).Token()
+		require.NoError(t, err)
+		grpcTokens = append(grpcTokens, token.AccessToken)
+	}
+
+	assert.Equal(t, []string{
+		"Bearer |http.write",
+		"Bearer billing|http.write",
+		"Bearer inventory|inventory.write",
+		"Bearer |http.write",
+	}, authorizations)
+	assert.Equal(t, []string{"|grpc.write", "billing|grpc.write", "inventory|inventory.write", "|grpc.write"}, grpcTokens)
+	// the tokens of each protocol are cached apart
+	assert.Equal(t, map[string]int{"billing": 2, "inventory": 2, "": 2}, requests)
+}
+
+func TestProtocolScopesNotSet(t *testing.T) {
+	server, _ := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Scopes:       []string{"default.read"},
+		GRPCScopes:   []string{"grpc.write"},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var authorization string
+	roundTripper, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authorization = req.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+	_, err = roundTripper.RoundTrip(req)
+	require.NoError(t, err)
+	// the HTTP exporters keep the shared scopes
+	assert.Equal(t, "Bearer |default.read", authorization)
+}
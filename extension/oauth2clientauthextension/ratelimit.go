@@ -0,0 +1,43 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitRoundTripper paces the requests sent to the authorization server, retries included, to the configured rate.
+// Requests beyond the budget wait for their turn, unless the deadline of their context would be exceeded first.
+type rateLimitRoundTripper struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func newRateLimitRoundTripper(base http.RoundTripper, requestsPerSecond float64, burst int) *rateLimitRoundTripper {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimitRoundTripper{base: base, limiter: rate.NewLimiter(rate.Limit(requestsPerSecond), burst)}
+}
+
+func (r *rateLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := r.limiter.Wait(req.Context()); err != nil {
+		return nil, fmt.Errorf("token request rate limit: %w", err)
+	}
+	return r.base.RoundTrip(req)
+}
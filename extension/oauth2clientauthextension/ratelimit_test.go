@@ -0,0 +1,84 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenRequestRateLimit(t *testing.T) {
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		// the token expires within the expiry delta of oauth2, so that every Token call requests a new one
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":1}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:               "testclientid",
+		ClientSecret:           "testsecret",
+		TokenURL:               server.URL,
+		TokenRequestsPerSecond: 20,
+		TokenRequestsBurst:     2,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	ts := oauth2Authenticator.tokenSource("")
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err = ts.Token()
+		require.NoError(t, err)
+	}
+	require.Len(t, requests, 5)
+
+	// the burst is sent right away, the following requests are sent every 50ms
+	assert.Less(t, int64(requests[1].Sub(start)), int64(40*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(requests[4].Sub(start)), int64(140*time.Millisecond))
+}
+
+func TestTokenRequestRateLimitContext(t *testing.T) {
+	var sent int
+	rt := newRateLimitRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), 0.1, 0)
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/v1/token", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// the next request would be sent in 10s, after the deadline of its context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = rt.RoundTrip(req.WithContext(ctx))
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	assert.Equal(t, 1, sent)
+}
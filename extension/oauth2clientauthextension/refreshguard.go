@@ -0,0 +1,66 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshGuardWindow is the window of max_refreshes_per_minute.
+const refreshGuardWindow = time.Minute
+
+var errRefreshLoop = errors.New("max_refreshes_per_minute reached, the authorization server may be issuing tokens that expire right away")
+
+// refreshGuardTokenSource fails the token requests beyond maxRefreshes in the last minute without sending them, to
+// break refresh loops hammering the authorization server, e.g. when it issues tokens that are already expired.
+type refreshGuardTokenSource struct {
+	ts           oauth2.TokenSource
+	maxRefreshes int
+	now          func() time.Time
+
+	mu        sync.Mutex
+	refreshes []time.Time
+}
+
+func (s *refreshGuardTokenSource) Token() (*oauth2.Token, error) {
+	if err := s.record(); err != nil {
+		return nil, err
+	}
+	return s.ts.Token()
+}
+
+// record counts a token request, unless maxRefreshes were already sent in the last minute.
+func (s *refreshGuardTokenSource) record() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	recent := s.refreshes[:0]
+	for _, refresh := range s.refreshes {
+		if now.Sub(refresh) < refreshGuardWindow {
+			recent = append(recent, refresh)
+		}
+	}
+	s.refreshes = recent
+	if len(s.refreshes) >= s.maxRefreshes {
+		return fmt.Errorf("%w: %d token requests since %v", errRefreshLoop, len(s.refreshes), s.refreshes[0])
+	}
+	s.refreshes = append(s.refreshes, now)
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestMaxRefreshesPerMinute(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		// the token expires before it can be used, every request for a token refreshes it
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":1}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:              "testclientid",
+		ClientSecret:          "testsecret",
+		TokenURL:              server.URL,
+		MaxRefreshesPerMinute: 3,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	ts := oauth2Authenticator.tokenSource("")
+	for i := 0; i < 3; i++ {
+		_, err = ts.Token()
+		require.NoError(t, err)
+	}
+	_, err = ts.Token()
+	assert.ErrorIs(t, err, errRefreshLoop)
+	assert.Equal(t, 3, requests)
+}
+
+func TestRefreshGuardWindow(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	ts := &refreshGuardTokenSource{
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			return &oauth2.Token{AccessToken: "test-token"}, nil
+		}),
+		maxRefreshes: 2,
+		now:          func() time.Time { return now },
+	}
+
+	refresh := func(elapsed time.Duration) error {
+		now = now.Add(elapsed)
+		_, err := ts.Token()
+		return err
+	}
+	assert.NoError(t, refresh(0))
+	assert.NoError(t, refresh(30*time.Second))
+	assert.ErrorIs(t, refresh(20*time.Second), errRefreshLoop)
+	// the first refresh is a minute old
+	assert.NoError(t, refresh(10*time.Second))
+	assert.ErrorIs(t, refresh(time.Second), errRefreshLoop)
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,61 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+var errMissingRequiredScopes = errors.New("the token isn't granted the required scopes")
+
+// requiredScopesTokenSource rejects the tokens that aren't granted all the required scopes, which would have the
+// requests of the exporters rejected by the backend. The granted scopes are the scope of the token response or,
+// when the response omits it, the requested ones.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type requiredScopesTokenSource struct {
+	ts        oauth2.TokenSource
+	required  []string
+	requested []string
+}
+
+func (s *requiredScopesTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	granted := s.requested
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		granted = strings.Fields(scope)
+	}
+
+	grantedSet := make(map[string]struct{}, len(granted))
+	for _, scope := range granted {
+		grantedSet[scope] = struct{}{}
+	}
+	var missing []string
+	for _, scope := range s.required {
+		if _, ok := grantedSet[scope]; !ok {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: missing %q, granted %q", errMissingRequiredScopes, strings.Join(missing, " "), strings.Join(granted, " "))
+	}
+	return token, nil
+}
@@ -0,0 +1,101 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		grantedBody string
+		shouldError bool
+	}{
+		{
+			name:        "granted",
+			grantedBody: `,"scope":"metrics.write logs.write traces.write"`,
+		},
+		{
+			// the requested scopes are granted when the response omits the scope
+			name: "scope_omitted",
+		},
+		{
+			name:        "not_granted",
+			grantedBody: `,"scope":"metrics.write"`,
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600` + test.grantedBody + `}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:       "testclientid",
+				ClientSecret:   "testsecret",
+				TokenURL:       server.URL,
+				Scopes:         []string{"metrics.write", "logs.write"},
+				RequiredScopes: []string{"logs.write", "metrics.write"},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, errMissingRequiredScopes))
+				assert.Contains(t, err.Error(), `missing "logs.write"`)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestRequiredScopesValidateOnStart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600,"scope":"metrics.write"}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:        "testclientid",
+		ClientSecret:    "testsecret",
+		TokenURL:        server.URL,
+		RequiredScopes:  []string{"metrics.write", "logs.write"},
+		ValidateOnStart: true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	err = oauth2Authenticator.Start(context.Background(), nil)
+	assert.True(t, errors.Is(err, errMissingRequiredScopes))
+}
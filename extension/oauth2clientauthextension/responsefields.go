@@ -0,0 +1,106 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fieldPaths returns the standard token response fields with the JSON path they are read from.
+func (m ResponseFieldMap) fieldPaths() map[string]string {
+	paths := map[string]string{}
+	if m.AccessToken != "" {
+		paths["access_token"] = m.AccessToken
+	}
+	if m.TokenType != "" {
+		paths["token_type"] = m.TokenType
+	}
+	if m.ExpiresIn != "" {
+		paths["expires_in"] = m.ExpiresIn
+	}
+	return paths
+}
+
+// validPath tells whether path is made of dot-separated, non-empty field names.
+func validPath(path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath returns the value found at the dot-separated path of a decoded JSON document.
+func lookupPath(document interface{}, path string) (interface{}, bool) {
+	value := document
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// fieldMapRoundTripper rewrites successful JSON token responses not following the standard shape, copying the
+// fields found at the configured paths to the standard top-level fields, so that they are parsed as usual.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type fieldMapRoundTripper struct {
+	base  http.RoundTripper
+	paths map[string]string
+}
+
+func (f *fieldMapRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := f.base.RoundTrip(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+	if contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); contentType != "application/json" {
+		return resp, nil
+	}
+
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if json.Unmarshal(body, &document) != nil {
+		// left for the oauth2 package to report
+		return resp, nil
+	}
+	for field, path := range f.paths {
+		if value, ok := lookupPath(document, path); ok {
+			document[field] = value
+		}
+	}
+	if body, err = json.Marshal(document); err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return resp, nil
+}
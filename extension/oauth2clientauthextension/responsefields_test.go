@@ -0,0 +1,125 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestResponseFieldMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		fieldMap      ResponseFieldMap
+		response      string
+		shouldError   bool
+		expectedToken string
+		expectedType  string
+		expectedTTL   time.Duration
+	}{
+		{
+			name:          "standard_response",
+			response:      `{"access_token":"test-token","token_type":"bearer","expires_in":3600}`,
+			expectedToken: "test-token",
+			expectedType:  "Bearer",
+			expectedTTL:   time.Hour,
+		},
+		{
+			name:          "nested_fields",
+			fieldMap:      ResponseFieldMap{AccessToken: "data.token", TokenType: "data.kind", ExpiresIn: "data.ttl"},
+			response:      `{"data":{"token":"nested-token","kind":"bearer","ttl":3600}}`,
+			expectedToken: "nested-token",
+			expectedType:  "Bearer",
+			expectedTTL:   time.Hour,
+		},
+		{
+			name:          "deeply_nested_string_lifetime",
+			fieldMap:      ResponseFieldMap{AccessToken: "result.credentials.token", ExpiresIn: "result.credentials.ttl"},
+			response:      `{"result":{"credentials":{"token":"nested-token","ttl":"600"}}}`,
+			expectedToken: "nested-token",
+			expectedType:  "Bearer",
+			expectedTTL:   10 * time.Minute,
+		},
+		{
+			name:          "unset_fields_read_from_standard_location",
+			fieldMap:      ResponseFieldMap{AccessToken: "data.token"},
+			response:      `{"data":{"token":"nested-token"},"token_type":"bearer","expires_in":60}`,
+			expectedToken: "nested-token",
+			expectedType:  "Bearer",
+			expectedTTL:   time.Minute,
+		},
+		{
+			name:        "missing_field",
+			fieldMap:    ResponseFieldMap{AccessToken: "data.token"},
+			response:    `{"data":"nested-token"}`,
+			shouldError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:         "testclientid",
+				ClientSecret:     "testsecret",
+				TokenURL:         server.URL,
+				ResponseFieldMap: test.fieldMap,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "missing access_token")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedToken, token.AccessToken)
+			assert.Equal(t, test.expectedType, token.Type())
+			assert.WithinDuration(t, time.Now().Add(test.expectedTTL), token.Expiry, 5*time.Second)
+		})
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	document := map[string]interface{}{
+		"data": map[string]interface{}{"token": "test-token"},
+		"list": []interface{}{"a"},
+	}
+
+	value, ok := lookupPath(document, "data.token")
+	assert.True(t, ok)
+	assert.Equal(t, "test-token", value)
+
+	_, ok = lookupPath(document, "data.ttl")
+	assert.False(t, ok)
+	_, ok = lookupPath(document, "list.token")
+	assert.False(t, ok)
+
+	assert.True(t, validPath("data.token"))
+	assert.False(t, validPath("data..token"))
+	assert.False(t, validPath(".token"))
+}
@@ -0,0 +1,224 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRetryableStatusCodes are the token endpoint response codes retried when
+// RetrySettings.RetryableStatusCodes is not set.
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Jitter strategies of RetrySettings.JitterStrategy.
+const (
+	jitterFull  = "full"
+	jitterEqual = "equal"
+	jitterNone  = "none"
+)
+
+// retryBackoffKey is the context key of the retryBackoff shared by the token requests of a token source.
+type retryBackoffKey struct{}
+
+// retryBackoff holds the interval to wait before the next retry. It is kept across the token requests of a token source,
+// so that an authorization server failing again is not retried more aggressively than the last time.
+type retryBackoff struct {
+	mu       sync.Mutex
+	interval time.Duration
+}
+
+// next returns the interval to wait before the next retry and doubles the following one, up to maxInterval.
+func (b *retryBackoff) next(initialInterval, maxInterval time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.interval == 0 {
+		b.interval = initialInterval
+	}
+	interval := b.interval
+	b.interval *= 2
+	if maxInterval > 0 && b.interval > maxInterval {
+		b.interval = maxInterval
+	}
+	return interval
+}
+
+func (b *retryBackoff) reset() {
+	b.mu.Lock()
+	b.interval = 0
+	b.mu.Unlock()
+}
+
+// retryRoundTripper retries token requests failing with a connection error, a timeout or a retryable status code,
+// up to maxRetries times and for up to maxElapsedTime, whichever comes first when both are set, as long as the retry
+// budget, if any, allows.
+type retryRoundTripper struct {
+	base            http.RoundTripper
+	maxRetries      int
+	maxElapsedTime  time.Duration
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	jitterStrategy  string
+	resetOnSuccess  bool
+	retryableCodes  map[int]bool
+	budget          *rate.Limiter
+	sleep           func(ctx context.Context, d time.Duration) error
+	now             func() time.Time
+	random          func() float64
+}
+
+func newRetryRoundTripper(base http.RoundTripper, settings RetrySettings) *retryRoundTripper {
+	codes := settings.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryableStatusCodes
+	}
+	retryableCodes := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		retryableCodes[code] = true
+	}
+	var budget *rate.Limiter
+	if settings.BudgetPerSecond > 0 {
+		burst := settings.BudgetBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		budget = rate.NewLimiter(rate.Limit(settings.BudgetPerSecond), burst)
+	}
+	return &retryRoundTripper{
+		base:            base,
+		maxRetries:      settings.MaxRetries,
+		maxElapsedTime:  settings.MaxElapsedTime,
+		initialInterval: settings.InitialInterval,
+		maxInterval:     settings.MaxInterval,
+		jitterStrategy:  settings.JitterStrategy,
+		resetOnSuccess:  settings.ResetOnSuccess,
+		retryableCodes:  retryableCodes,
+		budget:          budget,
+		sleep:           sleep,
+		now:             time.Now,
+		random:          rand.Float64,
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff, ok := req.Context().Value(retryBackoffKey{}).(*retryBackoff)
+	if !ok {
+		// requests made outside of a token source don't share their backoff
+		backoff = &retryBackoff{}
+	}
+	start := r.now()
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := r.base.RoundTrip(attemptReq)
+		if err == nil {
+			// the connection may fail while the response is read, which is worth retrying as well
+			if _, err = readResponseBody(resp); err != nil {
+				resp = nil
+			}
+		}
+		retry := (r.maxRetries == 0 || attempt < r.maxRetries) && r.shouldRetry(req, resp, err)
+		var wait time.Duration
+		if retry {
+			wait = r.jitter(backoff.next(r.initialInterval, r.maxInterval))
+			retry = r.withinElapsedTime(start, wait)
+		}
+		if retry && r.budget != nil {
+			// the budget is shared by the token requests of all the token sources, using the same client
+			retry = r.budget.Allow()
+		}
+		if !retry {
+			if r.resetOnSuccess && err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				backoff.reset()
+			}
+			return resp, err
+		}
+		if resp != nil {
+			// drain the body so that the underlying connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err = r.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// jitter returns the time to wait for the given backoff interval: anywhere up to the interval with full jitter, between
+// half of it and the interval with equal jitter, and the interval itself without jitter. The backoff keeps growing from
+// the interval, not from the time waited.
+func (r *retryRoundTripper) jitter(interval time.Duration) time.Duration {
+	switch r.jitterStrategy {
+	case jitterNone:
+		return interval
+	case jitterEqual:
+		half := interval / 2
+		return half + time.Duration(r.random()*float64(interval-half))
+	default:
+		return time.Duration(r.random() * float64(interval))
+	}
+}
+
+// withinElapsedTime reports whether a retry after waiting for the given interval starts before maxElapsedTime has
+// elapsed since the first attempt, if set. Otherwise, the last failure is returned right away instead of waiting
+// in vain. The deadline of the request context bounds the retries as well, as waiting stops when it's reached.
+func (r *retryRoundTripper) withinElapsedTime(start time.Time, wait time.Duration) bool {
+	return r.maxElapsedTime <= 0 || !r.now().Add(wait).After(start.Add(r.maxElapsedTime))
+}
+
+func (r *retryRoundTripper) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		// the request body can't be replayed
+		return false
+	}
+	if err != nil {
+		// permanent failures, like an untrusted certificate, fail the same way when retried
+		return req.Context().Err() == nil && temporaryNetworkError(err)
+	}
+	return r.retryableCodes[resp.StatusCode]
+}
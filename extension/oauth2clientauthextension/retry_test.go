@@ -0,0 +1,469 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// newFlakyTokenServer returns a token endpoint answering the first len(failures) requests
+// with the given status codes and any further request with a valid token.
+func newFlakyTokenServer(t *testing.T, failures ...int) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= len(failures) {
+			w.WriteHeader(failures[requests-1])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryableStatusCodes(t *testing.T) {
+	tests := []struct {
+		name             string
+		retryable        []int
+		failures         []int
+		shouldError      bool
+		expectedRequests int
+	}{
+		{
+			name:             "default_codes_retry_5xx_and_429",
+			failures:         []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			expectedRequests: 3,
+		},
+		{
+			name:             "default_codes_do_not_retry_408",
+			failures:         []int{http.StatusRequestTimeout},
+			shouldError:      true,
+			expectedRequests: 1,
+		},
+		{
+			name:             "custom_codes_retry_408_and_425",
+			retryable:        []int{http.StatusRequestTimeout, http.StatusTooEarly},
+			failures:         []int{http.StatusRequestTimeout, http.StatusTooEarly},
+			expectedRequests: 3,
+		},
+		{
+			name:             "custom_codes_replace_defaults",
+			retryable:        []int{http.StatusRequestTimeout},
+			failures:         []int{http.StatusServiceUnavailable},
+			shouldError:      true,
+			expectedRequests: 1,
+		},
+		{
+			name:             "gives_up_after_max_retries",
+			retryable:        []int{http.StatusTooEarly},
+			failures:         []int{http.StatusTooEarly, http.StatusTooEarly, http.StatusTooEarly, http.StatusTooEarly},
+			shouldError:      true,
+			expectedRequests: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newFlakyTokenServer(t, test.failures...)
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				Retry: RetrySettings{
+					MaxRetries:           3,
+					InitialInterval:      time.Millisecond,
+					MaxInterval:          5 * time.Millisecond,
+					RetryableStatusCodes: test.retryable,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			// avoid the second request of the auth style auto detection
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.shouldError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "test-token", token.AccessToken)
+			}
+			assert.Equal(t, test.expectedRequests, *requests)
+		})
+	}
+}
+
+func TestRetryDisabledByDefault(t *testing.T) {
+	server, requests := newFlakyTokenServer(t, http.StatusServiceUnavailable)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	_, err = fetchToken(oauth2Authenticator)
+	assert.Error(t, err)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestRetryNotOnPermanentFailure(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	// the certificate of the test server isn't trusted by the extension
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Retry: RetrySettings{
+			MaxRetries:      3,
+			InitialInterval: time.Millisecond,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, connections)
+}
+
+func TestRetryStopsOnContextCancellation(t *testing.T) {
+	server, requests := newFlakyTokenServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	rt := newRetryRoundTripper(http.DefaultTransport, RetrySettings{
+		MaxRetries:      3,
+		InitialInterval: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+	require.NoError(t, err)
+
+	_, err = rt.RoundTrip(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, *requests)
+}
+
+func TestRetryBackoffResetOnSuccess(t *testing.T) {
+	tests := []struct {
+		name           string
+		resetOnSuccess bool
+		expectedWaits  []time.Duration
+	}{
+		{
+			name:           "reset",
+			resetOnSuccess: true,
+			expectedWaits:  []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond},
+		},
+		{
+			name:          "no_reset",
+			expectedWaits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// fail, then succeed on retry, then fail again
+			statuses := []int{
+				http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable, http.StatusOK,
+				http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := statuses[0]
+				statuses = statuses[1:]
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				// the token expires within the expiry delta of oauth2, so that every Token call requests a new one
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":1}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				Retry: RetrySettings{
+					MaxRetries:      1,
+					InitialInterval: 100 * time.Millisecond,
+					MaxInterval:     time.Second,
+					JitterStrategy:  jitterNone,
+					ResetOnSuccess:  test.resetOnSuccess,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			var waits []time.Duration
+			rt := oauth2Authenticator.client.Transport.(*acceptJSONRoundTripper).base.(*errorResponseRoundTripper).base.(*retryRoundTripper)
+			rt.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			ts := oauth2Authenticator.tokenSource("")
+			_, err = ts.Token()
+			assert.Error(t, err)
+			_, err = ts.Token()
+			assert.NoError(t, err)
+			_, err = ts.Token()
+			assert.Error(t, err)
+
+			assert.Equal(t, test.expectedWaits, waits)
+			assert.Empty(t, statuses)
+		})
+	}
+}
+
+func TestRetryMaxElapsedTime(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxRetries       int
+		expectedWaits    []time.Duration
+		expectedRequests int
+	}{
+		{
+			// the next wait, 800ms, would end after the 1s budget
+			name:             "elapsed_time_only",
+			expectedWaits:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+			expectedRequests: 4,
+		},
+		{
+			name:             "max_retries_reached_first",
+			maxRetries:       2,
+			expectedWaits:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+			expectedRequests: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures := make([]int, 10)
+			for i := range failures {
+				failures[i] = http.StatusServiceUnavailable
+			}
+			server, requests := newFlakyTokenServer(t, failures...)
+
+			rt := newRetryRoundTripper(http.DefaultTransport, RetrySettings{
+				MaxRetries:      test.maxRetries,
+				MaxElapsedTime:  time.Second,
+				InitialInterval: 100 * time.Millisecond,
+				MaxInterval:     time.Second,
+				JitterStrategy:  jitterNone,
+			})
+			now := time.Now()
+			rt.now = func() time.Time { return now }
+			var waits []time.Duration
+			rt.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				now = now.Add(d)
+				return nil
+			}
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+			assert.Equal(t, test.expectedWaits, waits)
+			assert.Equal(t, test.expectedRequests, *requests)
+		})
+	}
+}
+
+func TestRetryJitterStrategy(t *testing.T) {
+	// the backoff intervals of the retries, capped by the max interval
+	intervals := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond}
+	tests := []struct {
+		name     string
+		strategy string
+		minWait  func(interval time.Duration) time.Duration
+	}{
+		{
+			name:    "default_full",
+			minWait: func(time.Duration) time.Duration { return 0 },
+		},
+		{
+			name:     "full",
+			strategy: jitterFull,
+			minWait:  func(time.Duration) time.Duration { return 0 },
+		},
+		{
+			name:     "equal",
+			strategy: jitterEqual,
+			minWait:  func(interval time.Duration) time.Duration { return interval / 2 },
+		},
+		{
+			name:     "none",
+			strategy: jitterNone,
+			minWait:  func(interval time.Duration) time.Duration { return interval },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				rt := newRetryRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+				}), RetrySettings{
+					MaxRetries:      len(intervals),
+					InitialInterval: 100 * time.Millisecond,
+					MaxInterval:     500 * time.Millisecond,
+					JitterStrategy:  test.strategy,
+				})
+				var waits []time.Duration
+				rt.sleep = func(_ context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				}
+
+				req, err := http.NewRequest(http.MethodPost, "https://example.com/v1/token", nil)
+				require.NoError(t, err)
+				resp, err := rt.RoundTrip(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				require.Len(t, waits, len(intervals))
+				for j, wait := range waits {
+					assert.GreaterOrEqual(t, wait, test.minWait(intervals[j]))
+					assert.LessOrEqual(t, wait, intervals[j])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryJitterBounds(t *testing.T) {
+	tests := []struct {
+		strategy string
+		random   float64
+		expected time.Duration
+	}{
+		{strategy: jitterFull, random: 0, expected: 0},
+		{strategy: jitterFull, random: 0.5, expected: 50 * time.Millisecond},
+		{strategy: jitterEqual, random: 0, expected: 50 * time.Millisecond},
+		{strategy: jitterEqual, random: 0.5, expected: 75 * time.Millisecond},
+		{strategy: jitterNone, random: 0.5, expected: 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		rt := newRetryRoundTripper(http.DefaultTransport, RetrySettings{JitterStrategy: test.strategy})
+		rt.random = func() float64 { return test.random }
+		assert.Equal(t, test.expected, rt.jitter(100*time.Millisecond), "%s jitter with %v", test.strategy, test.random)
+	}
+}
+
+func TestRetryBudgetSharedByTokenSources(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		mu.Lock()
+		requests[r.PostForm.Get("audience")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory"},
+		},
+		Retry: RetrySettings{
+			MaxRetries:      3,
+			InitialInterval: time.Millisecond,
+			JitterStrategy:  jitterNone,
+			// a single retry is allowed for both token sources, the budget isn't replenished during the test
+			BudgetPerSecond: 0.001,
+			BudgetBurst:     1,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	_, err = oauth2Authenticator.tokenSource("billing").Token()
+	assert.Error(t, err)
+	_, err = oauth2Authenticator.tokenSource("inventory").Token()
+	assert.Error(t, err)
+
+	// the first token source spent the budget, the second one isn't retried
+	assert.Equal(t, map[string]int{"billing": 2, "inventory": 1}, requests)
+}
+
+func TestRetryWithoutBudget(t *testing.T) {
+	server, requests := newFlakyTokenServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Retry: RetrySettings{
+			MaxRetries:      2,
+			InitialInterval: time.Millisecond,
+			JitterStrategy:  jitterNone,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	for i := 0; i < 2; i++ {
+		_, err = fetchToken(oauth2Authenticator)
+		assert.Error(t, err)
+	}
+	// every token request is retried
+	assert.Equal(t, 6, *requests)
+}
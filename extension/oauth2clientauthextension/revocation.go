@@ -0,0 +1,151 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// revocationTimeout bounds the time Shutdown spends revoking tokens.
+const revocationTimeout = 5 * time.Second
+
+// issuedTokens records the tokens obtained by the extension, so that they can be revoked on shutdown.
+type issuedTokens struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func newIssuedTokens() *issuedTokens {
+	return &issuedTokens{tokens: map[string]time.Time{}}
+}
+
+func (i *issuedTokens) add(token *oauth2.Token) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	// expired tokens are dropped along the way, so that tokens don't pile up
+	for accessToken, expiry := range i.tokens {
+		if !expiry.IsZero() && expiry.Before(now) {
+			delete(i.tokens, accessToken)
+		}
+	}
+	i.tokens[token.AccessToken] = token.Expiry
+}
+
+// take returns the tokens that haven't expired yet and forgets them all.
+func (i *issuedTokens) take() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	var tokens []string
+	for accessToken, expiry := range i.tokens {
+		if expiry.IsZero() || expiry.After(now) {
+			tokens = append(tokens, accessToken)
+		}
+	}
+	i.tokens = map[string]time.Time{}
+	return tokens
+}
+
+// trackingTokenSource records the tokens it hands out.
+type trackingTokenSource struct {
+	ts     oauth2.TokenSource
+	issued *issuedTokens
+}
+
+func (s *trackingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.issued.add(token)
+	return token, nil
+}
+
+// revokeTokens revokes the tokens obtained by the extension that haven't expired yet, logging failures.
+func (o *ClientCredentialsAuthenticator) revokeTokens(ctx context.Context) {
+	o.mu.RLock()
+	endpoint := o.revocationURL
+	conf := o.clientCredentials
+	clientIDField, clientSecretField := o.clientIDField, o.clientSecretField
+	client := o.client
+	o.mu.RUnlock()
+	if endpoint == "" {
+		return
+	}
+
+	// the client authenticates as for token requests
+	basicAuth := conf.AuthStyle != oauth2.AuthStyleInParams && clientIDField == "" && clientSecretField == ""
+	if clientIDField == "" {
+		clientIDField = "client_id"
+	}
+	if clientSecretField == "" {
+		clientSecretField = "client_secret"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, revocationTimeout)
+	defer cancel()
+	for _, token := range o.issued.take() {
+		params := url.Values{
+			"token":           {token},
+			"token_type_hint": {"access_token"},
+		}
+		if !basicAuth {
+			params.Set(clientIDField, conf.ClientID)
+			if conf.ClientSecret != "" {
+				params.Set(clientSecretField, conf.ClientSecret)
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+		if err != nil {
+			o.logger.Warn("Failed to revoke security token", zap.Error(err))
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basicAuth {
+			// same encoding as the token requests
+			req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+		}
+		if err = revoke(client, req); err != nil {
+			o.logger.Warn("Failed to revoke security token", zap.Error(err))
+		}
+	}
+}
+
+// revoke sends a token revocation request.
+// See https://datatracker.ietf.org/doc/html/rfc7009#section-2.1
+func revoke(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint returned %s", resp.Status)
+	}
+	return nil
+}
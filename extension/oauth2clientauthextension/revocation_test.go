@@ -0,0 +1,147 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newRevocationServer returns an authorization server issuing the test-token token at /token and accepting
+// revocation requests at /revoke with the given status code, along with the received revocation requests.
+func newRevocationServer(t *testing.T, revocationStatus int) (*httptest.Server, func() []url.Values) {
+	var mu sync.Mutex
+	var revocations []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/revoke" {
+			assert.NoError(t, r.ParseForm())
+			form := r.PostForm
+			if id, secret, ok := r.BasicAuth(); ok {
+				form.Set("basic_auth", id+":"+secret)
+			}
+			mu.Lock()
+			revocations = append(revocations, form)
+			mu.Unlock()
+			w.WriteHeader(revocationStatus)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return revocations
+	}
+}
+
+func TestRevokeOnShutdown(t *testing.T) {
+	server, revocations := newRevocationServer(t, http.StatusOK)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:           "testclientid",
+		ClientSecret:       "testsecret",
+		TokenURL:           server.URL + "/token",
+		RevocationEndpoint: server.URL + "/revoke",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Empty(t, revocations())
+
+	require.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	require.Len(t, revocations(), 1)
+	assert.Equal(t, url.Values{
+		"token":           {"test-token"},
+		"token_type_hint": {"access_token"},
+		"basic_auth":      {"testclientid:testsecret"},
+	}, revocations()[0])
+
+	// revoked tokens aren't revoked again
+	require.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	assert.Len(t, revocations(), 1)
+}
+
+func TestRevokeWithCustomCredentialFields(t *testing.T) {
+	server, revocations := newRevocationServer(t, http.StatusOK)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:           "testclientid",
+		ClientSecret:       "testsecret",
+		ClientIDField:      "app_id",
+		TokenURL:           server.URL + "/token",
+		RevocationEndpoint: server.URL + "/revoke",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	require.Len(t, revocations(), 1)
+	assert.Equal(t, url.Values{
+		"token":           {"test-token"},
+		"token_type_hint": {"access_token"},
+		"app_id":          {"testclientid"},
+		"client_secret":   {"testsecret"},
+	}, revocations()[0])
+}
+
+func TestRevocationFailureDoesNotFailShutdown(t *testing.T) {
+	server, revocations := newRevocationServer(t, http.StatusServiceUnavailable)
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:           "testclientid",
+		ClientSecret:       "testsecret",
+		TokenURL:           server.URL + "/token",
+		RevocationEndpoint: server.URL + "/revoke",
+	}, zap.New(core))
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	assert.Len(t, revocations(), 1)
+	require.Equal(t, 1, logs.Len())
+	assert.Contains(t, logs.All()[0].ContextMap()["error"], "503")
+}
+
+func TestNoRevocationWithoutEndpoint(t *testing.T) {
+	server, revocations := newRevocationServer(t, http.StatusOK)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL + "/token",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	assert.Empty(t, revocations())
+}
@@ -0,0 +1,68 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// samlBearerTokenSource implements the SAML 2.0 bearer assertion grant.
+// See https://datatracker.ietf.org/doc/html/rfc7522#section-2.1
+type samlBearerTokenSource struct {
+	ctx           context.Context
+	conf          *clientcredentials.Config
+	assertionFile string
+}
+
+// readSAMLAssertion returns the content of the assertion file, failing when it is empty.
+func readSAMLAssertion(path string) ([]byte, error) {
+	assertion, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SAML assertion: %w", err)
+	}
+	assertion = bytes.TrimSpace(assertion)
+	if len(assertion) == 0 {
+		return nil, fmt.Errorf("%w: %s", errEmptySAMLAssertion, path)
+	}
+	return assertion, nil
+}
+
+// Token reads the assertion file on every call, so that assertions renewed on disk are picked up, and exchanges
+// its content for a new token.
+func (s *samlBearerTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := readSAMLAssertion(s.assertionFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the grant_type parameter is allowed to be overridden by the endpoint parameters,
+	// everything else (client authentication, response parsing) is handled by clientcredentials.
+	conf := *s.conf
+	conf.EndpointParams = url.Values{}
+	for k, v := range s.conf.EndpointParams {
+		conf.EndpointParams[k] = v
+	}
+	conf.EndpointParams.Set("grant_type", grantTypeSAML2Bearer)
+	conf.EndpointParams.Set("assertion", base64.RawURLEncoding.EncodeToString(assertion))
+	return conf.TokenSource(s.ctx).Token()
+}
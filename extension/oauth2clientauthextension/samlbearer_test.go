@@ -0,0 +1,125 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testSAMLAssertion = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_1">...</saml:Assertion>`
+
+func TestSAMLBearerGrant(t *testing.T) {
+	var assertions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, grantTypeSAML2Bearer, r.PostForm.Get("grant_type"))
+		assert.Equal(t, "resource.read", r.PostForm.Get("scope"))
+		assertion, err := base64.RawURLEncoding.DecodeString(r.PostForm.Get("assertion"))
+		assert.NoError(t, err)
+		assertions = append(assertions, string(assertion))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"saml-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	assertionFile := filepath.Join(t.TempDir(), "assertion.xml")
+	require.NoError(t, ioutil.WriteFile(assertionFile, []byte(testSAMLAssertion+"\n"), 0600))
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:          "testclientid",
+		GrantType:         grantTypeSAML2Bearer,
+		SAMLAssertionFile: assertionFile,
+		TokenURL:          server.URL,
+		Scopes:            []string{"resource.read"},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "saml-token", token.AccessToken)
+
+	// a renewed assertion is picked up by the next token request
+	renewed := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_2">...</saml:Assertion>`
+	require.NoError(t, ioutil.WriteFile(assertionFile, []byte(renewed), 0600))
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{testSAMLAssertion, renewed}, assertions)
+}
+
+func TestSAMLBearerGrantStart(t *testing.T) {
+	dir := t.TempDir()
+	emptyFile := filepath.Join(dir, "empty.xml")
+	require.NoError(t, ioutil.WriteFile(emptyFile, []byte(" \n"), 0600))
+	validFile := filepath.Join(dir, "assertion.xml")
+	require.NoError(t, ioutil.WriteFile(validFile, []byte(testSAMLAssertion), 0600))
+
+	tests := []struct {
+		name          string
+		assertionFile string
+		expectedErr   error
+		shouldError   bool
+	}{
+		{
+			name:          "valid_assertion",
+			assertionFile: validFile,
+		},
+		{
+			name:          "empty_assertion",
+			assertionFile: emptyFile,
+			expectedErr:   errEmptySAMLAssertion,
+			shouldError:   true,
+		},
+		{
+			name:          "missing_assertion",
+			assertionFile: filepath.Join(dir, "missing.xml"),
+			shouldError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:          "testclientid",
+				GrantType:         grantTypeSAML2Bearer,
+				SAMLAssertionFile: test.assertionFile,
+				TokenURL:          "https://example.com/v1/token",
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			err = oauth2Authenticator.Start(context.Background(), nil)
+			if !test.shouldError {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// refreshSchedule returns the time of the next scheduled refresh after the given time, or the zero time when
+// there is none.
+type refreshSchedule interface {
+	next(after time.Time) time.Time
+}
+
+// parseRefreshSchedule parses a refresh_schedule, either a duration or a cron expression.
+func parseRefreshSchedule(schedule string) (refreshSchedule, error) {
+	if interval, err := time.ParseDuration(schedule); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("%w: the interval must be positive", errInvalidRefreshSchedule)
+		}
+		return intervalSchedule(interval), nil
+	}
+	return parseCronSchedule(schedule)
+}
+
+// intervalSchedule refreshes tokens at a fixed interval.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule refreshes tokens at the minutes matching a standard 5 fields cron expression, in UTC.
+// Like cron, days match either the day of the month or the day of the week when both are restricted.
+type cronSchedule struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek uint64
+	anyDayOfMonth, anyDayOfWeek                     bool
+}
+
+// cronFields are the bounds of the fields of cron expressions.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("%w: %q is neither a duration nor a cron expression of 5 fields", errInvalidRefreshSchedule, expression)
+	}
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s field %q: %v", errInvalidRefreshSchedule, cronFields[i].name, field, err)
+		}
+		sets[i] = set
+	}
+	// both 0 and 7 are Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minutes:       sets[0],
+		hours:         sets[1],
+		daysOfMonth:   sets[2],
+		months:        sets[3],
+		daysOfWeek:    sets[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField returns the set of values matching a comma separated list of values, ranges and steps.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// cronSearchDays bounds the search of the next matching day, so that expressions never matching, e.g. on
+// the 30th of February, don't loop forever.
+const cronSearchDays = 5 * 366
+
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for i := 0; i < cronSearchDays; i, day = i+1, day.AddDate(0, 0, 1) {
+		if !s.matchesDay(day) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if s.hours&(1<<uint(hour)) == 0 {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				candidate := day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+				if s.minutes&(1<<uint(minute)) != 0 && !candidate.Before(t) {
+					return candidate
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchesDay(day time.Time) bool {
+	if s.months&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+	dayOfMonth := s.daysOfMonth&(1<<uint(day.Day())) != 0
+	dayOfWeek := s.daysOfWeek&(1<<uint(day.Weekday())) != 0
+	switch {
+	case s.anyDayOfMonth:
+		return dayOfWeek
+	case s.anyDayOfWeek:
+		return dayOfMonth
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// runRefreshSchedule refreshes the tokens at the scheduled times until stop is closed.
+func (o *ClientCredentialsAuthenticator) runRefreshSchedule(schedule refreshSchedule, stop <-chan struct{}) {
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			o.logger.Warn("refresh_schedule doesn't match any time, no more scheduled refresh")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := o.refresh(); err != nil {
+			if o.lifetime.Err() != nil {
+				// the refresh was cancelled by the shutdown
+				return
+			}
+			o.logger.Warn("Scheduled refresh of the security tokens failed, keeping the current ones", zap.Error(err))
+		}
+	}
+}
+
+// refresh obtains new tokens for the extension configuration and every profile, regardless of the expiry of
+// the cached ones, which are replaced once all the new tokens are obtained.
+func (o *ClientCredentialsAuthenticator) refresh() error {
+	// the token sources built by warmUp are seeded with the warm tokens, which would be handed out again
+	o.mu.Lock()
+	o.warmTokens = nil
+	o.mu.Unlock()
+	if err := o.warmUp(); err != nil {
+		return err
+	}
+	// the token sources in use are rebuilt, seeded with the new tokens
+	o.mu.Lock()
+	o.generation++
+	o.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,230 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.uber.org/goleak"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshScheduleNext(t *testing.T) {
+	// a Wednesday
+	after := time.Date(2021, time.December, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		schedule string
+		expected time.Time
+	}{
+		{
+			schedule: "6h",
+			expected: after.Add(6 * time.Hour),
+		},
+		{
+			schedule: "*/15 * * * *",
+			expected: time.Date(2021, time.December, 1, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 3 * * 0",
+			expected: time.Date(2021, time.December, 5, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 3 * * 7",
+			expected: time.Date(2021, time.December, 5, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			// the day of the month or the day of the week
+			schedule: "0 0 15 * 1",
+			expected: time.Date(2021, time.December, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "30 2 29 2 *",
+			expected: time.Date(2024, time.February, 29, 2, 30, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 8-18/4 * 1-6,12 1-5",
+			expected: time.Date(2021, time.December, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			schedule: "0 0 30 2 *",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			schedule, err := parseRefreshSchedule(test.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, schedule.next(after))
+		})
+	}
+}
+
+func TestInvalidRefreshSchedule(t *testing.T) {
+	for _, schedule := range []string{"-1h", "0s", "0 3 * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 0 * *"} {
+		t.Run(schedule, func(t *testing.T) {
+			_, err := parseRefreshSchedule(schedule)
+			assert.ErrorIs(t, err, errInvalidRefreshSchedule)
+		})
+	}
+}
+
+func TestRefreshSchedule(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:        "testclientid",
+		ClientSecret:    "testsecret",
+		TokenURL:        server.URL,
+		RefreshSchedule: "100ms",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	ts := oauth2Authenticator.tokenSource("")
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	start := time.Now()
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&requests) >= 4 }, 5*time.Second, 10*time.Millisecond)
+	// three scheduled refreshes, 100ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+
+	// the token source in use hands out the latest token, although the first one hasn't expired
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.NotEqual(t, "token-1", token.AccessToken)
+
+	require.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+	stopped := atomic.LoadInt32(&requests)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&requests))
+}
+
+func TestShutdownDuringScheduledRefresh(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		// the token is never sent, the request is only cancelled by the shutdown, which is noticed once the body is read
+		_ = r.ParseForm()
+		<-r.Context().Done()
+	}))
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:        "testclientid",
+		ClientSecret:    "testsecret",
+		TokenURL:        server.URL,
+		RefreshSchedule: "10ms",
+		Timeout:         time.Minute,
+		Retry:           RetrySettings{MaxRetries: 3, InitialInterval: time.Minute},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, oauth2Authenticator.Shutdown(ctx))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// token requests fail once the extension is shut down
+	_, err = oauth2Authenticator.tokenSource("").Token()
+	assert.ErrorIs(t, err, context.Canceled)
+	server.Close()
+}
+
+func TestShutdownTimeoutDuringScheduledRefresh(t *testing.T) {
+	server, revocations := newRevocationServer(t, http.StatusOK)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:           "testclientid",
+		ClientSecret:       "testsecret",
+		TokenURL:           server.URL + "/token",
+		RevocationEndpoint: server.URL + "/revoke",
+		AuditLogFile:       auditFile,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	// a scheduled refresh never returning
+	oauth2Authenticator.stopSchedule = make(chan struct{})
+	oauth2Authenticator.scheduleDone = make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = oauth2Authenticator.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the tokens are still revoked and the audit log closed
+	assert.Len(t, revocations(), 1)
+	oauth2Authenticator.audit.write(auditEntry{Outcome: "success"})
+	assert.Nil(t, oauth2Authenticator.audit.file)
+}
+
+func TestRefreshScheduleDuringReload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	newConfig := func() *Config {
+		return &Config{
+			ClientID:        "testclientid",
+			ClientSecret:    "testsecret",
+			TokenURL:        server.URL,
+			RefreshSchedule: "1ms",
+			Profiles: map[string]TokenProfile{
+				"billing": {Scopes: []string{"billing"}},
+			},
+		}
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(newConfig(), zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), componenttest.NewNopHost()))
+
+	// the scheduled refreshes read the profiles replaced by the reloads, which the race detector checks
+	for i := 0; i < 20; i++ {
+		require.NoError(t, oauth2Authenticator.Reload(newConfig()))
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, oauth2Authenticator.Shutdown(context.Background()))
+}
@@ -0,0 +1,81 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+var errSessionPriming = errors.New("session priming request failed")
+
+// sessionPrimingRoundTripper gets the session priming URL before every token request, for authorization servers
+// only issuing tokens to clients holding a session cookie obtained beforehand. The cookies set by the responses
+// are kept in a jar of its own, so that they are only ever sent with the token requests of the extension.
+type sessionPrimingRoundTripper struct {
+	base http.RoundTripper
+	url  string
+	jar  http.CookieJar
+}
+
+func newSessionPrimingRoundTripper(base http.RoundTripper, primingURL string) *sessionPrimingRoundTripper {
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+	return &sessionPrimingRoundTripper{base: base, url: primingURL, jar: jar}
+}
+
+func (s *sessionPrimingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := s.prime(req); err != nil {
+		return nil, err
+	}
+
+	req2 := req.Clone(req.Context())
+	for _, cookie := range s.jar.Cookies(req.URL) {
+		req2.AddCookie(cookie)
+	}
+	resp, err := s.base.RoundTrip(req2)
+	if err != nil {
+		return nil, err
+	}
+	s.jar.SetCookies(req.URL, resp.Cookies())
+	return resp, nil
+}
+
+// prime gets the session priming URL, storing the cookies it sets. Redirects aren't followed, as the session cookie
+// is usually set by the redirecting response.
+func (s *sessionPrimingRoundTripper) prime(req *http.Request) error {
+	primingReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	for _, cookie := range s.jar.Cookies(primingReq.URL) {
+		primingReq.AddCookie(cookie)
+	}
+	resp, err := s.base.RoundTrip(primingReq)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: %s", errSessionPriming, resp.Status)
+	}
+	s.jar.SetCookies(primingReq.URL, resp.Cookies())
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestSessionPriming(t *testing.T) {
+	primings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			primings++
+			assert.Equal(t, http.MethodGet, r.Method)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "primed", Path: "/"})
+			w.WriteHeader(http.StatusNoContent)
+		case "/token":
+			// the token endpoint only issues tokens to clients holding the session cookie
+			if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "primed" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+		default:
+			// the requests of the exporter don't carry the cookies of the token requests
+			assert.Empty(t, r.Header.Get("Cookie"))
+			assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	newAuthenticator := func(primingURL string) *ClientCredentialsAuthenticator {
+		oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+			ClientID:          "testclientid",
+			ClientSecret:      "testsecret",
+			TokenURL:          server.URL + "/token",
+			SessionPrimingURL: primingURL,
+		}, zap.NewNop())
+		require.NoError(t, err)
+		oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+		return oauth2Authenticator
+	}
+
+	_, err := fetchToken(newAuthenticator(""))
+	assert.Error(t, err)
+
+	oauth2Authenticator := newAuthenticator(server.URL + "/login")
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, 1, primings)
+
+	roundTripper, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: roundTripper}).Get(server.URL + "/data")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestSessionPrimingFailure(t *testing.T) {
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		tokenRequests++
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          server.URL + "/token",
+		SessionPrimingURL: server.URL + "/login",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	assert.ErrorIs(t, err, errSessionPriming)
+	assert.Zero(t, tokenRequests)
+}
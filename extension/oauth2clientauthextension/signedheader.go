@@ -0,0 +1,251 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultSignedHeader = "X-Request-Signature"
+
+	// signedHeaderLifetime is the validity of the signed headers, which are signed for a single request.
+	signedHeaderLifetime = time.Minute
+)
+
+var (
+	errNoSigningKey          = errors.New("no PEM private key found in signed_header.key_file")
+	errUnsupportedSigningKey = errors.New("unsupported signed_header.key_file key, must be an RSA or a P-256 ECDSA key")
+)
+
+// signedHeaderRequest holds the attributes of a request, which the claims templates of signed_header refer to.
+type signedHeaderRequest struct {
+	Method string
+	Host   string
+	Path   string
+	Query  string
+	// BodySHA256 is the base64url encoded SHA-256 digest of the body.
+	BodySHA256 string
+}
+
+// parseClaimsTemplates parses the claims templates of signed_header. The iat, exp and jti claims are set for each
+// request, they can't be templated.
+func parseClaimsTemplates(claims map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(claims))
+	for name, text := range claims {
+		switch name {
+		case "iat", "exp", "jti":
+			return nil, fmt.Errorf("%w: %q", errReservedSignedClaim, name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidClaimsTemplate, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// loadSigningKey loads the PEM private key of signed_header, returning it along with its JWS algorithm.
+func loadSigningKey(keyFile string) (crypto.Signer, string, error) {
+	keyPEM, err := ioutil.ReadFile(filepath.Clean(keyFile))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load signed_header.key_file: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, "", errNoSigningKey
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse signed_header.key_file: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "RS256", nil
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P256() {
+			return k, "ES256", nil
+		}
+	}
+	return nil, "", errUnsupportedSigningKey
+}
+
+// requestSigner signs JWTs carrying claims computed from the attributes of the requests.
+type requestSigner struct {
+	key    crypto.Signer
+	alg    string
+	header string
+	claims map[string]*template.Template
+	now    func() time.Time
+}
+
+func newRequestSigner(settings SignedHeaderSettings) (*requestSigner, error) {
+	claims, err := parseClaimsTemplates(settings.Claims)
+	if err != nil {
+		return nil, err
+	}
+	key, alg, err := loadSigningKey(settings.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	header := settings.Header
+	if header == "" {
+		header = defaultSignedHeader
+	}
+	return &requestSigner{
+		key:    key,
+		alg:    alg,
+		header: header,
+		claims: claims,
+		now:    time.Now,
+	}, nil
+}
+
+// sign returns the compact serialization of the JWT for req, sent with body, whose query is signed as query.
+func (s *requestSigner) sign(req *http.Request, query string, body []byte) (string, error) {
+	digest := sha256.Sum256(body)
+	attributes := signedHeaderRequest{
+		Method:     req.Method,
+		Host:       req.URL.Host,
+		Path:       req.URL.Path,
+		Query:      query,
+		BodySHA256: base64.RawURLEncoding.EncodeToString(digest[:]),
+	}
+	if req.Host != "" {
+		attributes.Host = req.Host
+	}
+
+	claims := make(map[string]interface{}, len(s.claims)+3)
+	for name, tmpl := range s.claims {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, attributes); err != nil {
+			return "", fmt.Errorf("failed to compute the %q claim of the signed header: %w", name, err)
+		}
+		claims[name] = value.String()
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := s.now()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(signedHeaderLifetime).Unix()
+	claims["jti"] = hex.EncodeToString(jti)
+
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := s.signature(signingInput)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the signed header: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// signature signs the signing input of a JWT, as per RFC 7518.
+// See https://datatracker.ietf.org/doc/html/rfc7518#section-3
+func (s *requestSigner) signature(signingInput string) ([]byte, error) {
+	digest := sha256.Sum256([]byte(signingInput))
+	key, ok := s.key.(*ecdsa.PrivateKey)
+	if !ok {
+		return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	// ES256 signatures are the concatenation of r and s rather than their ASN.1 encoding
+	r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	sig.FillBytes(signature[32:])
+	return signature, nil
+}
+
+// signedHeaderRoundTripper adds a JWT signed for each request, binding its attributes, to the requests of the
+// exporters, alongside their access token. It sends the body it signed. With tokenInQuery, the access_token
+// query parameter added by queryTokenRoundTripper is left out of the signed query, so that the token doesn't end up
+// in the JWT.
+type signedHeaderRoundTripper struct {
+	base         http.RoundTripper
+	signer       *requestSigner
+	tokenInQuery bool
+}
+
+func (s *signedHeaderRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	query := req.URL.RawQuery
+	if s.tokenInQuery {
+		values := req.URL.Query()
+		values.Del("access_token")
+		query = values.Encode()
+	}
+	signed, err := s.signer.sign(req, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req2 := req.Clone(req.Context())
+	req2.Header.Set(s.signer.header, signed)
+	if body != nil {
+		req2.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req2.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return s.base.RoundTrip(req2)
+}
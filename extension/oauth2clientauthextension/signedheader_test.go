@@ -0,0 +1,219 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// writeSigningKey writes key as a PEM file, PKCS#8 encoded.
+func writeSigningKey(t *testing.T, key crypto.Signer) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return keyFile
+}
+
+// verifyJWT checks the signature of the compact JWT signed by key, returning its claims.
+func verifyJWT(t *testing.T, jwt string, key crypto.Signer) map[string]interface{} {
+	parts := strings.Split(jwt, ".")
+	require.Len(t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		require.NoError(t, rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature))
+	case *ecdsa.PublicKey:
+		require.Len(t, signature, 64)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		require.True(t, ecdsa.Verify(k, digest[:], r, s))
+	}
+
+	var claims map[string]interface{}
+	require.NoError(t, jwtClaims(jwt, &claims))
+	return claims
+}
+
+func TestSignedHeader(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		key            crypto.Signer
+		header         string
+		expectedHeader string
+		expectedAlg    string
+	}{
+		{
+			name:           "rsa",
+			key:            rsaKey,
+			expectedHeader: "X-Request-Signature",
+			expectedAlg:    "RS256",
+		},
+		{
+			name:           "ecdsa_custom_header",
+			key:            ecKey,
+			header:         "X-Integrity",
+			expectedHeader: "X-Integrity",
+			expectedAlg:    "ES256",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer tokenServer.Close()
+
+			body := []byte(`{"resourceMetrics":[]}`)
+			digest := sha256.Sum256(body)
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, body, received)
+				assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+
+				signed := r.Header.Get(test.expectedHeader)
+				header, err := base64.RawURLEncoding.DecodeString(strings.Split(signed, ".")[0])
+				assert.NoError(t, err)
+				assert.JSONEq(t, `{"alg":"`+test.expectedAlg+`","typ":"JWT"}`, string(header))
+
+				claims := verifyJWT(t, signed, test.key)
+				assert.Equal(t, "POST", claims["htm"])
+				assert.Equal(t, "/v1/metrics?tenant=a", claims["htu"])
+				assert.Equal(t, base64.RawURLEncoding.EncodeToString(digest[:]), claims["body"])
+				assert.Equal(t, 60.0, claims["exp"].(float64)-claims["iat"].(float64))
+				assert.NotEmpty(t, claims["jti"])
+			}))
+			defer backend.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     tokenServer.URL,
+				SignedHeader: SignedHeaderSettings{
+					KeyFile: writeSigningKey(t, test.key),
+					Header:  test.header,
+					Claims: map[string]string{
+						"htm":  "{{.Method}}",
+						"htu":  "{{.Path}}?{{.Query}}",
+						"body": "{{.BodySHA256}}",
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			rt, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodPost, backend.URL+"/v1/metrics?tenant=a", bytes.NewReader(body))
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestSignedHeaderWithTokenInQuery(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-token", r.URL.Query().Get("access_token"))
+		signed := r.Header.Get(defaultSignedHeader)
+		// the token is in none of the parts of the JWT
+		for _, part := range strings.Split(signed, ".") {
+			decoded, err := base64.RawURLEncoding.DecodeString(part)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(decoded), "test-token")
+		}
+		claims := verifyJWT(t, signed, key)
+		assert.Equal(t, "tenant=a", claims["query"])
+	}))
+	defer backend.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:      "testclientid",
+		ClientSecret:  "testsecret",
+		TokenURL:      tokenServer.URL,
+		TokenLocation: tokenLocationQuery,
+		SignedHeader: SignedHeaderSettings{
+			KeyFile: writeSigningKey(t, key),
+			Header:  defaultSignedHeader,
+			Claims:  map[string]string{"query": "{{.Query}}"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	rt, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, backend.URL+"/v1/metrics?tenant=a", nil)
+	require.NoError(t, err)
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSignedHeaderUnsupportedKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	_, err = newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://example.com/token",
+		SignedHeader: SignedHeaderSettings{KeyFile: writeSigningKey(t, key)},
+	}, zap.NewNop())
+	assert.True(t, errors.Is(err, errUnsupportedSigningKey))
+}
@@ -0,0 +1,93 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	hmacSHA256 = "sha256"
+	hmacSHA384 = "sha384"
+	hmacSHA512 = "sha512"
+
+	defaultHMACHeader = "X-Signature"
+)
+
+func hmacHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "", hmacSHA256:
+		return sha256.New
+	case hmacSHA384:
+		return sha512.New384
+	case hmacSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// hmacSigningRoundTripper adds the HMAC signature of their body to the token requests. It sends the body
+// it signed, so that it is kept below the round trippers changing the body, like compressionRoundTripper.
+type hmacSigningRoundTripper struct {
+	base   http.RoundTripper
+	key    []byte
+	header string
+	hash   func() hash.Hash
+}
+
+func newHMACSigningRoundTripper(base http.RoundTripper, settings RequestSigningSettings) *hmacSigningRoundTripper {
+	header := settings.HMACHeader
+	if header == "" {
+		header = defaultHMACHeader
+	}
+	return &hmacSigningRoundTripper{
+		base:   base,
+		key:    []byte(settings.HMACKey),
+		header: header,
+		hash:   hmacHash(settings.HMACAlgorithm),
+	}
+}
+
+func (s *hmacSigningRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	mac := hmac.New(s.hash, s.key)
+	_, _ = mac.Write(body)
+
+	req2 := req.Clone(req.Context())
+	req2.Header.Set(s.header, hex.EncodeToString(mac.Sum(nil)))
+	if body != nil {
+		req2.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req2.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return s.base.RoundTrip(req2)
+}
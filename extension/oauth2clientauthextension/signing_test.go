@@ -0,0 +1,102 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestRequestSigning(t *testing.T) {
+	tests := []struct {
+		name           string
+		signing        RequestSigningSettings
+		compression    string
+		expectedHeader string
+		expectedHash   func() hash.Hash
+	}{
+		{
+			name:           "defaults",
+			signing:        RequestSigningSettings{HMACKey: "shared-key"},
+			expectedHeader: "X-Signature",
+			expectedHash:   sha256.New,
+		},
+		{
+			name: "custom_header_and_algorithm",
+			signing: RequestSigningSettings{
+				HMACKey:       "shared-key",
+				HMACHeader:    "X-Gateway-Signature",
+				HMACAlgorithm: "sha512",
+			},
+			expectedHeader: "X-Gateway-Signature",
+			expectedHash:   sha512.New,
+		},
+		{
+			// the signature covers the body as sent
+			name:           "compressed_body",
+			signing:        RequestSigningSettings{HMACKey: "shared-key"},
+			compression:    compressionGzip,
+			expectedHeader: "X-Signature",
+			expectedHash:   sha256.New,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.NotEmpty(t, body)
+				assert.Equal(t, test.compression, r.Header.Get("Content-Encoding"))
+
+				mac := hmac.New(test.expectedHash, []byte("shared-key"))
+				_, _ = mac.Write(body)
+				if r.Header.Get(test.expectedHeader) != hex.EncodeToString(mac.Sum(nil)) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                "testclientid",
+				ClientSecret:            "testsecret",
+				TokenURL:                server.URL,
+				TokenRequestCompression: test.compression,
+				RequestSigning:          test.signing,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
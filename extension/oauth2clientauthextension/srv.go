@@ -0,0 +1,182 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// srvRefreshInterval is how long the targets of the SRV record are used before the record is resolved again.
+const srvRefreshInterval = 5 * time.Minute
+
+var errNoSRVRecords = errors.New("no SRV record found for token_srv")
+
+// srvResolver resolves SRV records, like net.Resolver.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// withSRVResolver makes the extension resolve the SRV record of token_srv with resolver instead of net.DefaultResolver.
+func withSRVResolver(resolver srvResolver) Option {
+	return func(o *ClientCredentialsAuthenticator) {
+		o.srvResolver = resolver
+	}
+}
+
+// srvRoundTripper sends the token requests to the targets of an SRV record, in place of the host and port of
+// the token URL. The targets are tried in priority and weight order, moving on to the next one when the connection
+// to a target can't be established. The records of the same priority are ordered again for every request, so that
+// their weights spread the requests. The target host name is used for TLS, like for any URL. The record is resolved
+// again every srvRefreshInterval, and the last records are kept when resolving fails.
+type srvRoundTripper struct {
+	base     http.RoundTripper
+	name     string
+	resolver srvResolver
+	now      func() time.Time
+	intn     func(n int) int
+
+	mu         sync.Mutex
+	records    []*net.SRV
+	resolvedAt time.Time
+}
+
+func newSRVRoundTripper(base http.RoundTripper, name string, resolver srvResolver) *srvRoundTripper {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &srvRoundTripper{
+		base:     base,
+		name:     name,
+		resolver: resolver,
+		now:      time.Now,
+		intn:     rand.Intn, // #nosec G404 -- only spreads the requests across the targets
+	}
+}
+
+func (s *srvRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	records, err := s.resolve(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(records))
+	for _, record := range orderSRV(records, s.intn) {
+		targets = append(targets, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+	}
+	// the errors of every target tried, so that the failure reports why each of them failed
+	var errs error
+	for i, target := range targets {
+		req2 := req.Clone(req.Context())
+		req2.URL.Host = target
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req2.Body, err = req.GetBody(); err != nil {
+				return nil, multierr.Append(errs, err)
+			}
+		}
+		resp, err := s.base.RoundTrip(req2)
+		if err == nil {
+			return resp, nil
+		}
+		errs = multierr.Append(errs, fmt.Errorf("%s: %w", target, err))
+		if !dialFailed(err) || req.Context().Err() != nil {
+			break
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			// the body can't be sent again
+			break
+		}
+	}
+	return nil, errs
+}
+
+// dialFailed reports whether err is the failure to establish a connection, so that the request wasn't sent.
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// resolve returns the records of the SRV record.
+func (s *srvRoundTripper) resolve(ctx context.Context) ([]*net.SRV, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) > 0 && s.now().Sub(s.resolvedAt) < srvRefreshInterval {
+		return s.records, nil
+	}
+
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err == nil && len(records) == 0 {
+		err = fmt.Errorf("%w: %s", errNoSRVRecords, s.name)
+	}
+	if err != nil {
+		if len(s.records) > 0 {
+			// the previous records are likely still valid, they are used until the record resolves again
+			return s.records, nil
+		}
+		return nil, fmt.Errorf("failed to resolve token_srv: %w", err)
+	}
+	s.records = records
+	s.resolvedAt = s.now()
+	return s.records, nil
+}
+
+// orderSRV returns a copy of records sorted by priority, the records of the same priority being ordered at random,
+// in proportion to their weight.
+// See https://datatracker.ietf.org/doc/html/rfc2782
+func orderSRV(records []*net.SRV, intn func(n int) int) []*net.SRV {
+	ordered := append([]*net.SRV(nil), records...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+	for start := 0; start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && ordered[end].Priority == ordered[start].Priority {
+			end++
+		}
+		shuffleByWeight(ordered[start:end], intn)
+		start = end
+	}
+	return ordered
+}
+
+// shuffleByWeight orders records of the same priority, picking each one with a probability proportional to its
+// weight among the remaining ones. Records of weight 0 come last.
+func shuffleByWeight(records []*net.SRV, intn func(n int) int) {
+	sum := 0
+	for _, record := range records {
+		sum += int(record.Weight)
+	}
+	for sum > 0 && len(records) > 1 {
+		n := intn(sum)
+		picked := 0
+		for i := range records {
+			picked += int(records[i].Weight)
+			if picked > n {
+				records[0], records[i] = records[i], records[0]
+				break
+			}
+		}
+		sum -= int(records[0].Weight)
+		records = records[1:]
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// stubSRVResolver answers SRV lookups with the given records, or fails with err.
+type stubSRVResolver struct {
+	records []*net.SRV
+	err     error
+	lookups []string
+}
+
+func (r *stubSRVResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.lookups = append(r.lookups, name)
+	return name, r.records, r.err
+}
+
+func TestTokenSRV(t *testing.T) {
+	caPEM, cert := newTestCA(t, "test CA")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, caPEM, 0600))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/token", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	// nothing listens on the port of the closed listener
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, closedPort, err := net.SplitHostPort(closed.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+	closedPortNumber, err := strconv.Atoi(closedPort)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		records []*net.SRV
+	}{
+		{
+			name: "highest_priority_target",
+			records: []*net.SRV{
+				// the certificate of the server is valid for 127.0.0.1
+				{Target: "127.0.0.1.", Port: uint16(portNumber), Priority: 10},
+				{Target: "backup.idp.invalid.", Port: 443, Priority: 20},
+			},
+		},
+		{
+			name: "next_target_on_dial_failure",
+			records: []*net.SRV{
+				{Target: "127.0.0.1.", Port: uint16(portNumber), Priority: 20},
+				{Target: "127.0.0.1.", Port: uint16(closedPortNumber), Priority: 10},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &stubSRVResolver{records: test.records}
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     "https://idp.invalid/v1/token",
+				TokenSRV:     "_oauth._tcp.idp.example.com",
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: caFile},
+					},
+				},
+			}, zap.NewNop(), withSRVResolver(resolver))
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+			assert.Equal(t, []string{"_oauth._tcp.idp.example.com"}, resolver.lookups)
+		})
+	}
+}
+
+func TestTokenSRVAllTargetsFail(t *testing.T) {
+	var targets []string
+	rt := newSRVRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		targets = append(targets, req.URL.Host)
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}), "_oauth._tcp.idp.example.com", &stubSRVResolver{records: []*net.SRV{
+		{Target: "idp-2.example.com.", Port: 8443, Priority: 20},
+		{Target: "idp-1.example.com.", Port: 8443, Priority: 10},
+	}})
+
+	req, err := http.NewRequest(http.MethodPost, "https://idp.example.com/v1/token", strings.NewReader("grant_type=client_credentials"))
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.Error(t, err)
+	assert.Equal(t, []string{"idp-1.example.com:8443", "idp-2.example.com:8443"}, targets)
+	// the error of every target is reported
+	errs := multierr.Errors(err)
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "idp-1.example.com:8443")
+	assert.Contains(t, errs[1].Error(), "idp-2.example.com:8443")
+}
+
+func TestTokenSRVErrorOfEveryTarget(t *testing.T) {
+	closedPort := func() uint16 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		port := listener.Addr().(*net.TCPAddr).Port
+		require.NoError(t, listener.Close())
+		return uint16(port)
+	}
+	primary, backup := closedPort(), closedPort()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     "https://idp.invalid/v1/token",
+		TokenSRV:     "_oauth._tcp.idp.example.com",
+	}, zap.NewNop(), withSRVResolver(&stubSRVResolver{records: []*net.SRV{
+		{Target: "127.0.0.1.", Port: primary, Priority: 10},
+		{Target: "127.0.0.1.", Port: backup, Priority: 20},
+	}}))
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	var tokenErr *FailedToGetSecurityTokenError
+	require.ErrorAs(t, err, &tokenErr)
+	assert.Contains(t, err.Error(), net.JoinHostPort("127.0.0.1", strconv.Itoa(int(primary)))+": dial tcp")
+	assert.Contains(t, err.Error(), net.JoinHostPort("127.0.0.1", strconv.Itoa(int(backup)))+": dial tcp")
+	assert.True(t, tokenErr.Temporary())
+}
+
+func TestTokenSRVWeightsSpreadRequests(t *testing.T) {
+	var targets []string
+	resolver := &stubSRVResolver{records: []*net.SRV{
+		{Target: "idp-1.example.com.", Port: 8443, Priority: 10, Weight: 50},
+		{Target: "idp-2.example.com.", Port: 8443, Priority: 10, Weight: 50},
+	}}
+	rt := newSRVRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		targets = append(targets, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), "_oauth._tcp.idp.example.com", resolver)
+	picks := []int{0, 99, 0}
+	rt.intn = func(n int) int {
+		pick := picks[0]
+		picks = picks[1:]
+		return pick
+	}
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodPost, "https://idp.example.com/v1/token", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	// the cached records are ordered by weight for every request
+	assert.Len(t, resolver.lookups, 1)
+	assert.Equal(t, []string{"idp-1.example.com:8443", "idp-2.example.com:8443", "idp-1.example.com:8443"}, targets)
+}
+
+func TestOrderSRV(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "c", Priority: 20, Weight: 10},
+		{Target: "a", Priority: 10, Weight: 0},
+		{Target: "b", Priority: 10, Weight: 30},
+		{Target: "d", Priority: 10, Weight: 70},
+	}
+
+	tests := []struct {
+		name     string
+		pick     int
+		expected []string
+	}{
+		{
+			name:     "lightest_picked",
+			pick:     0,
+			expected: []string{"b", "d", "a", "c"},
+		},
+		{
+			name:     "heaviest_picked",
+			pick:     50,
+			expected: []string{"d", "b", "a", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			picks := 0
+			ordered := orderSRV(records, func(n int) int {
+				picks++
+				if picks == 1 {
+					return test.pick
+				}
+				return 0
+			})
+			var targets []string
+			for _, record := range ordered {
+				targets = append(targets, record.Target)
+			}
+			assert.Equal(t, test.expected, targets)
+		})
+	}
+	// the records are left as is
+	assert.Equal(t, "c", records[0].Target)
+}
+
+func TestTokenSRVRefresh(t *testing.T) {
+	var targets []string
+	resolver := &stubSRVResolver{}
+	rt := newSRVRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		targets = append(targets, req.URL.Host)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), "_oauth._tcp.idp.example.com", resolver)
+	now := time.Now()
+	rt.now = func() time.Time { return now }
+
+	send := func() error {
+		req, err := http.NewRequest(http.MethodPost, "https://idp.example.com/v1/token", nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	// fails until the record resolves
+	assert.ErrorIs(t, send(), errNoSRVRecords)
+	resolver.err = errors.New("no such host")
+	assert.Error(t, send())
+
+	resolver.err = nil
+	resolver.records = []*net.SRV{{Target: "idp-1.example.com.", Port: 8443}}
+	require.NoError(t, send())
+	// the target is cached
+	resolver.records = []*net.SRV{{Target: "idp-2.example.com.", Port: 8443}}
+	require.NoError(t, send())
+	assert.Len(t, resolver.lookups, 3)
+
+	// resolved again after the refresh interval
+	now = now.Add(srvRefreshInterval)
+	require.NoError(t, send())
+	// the last target is kept when resolving fails
+	now = now.Add(srvRefreshInterval)
+	resolver.err = errors.New("no such host")
+	require.NoError(t, send())
+
+	assert.Equal(t, []string{"idp-1.example.com:8443", "idp-1.example.com:8443", "idp-2.example.com:8443", "idp-2.example.com:8443"}, targets)
+}
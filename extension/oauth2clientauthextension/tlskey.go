@@ -0,0 +1,58 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/youmark/pkcs8"
+)
+
+// loadEncryptedKeyPair loads a certificate along with its private key, stored as an encrypted PKCS#8 PEM file.
+func loadEncryptedKeyPair(certFile, keyFile, passphrase string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(filepath.Clean(certFile))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load TLS cert: %w", err)
+	}
+	encryptedPEM, err := ioutil.ReadFile(filepath.Clean(keyFile))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load TLS key: %w", err)
+	}
+
+	block, _ := pem.Decode(encryptedPEM)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return tls.Certificate{}, fmt.Errorf("%w: %s", errKeyNotEncrypted, keyFile)
+	}
+	key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decrypt TLS key: %w", err)
+	}
+
+	// the decrypted key is handed to tls.X509KeyPair, which checks that it matches the certificate
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decrypt TLS key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load TLS cert and key: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,99 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+)
+
+func TestEncryptedTLSKey(t *testing.T) {
+	// test files for TLS testing, the encrypted key being test-key.pem encrypted with "testpassphrase"
+	var (
+		testCAFile           = "testdata/testCA.pem"
+		testCertFile         = "testdata/test-cert.pem"
+		testKeyFile          = "testdata/test-key.pem"
+		testEncryptedKeyFile = "testdata/test-key-encrypted.pem"
+	)
+
+	expected, err := tls.LoadX509KeyPair(testCertFile, testKeyFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		keyFile       string
+		passphrase    string
+		expectedErr   error
+		expectedError string
+	}{
+		{
+			name:       "valid_passphrase",
+			keyFile:    testEncryptedKeyFile,
+			passphrase: "testpassphrase",
+		},
+		{
+			name:          "invalid_passphrase",
+			keyFile:       testEncryptedKeyFile,
+			passphrase:    "wrongpassphrase",
+			expectedError: "failed to decrypt TLS key",
+		},
+		{
+			name:        "unencrypted_key",
+			keyFile:     testKeyFile,
+			passphrase:  "testpassphrase",
+			expectedErr: errKeyNotEncrypted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     "https://example.com/v1/token",
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{
+							CAFile:   testCAFile,
+							CertFile: testCertFile,
+							KeyFile:  test.keyFile,
+						},
+					},
+					KeyPassphrase: test.passphrase,
+				},
+			}, zap.NewNop())
+			if test.expectedErr != nil || test.expectedError != "" {
+				require.Error(t, err)
+				if test.expectedErr != nil {
+					assert.ErrorIs(t, err, test.expectedErr)
+				}
+				assert.Contains(t, err.Error(), test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			transport := oauth2Authenticator.client.Transport.(*acceptJSONRoundTripper).base.(*errorResponseRoundTripper).base.(*http.Transport)
+			require.Len(t, transport.TLSClientConfig.Certificates, 1)
+			assert.Equal(t, expected.Certificate, transport.TLSClientConfig.Certificates[0].Certificate)
+			assert.Equal(t, expected.PrivateKey, transport.TLSClientConfig.Certificates[0].PrivateKey)
+		})
+	}
+}
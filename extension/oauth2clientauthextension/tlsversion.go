@@ -0,0 +1,86 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the messages of the crypto/tls errors reporting that client and server have no TLS version in common,
+// the first one being the alert sent by servers, the second one reporting the version selected by the server
+const (
+	protocolVersionAlert       = "tls: protocol version not supported"
+	unsupportedSelectedVersion = "tls: server selected unsupported protocol version "
+)
+
+// the TLS versions used by the crypto/tls package when not configured
+const (
+	defaultMinTLSVersion = tls.VersionTLS12
+	defaultMaxTLSVersion = tls.VersionTLS13
+)
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// tlsVersions describes the TLS versions offered to the token endpoint, and the settings they come from.
+func tlsVersions(tlsCfg *tls.Config) string {
+	minVersion, minSetting := uint16(defaultMinTLSVersion), "default"
+	maxVersion, maxSetting := uint16(defaultMaxTLSVersion), "default"
+	if tlsCfg != nil && tlsCfg.MinVersion != 0 {
+		minVersion, minSetting = tlsCfg.MinVersion, "tls.min_version"
+	}
+	if tlsCfg != nil && tlsCfg.MaxVersion != 0 {
+		maxVersion, maxSetting = tlsCfg.MaxVersion, "tls.max_version"
+	}
+	return fmt.Sprintf("%s (%s) to %s (%s)", tlsVersionName(minVersion), minSetting, tlsVersionName(maxVersion), maxSetting)
+}
+
+// tlsVersionError describes the handshake failures caused by the token endpoint supporting none of the TLS
+// versions offered by the extension, which crypto/tls reports without the versions involved. Other errors are
+// returned as is.
+func tlsVersionError(err error, tlsCfg *tls.Config) error {
+	msg := err.Error()
+	if strings.Contains(msg, protocolVersionAlert) {
+		return fmt.Errorf("TLS handshake with the token endpoint failed, it supports none of the TLS versions offered, %s: %w",
+			tlsVersions(tlsCfg), err)
+	}
+	if i := strings.Index(msg, unsupportedSelectedVersion); i >= 0 {
+		selected := strings.Fields(msg[i+len(unsupportedSelectedVersion):])
+		if len(selected) == 0 {
+			return err
+		}
+		version, parseErr := strconv.ParseUint(strings.TrimRight(selected[0], ":"), 16, 16)
+		if parseErr != nil {
+			return err
+		}
+		return fmt.Errorf("TLS handshake with the token endpoint failed, it negotiated %s while %s is required: %w",
+			tlsVersionName(uint16(version)), tlsVersions(tlsCfg), err)
+	}
+	return err
+}
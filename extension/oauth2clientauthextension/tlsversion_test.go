@@ -0,0 +1,101 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config/configtls"
+	"go.uber.org/zap"
+)
+
+func TestTLSVersionMismatch(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	tests := []struct {
+		name          string
+		minVersion    string
+		expectedError string
+	}{
+		{
+			name:       "supported_version",
+			minVersion: "1.2",
+		},
+		{
+			name:          "unsupported_min_version",
+			minVersion:    "1.3",
+			expectedError: "TLS handshake with the token endpoint failed, it supports none of the TLS versions offered, TLS 1.3 (tls.min_version) to TLS 1.3 (default)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				TLSSetting: TLSClientSetting{
+					TLSClientSetting: configtls.TLSClientSetting{
+						TLSSetting: configtls.TLSSetting{CAFile: caFile, MinVersion: test.minVersion},
+					},
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			if test.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedError)
+				assert.Contains(t, err.Error(), protocolVersionAlert)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestTLSVersionError(t *testing.T) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS13}
+
+	selected := errors.New("tls: server selected unsupported protocol version 301")
+	err := tlsVersionError(selected, tlsCfg)
+	assert.ErrorIs(t, err, selected)
+	assert.Equal(t, "TLS handshake with the token endpoint failed, it negotiated TLS 1.0 while TLS 1.2 (tls.min_version) to TLS 1.3 (tls.max_version) is required: "+selected.Error(), err.Error())
+
+	// other errors are left as is
+	other := errors.New("tls: handshake failure")
+	assert.Equal(t, other, tlsVersionError(other, tlsCfg))
+	malformed := errors.New("tls: server selected unsupported protocol version x")
+	assert.Equal(t, malformed, tlsVersionError(malformed, nil))
+}
@@ -0,0 +1,75 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// defaultSubjectTokenFile is where Kubernetes mounts the projected service account token of the pod.
+	defaultSubjectTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	tokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// readSubjectToken returns the content of the subject token file, failing when it is empty.
+func readSubjectToken(path string) (string, error) {
+	subjectToken, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read subject token: %w", err)
+	}
+	subjectToken = bytes.TrimSpace(subjectToken)
+	if len(subjectToken) == 0 {
+		return "", fmt.Errorf("%w: %s", errEmptySubjectToken, path)
+	}
+	return string(subjectToken), nil
+}
+
+// tokenExchangeTokenSource implements the token exchange grant, exchanging a JWT, like the projected service account
+// token of a Kubernetes pod, for tokens.
+// See https://datatracker.ietf.org/doc/html/rfc8693#section-2.1
+type tokenExchangeTokenSource struct {
+	ctx              context.Context
+	conf             *clientcredentials.Config
+	subjectTokenFile string
+}
+
+// Token reads the subject token file on every call, so that the tokens rotated by the kubelet are picked up, and
+// exchanges its content for a new token.
+func (s *tokenExchangeTokenSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := readSubjectToken(s.subjectTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// like for the SAML 2.0 bearer grant, the grant_type parameter is overridden by the endpoint parameters
+	conf := *s.conf
+	conf.EndpointParams = url.Values{}
+	for k, v := range s.conf.EndpointParams {
+		conf.EndpointParams[k] = v
+	}
+	conf.EndpointParams.Set("grant_type", grantTypeTokenExchange)
+	conf.EndpointParams.Set("subject_token", subjectToken)
+	conf.EndpointParams.Set("subject_token_type", tokenTypeJWT)
+	return conf.TokenSource(s.ctx).Token()
+}
@@ -0,0 +1,111 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenExchangeGrant(t *testing.T) {
+	var subjectTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, grantTypeTokenExchange, r.PostForm.Get("grant_type"))
+		assert.Equal(t, tokenTypeJWT, r.PostForm.Get("subject_token_type"))
+		subjectTokens = append(subjectTokens, r.PostForm.Get("subject_token"))
+
+		w.Header().Set("Content-Type", "application/json")
+		// expiring within the expiry delta of the oauth2 package, the token is exchanged again on every call
+		_, _ = w.Write([]byte(`{"access_token":"exchanged-token","token_type":"bearer","expires_in":1}`))
+	}))
+	defer server.Close()
+
+	// the projected token is a symbolic link the kubelet swaps to a new file when rotating the token
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token-1"), []byte("sa-token-1\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token-2"), []byte("sa-token-2\n"), 0600))
+	subjectTokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "token-1"), subjectTokenFile))
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:         "testclientid",
+		GrantType:        grantTypeTokenExchange,
+		SubjectTokenFile: subjectTokenFile,
+		TokenURL:         server.URL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+
+	ts := oauth2Authenticator.tokenSource("")
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "exchanged-token", token.AccessToken)
+
+	// the rotated token is exchanged by the next token request
+	require.NoError(t, os.Remove(subjectTokenFile))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "token-2"), subjectTokenFile))
+	_, err = ts.Token()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"sa-token-1", "sa-token-2"}, subjectTokens)
+}
+
+func TestTokenExchangeGrantStart(t *testing.T) {
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:         "testclientid",
+		GrantType:        grantTypeTokenExchange,
+		SubjectTokenFile: filepath.Join(t.TempDir(), "missing"),
+		TokenURL:         "https://example.com/v1/token",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	assert.Error(t, oauth2Authenticator.Start(context.Background(), nil))
+
+	emptyFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(emptyFile, []byte("\n"), 0600))
+	oauth2Authenticator, err = newClientCredentialsExtension(&Config{
+		ClientID:         "testclientid",
+		GrantType:        grantTypeTokenExchange,
+		SubjectTokenFile: emptyFile,
+		TokenURL:         "https://example.com/v1/token",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	assert.ErrorIs(t, oauth2Authenticator.Start(context.Background(), nil), errEmptySubjectToken)
+}
+
+func TestTokenExchangeDefaultSubjectTokenFile(t *testing.T) {
+	cfg := &Config{
+		ClientID:  "testclientid",
+		GrantType: grantTypeTokenExchange,
+		TokenURL:  "https://example.com/v1/token",
+	}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "/var/run/secrets/kubernetes.io/serviceaccount/token", cfg.Effective().SubjectTokenFile)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, "/var/run/secrets/kubernetes.io/serviceaccount/token", oauth2Authenticator.subjectTokenFile)
+}
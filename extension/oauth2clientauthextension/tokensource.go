@@ -0,0 +1,601 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// FailedToGetSecurityTokenError is returned by the RoundTripper and PerRPCCredentials of the extension
+// when no token could be obtained from the authorization server.
+type FailedToGetSecurityTokenError struct {
+	id  config.ComponentID
+	err error
+}
+
+func (e *FailedToGetSecurityTokenError) Error() string {
+	if e.id == (config.ComponentID{}) {
+		return fmt.Sprintf("failed to get security token from token endpoint: %v", e.err)
+	}
+	return fmt.Sprintf("%v: failed to get security token from token endpoint: %v", e.id, e.err)
+}
+
+// Unwrap returns the error reported while fetching the token.
+func (e *FailedToGetSecurityTokenError) Unwrap() error {
+	return e.err
+}
+
+// Temporary reports whether fetching the token may succeed later, so that the request is worth retrying:
+// connection failures, timeouts, an open circuit breaker and token endpoint responses with the 429 or a 5xx status
+// code are temporary, other failures, like rejected client credentials or an untrusted certificate, are permanent.
+func (e *FailedToGetSecurityTokenError) Temporary() bool {
+	var rErr *oauth2.RetrieveError
+	if errors.As(e.err, &rErr) {
+		if rErr.Response != nil && (rErr.Response.StatusCode == http.StatusTooManyRequests || rErr.Response.StatusCode >= 500) {
+			return true
+		}
+		return e.ErrorCode() == "temporarily_unavailable"
+	}
+	if errors.Is(e.err, errCircuitOpen) || errors.Is(e.err, context.DeadlineExceeded) {
+		return true
+	}
+	return temporaryNetworkError(e.err)
+}
+
+// ErrorCode returns the OAuth2 error code of the token endpoint response, e.g. "invalid_client" or "invalid_scope",
+// or an empty string when the failure isn't an error response of the token endpoint.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+func (e *FailedToGetSecurityTokenError) ErrorCode() string {
+	var rErr *oauth2.RetrieveError
+	if !errors.As(e.err, &rErr) {
+		return ""
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rErr.Body, &response); err != nil {
+		return ""
+	}
+	return response.Error
+}
+
+// maskedTokenChars is the number of characters of each end of the tokens logged with log_token_masked.
+const maskedTokenChars = 4
+
+// maskToken returns the first and last few characters of token, e.g. "abcd…wxyz", for comparison with the tokens
+// listed by the authorization server. Tokens too short for the masked part to be most of them are fully masked.
+func maskToken(token string) string {
+	if len(token) < 4*maskedTokenChars {
+		return "…"
+	}
+	return token[:maskedTokenChars] + "…" + token[len(token)-maskedTokenChars:]
+}
+
+// errorWrappingTokenSource reports token fetch failures as FailedToGetSecurityTokenError, naming the
+// extension instance that failed, and records the lifetime of the tokens it hands out. The remaining lifetime
+// of the tokens is also logged at debug level, along with the masked token with log_token_masked, never the
+// tokens themselves.
+type errorWrappingTokenSource struct {
+	ts        oauth2.TokenSource
+	id        config.ComponentID
+	profile   string
+	logger    *zap.Logger
+	logMasked bool
+}
+
+func (s *errorWrappingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	// profiles missing from the configuration, e.g. selected by gRPC metadata, aren't recorded
+	// so that they don't add values to the profile label
+	if !errors.Is(err, errUnknownProfile) {
+		recordTokenExpiry(s.id, s.profile, token)
+	}
+	if err != nil {
+		err = &FailedToGetSecurityTokenError{id: s.id, err: err}
+		s.logger.Debug("Failed to get security token", zap.Error(err))
+		return nil, err
+	}
+	// checked first, so that the remaining lifetime is only computed when logged
+	if ce := s.logger.Check(zap.DebugLevel, "Using security token"); ce != nil {
+		var fields []zap.Field
+		if !token.Expiry.IsZero() {
+			fields = append(fields, zap.Duration("remaining_lifetime", time.Until(token.Expiry)))
+		}
+		if s.logMasked {
+			fields = append(fields, zap.String("token", maskToken(token.AccessToken)))
+		}
+		if len(fields) > 0 {
+			ce.Write(fields...)
+		}
+	}
+	return token, nil
+}
+
+func (s *errorWrappingTokenSource) cachedToken() *oauth2.Token {
+	return cachedToken(s.ts)
+}
+
+func (s *errorWrappingTokenSource) refreshTriggers() *refreshTriggers {
+	return refreshTriggersOf(s.ts)
+}
+
+// clientCredentialsTokenSource requests a new token with the client credentials grant on every call.
+type clientCredentialsTokenSource struct {
+	ctx  context.Context
+	conf *clientcredentials.Config
+}
+
+func (s *clientCredentialsTokenSource) Token() (*oauth2.Token, error) {
+	return s.conf.TokenSource(s.ctx).Token()
+}
+
+var errEmptyAccessToken = errors.New("token endpoint returned an empty access_token")
+
+// nonEmptyTokenSource rejects tokens without an access token. The oauth2 package rejects empty access tokens,
+// but not blank ones, which some authorization servers return when failing.
+type nonEmptyTokenSource struct {
+	ts oauth2.TokenSource
+}
+
+func (s *nonEmptyTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(token.AccessToken) == "" {
+		return nil, errEmptyAccessToken
+	}
+	return token, nil
+}
+
+// callbackTokenSource invokes the callbacks registered with WithOnSuccess and WithOnFailure.
+type callbackTokenSource struct {
+	ts        oauth2.TokenSource
+	id        config.ComponentID
+	profile   string
+	scopes    []string
+	onSuccess []func(TokenAcquisition)
+	onFailure []func(error)
+}
+
+func (s *callbackTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		failure := &FailedToGetSecurityTokenError{id: s.id, err: err}
+		for _, callback := range s.onFailure {
+			callback(failure)
+		}
+		return nil, err
+	}
+
+	scopes := s.scopes
+	if granted, ok := token.Extra("scope").(string); ok && granted != "" {
+		scopes = strings.Fields(granted)
+	}
+	acquisition := TokenAcquisition{
+		ID:      s.id,
+		Profile: s.profile,
+		Expiry:  token.Expiry,
+		Scopes:  scopes,
+	}
+	for _, callback := range s.onSuccess {
+		callback(acquisition)
+	}
+	return token, nil
+}
+
+// withClientIDInBody returns a copy of conf sending the client_id form field in the request body, whatever the
+// authentication style. The client credentials are still sent in the Authorization header when it is used.
+func withClientIDInBody(conf *clientcredentials.Config) *clientcredentials.Config {
+	params := url.Values{}
+	for k, v := range conf.EndpointParams {
+		params[k] = v
+	}
+	params.Set("client_id", conf.ClientID)
+
+	custom := *conf
+	custom.EndpointParams = params
+	return &custom
+}
+
+// withCredentialFields returns a copy of conf sending the client credentials in the request body under the given
+// form field names, the ones of the specification being used for empty names.
+func withCredentialFields(conf *clientcredentials.Config, clientIDField, clientSecretField string) *clientcredentials.Config {
+	if clientIDField == "" {
+		clientIDField = "client_id"
+	}
+	if clientSecretField == "" {
+		clientSecretField = "client_secret"
+	}
+
+	params := url.Values{}
+	for k, v := range conf.EndpointParams {
+		params[k] = v
+	}
+	params.Set(clientIDField, conf.ClientID)
+	if conf.ClientSecret != "" {
+		params.Set(clientSecretField, conf.ClientSecret)
+	}
+
+	// the credentials are left out of the config so that they aren't sent again under the standard names
+	custom := *conf
+	custom.ClientID = ""
+	custom.ClientSecret = ""
+	custom.AuthStyle = oauth2.AuthStyleInParams
+	custom.EndpointParams = params
+	return &custom
+}
+
+// tokenCache is implemented by the token sources that cache tokens, so that tests can assert which token is
+// cached without obtaining one. It is deliberately unexported: the cached token isn't part of the API.
+type tokenCache interface {
+	// cachedToken returns the cached token, nil when none was obtained yet.
+	cachedToken() *oauth2.Token
+}
+
+// cachedToken returns the token cached by ts, nil when ts doesn't cache tokens or didn't obtain one yet.
+func cachedToken(ts oauth2.TokenSource) *oauth2.Token {
+	if cache, ok := ts.(tokenCache); ok {
+		return cache.cachedToken()
+	}
+	return nil
+}
+
+// reuseTokenSource caches tokens with oauth2.ReuseTokenSource, keeping track of the token it hands out,
+// which oauth2.ReuseTokenSource doesn't expose.
+type reuseTokenSource struct {
+	ts oauth2.TokenSource
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func newReuseTokenSource(token *oauth2.Token, ts oauth2.TokenSource) *reuseTokenSource {
+	return &reuseTokenSource{ts: oauth2.ReuseTokenSource(token, ts), token: token}
+}
+
+func (s *reuseTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	return token, nil
+}
+
+func (s *reuseTokenSource) cachedToken() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// singleTokenSource obtains a token once and keeps returning it, even after it expired.
+// A seeded token, obtained when starting, is only returned while it is valid, a token being obtained once it expired.
+type singleTokenSource struct {
+	mu     sync.Mutex
+	ts     oauth2.TokenSource
+	token  *oauth2.Token
+	seeded bool
+}
+
+func (s *singleTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && (!s.seeded || s.token.Valid()) {
+		return s.token, nil
+	}
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	s.seeded = false
+	return token, nil
+}
+
+func (s *singleTokenSource) cachedToken() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// clampMinValidity returns the lifetime a token obtained at the given time must have left to be handed out:
+// minValidity, but at most half of the lifetime of the token when it was obtained, so that tokens living shorter than
+// minValidity are still cached for a while instead of being requested again on every call.
+// Tokens obtained at an unknown time, like the warm-up ones, are checked against minValidity.
+func clampMinValidity(minValidity time.Duration, obtained time.Time, token *oauth2.Token) time.Duration {
+	if obtained.IsZero() {
+		return minValidity
+	}
+	if half := token.Expiry.Sub(obtained) / 2; half < minValidity {
+		return half
+	}
+	return minValidity
+}
+
+// minValidityTokenSource caches tokens like oauth2.ReuseTokenSource, but refreshes them as soon as their remaining
+// lifetime falls below minValidity, or below half of their lifetime for tokens living shorter than minValidity.
+type minValidityTokenSource struct {
+	ts          oauth2.TokenSource
+	minValidity time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	token    *oauth2.Token
+	obtained time.Time
+}
+
+func (s *minValidityTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && (s.token.Expiry.IsZero() ||
+		s.now().Add(clampMinValidity(s.minValidity, s.obtained, s.token)).Before(s.token.Expiry)) {
+		return s.token, nil
+	}
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token, s.obtained = token, s.now()
+	return token, nil
+}
+
+func (s *minValidityTokenSource) cachedToken() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// refreshFailureBackoff is the initial interval, doubled up to maxRefreshFailureBackoff on consecutive failures,
+// during which no new token is requested after a refresh failed, the cached token being handed out instead.
+const (
+	refreshFailureBackoff    = time.Second
+	maxRefreshFailureBackoff = time.Minute
+)
+
+// revalidatingTokenSource caches tokens like minValidityTokenSource, but hands out the cached token right away
+// once its remaining lifetime falls within window of minValidity, while a new token is obtained in the background,
+// so that requests don't wait for the refresh. A single background refresh runs at a time, none once lifetime
+// is done, and after a failure, the next one waits for a backoff. A warning is logged once per streak of failures.
+type revalidatingTokenSource struct {
+	ts          oauth2.TokenSource
+	minValidity time.Duration
+	window      time.Duration
+	now         func() time.Time
+	logger      *zap.Logger
+	// lifetime is the lifetime of the extension, which the token requests of ts are bound to.
+	lifetime context.Context
+
+	mu           sync.Mutex
+	token        *oauth2.Token
+	obtained     time.Time
+	revalidating bool
+	backoff      retryBackoff
+	nextAttempt  time.Time
+	failing      bool
+}
+
+func (s *revalidatingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && s.token.Expiry.IsZero() {
+		return s.token, nil
+	}
+	if s.token != nil {
+		minValidity := clampMinValidity(s.minValidity, s.obtained, s.token)
+		if s.now().Add(minValidity).Before(s.token.Expiry) {
+			if s.shouldRevalidate(minValidity) {
+				s.revalidating = true
+				go s.revalidate()
+			}
+			return s.token, nil
+		}
+	}
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token, s.obtained = token, s.now()
+	s.recovered()
+	return token, nil
+}
+
+// shouldRevalidate reports whether a background refresh of the cached token starts, the token having minValidity
+// left to live.
+func (s *revalidatingTokenSource) shouldRevalidate(minValidity time.Duration) bool {
+	now := s.now()
+	return !s.revalidating && s.lifetime.Err() == nil && !now.Before(s.nextAttempt) &&
+		!now.Add(minValidity+s.window).Before(s.token.Expiry)
+}
+
+// revalidate obtains a new token in the background, the cached token being kept when that fails.
+func (s *revalidatingTokenSource) revalidate() {
+	token, err := s.ts.Token()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revalidating = false
+	if err != nil {
+		if s.lifetime.Err() != nil {
+			// the token request was cancelled by the shutdown
+			return
+		}
+		s.nextAttempt = s.now().Add(s.backoff.next(refreshFailureBackoff, maxRefreshFailureBackoff))
+		if !s.failing {
+			s.failing = true
+			s.logger.Warn("Failed to refresh security token in the background, using the cached token",
+				zap.Time("expiry", s.token.Expiry), zap.Time("next_attempt", s.nextAttempt), zap.Error(err))
+		}
+		return
+	}
+	s.token, s.obtained = token, s.now()
+	s.recovered()
+}
+
+// recovered resets the backoff once a token is obtained.
+func (s *revalidatingTokenSource) recovered() {
+	s.failing = false
+	s.nextAttempt = time.Time{}
+	s.backoff.reset()
+}
+
+func (s *revalidatingTokenSource) cachedToken() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// staleTokenSource hands out the last token obtained when a new one can't be, as long as it expired less than
+// maxStale ago, so that requests keep being authorized during short outages of the authorization server.
+// After a failure, the expired token is handed out without requesting a new one until a backoff has passed, so that
+// requests don't wait for a failing authorization server every time. A warning is logged once per streak of failures.
+type staleTokenSource struct {
+	ts       oauth2.TokenSource
+	maxStale time.Duration
+	now      func() time.Time
+	logger   *zap.Logger
+
+	mu          sync.Mutex
+	token       *oauth2.Token
+	backoff     retryBackoff
+	nextAttempt time.Time
+	failing     bool
+}
+
+func (s *staleTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	if now := s.now(); s.token != nil && now.Before(s.nextAttempt) && s.servable(now) {
+		defer s.mu.Unlock()
+		return s.token, nil
+	}
+	s.mu.Unlock()
+
+	token, err := s.ts.Token()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.token = token
+		s.failing = false
+		s.nextAttempt = time.Time{}
+		s.backoff.reset()
+		return token, nil
+	}
+	now := s.now()
+	if s.token == nil || !s.servable(now) {
+		return nil, err
+	}
+	s.nextAttempt = now.Add(s.backoff.next(refreshFailureBackoff, maxRefreshFailureBackoff))
+	if !s.failing {
+		s.failing = true
+		s.logger.Warn("Failed to refresh security token, using the expired token",
+			zap.Time("expiry", s.token.Expiry), zap.Time("next_attempt", s.nextAttempt), zap.Error(err))
+	}
+	return s.token, nil
+}
+
+// servable reports whether the last token obtained expired less than maxStale ago.
+func (s *staleTokenSource) servable(now time.Time) bool {
+	return !now.After(s.token.Expiry.Add(s.maxStale))
+}
+
+// cachedToken returns the token cached by the wrapped token source, falling back to the last token obtained.
+func (s *staleTokenSource) cachedToken() *oauth2.Token {
+	if token := cachedToken(s.ts); token != nil {
+		return token
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// reloadingTokenSource builds the token source of the current configuration of the extension,
+// rebuilding it, and so dropping the cached token, when the configuration is reloaded.
+type reloadingTokenSource struct {
+	o        *ClientCredentialsAuthenticator
+	profile  string
+	protocol string
+	// triggers are the requests waiting for a token of this token source, when tracing, kept across reloads.
+	triggers   *refreshTriggers
+	mu         sync.Mutex
+	ts         oauth2.TokenSource
+	generation uint64
+}
+
+func (s *reloadingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	if s.ts == nil || s.generation != s.o.currentGeneration() {
+		ts, generation, err := s.o.newTokenSource(s.profile, s.protocol, s.triggers)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.ts, s.generation = ts, generation
+	}
+	ts := s.ts
+	s.mu.Unlock()
+	return ts.Token()
+}
+
+func (s *reloadingTokenSource) refreshTriggers() *refreshTriggers {
+	return s.triggers
+}
+
+// cachedToken returns the token cached for the current configuration, nil once it was reloaded.
+func (s *reloadingTokenSource) cachedToken() *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ts == nil || s.generation != s.o.currentGeneration() {
+		return nil
+	}
+	return cachedToken(s.ts)
+}
+
+// maxLifetimeTokenSource caps the lifetime of the tokens, so that tokens with an absurd expiry, e.g. given
+// by a faulty authorization server, are still refreshed.
+type maxLifetimeTokenSource struct {
+	ts          oauth2.TokenSource
+	maxLifetime time.Duration
+	now         func() time.Time
+	logger      *zap.Logger
+}
+
+func (s *maxLifetimeTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	if maxExpiry := s.now().Add(s.maxLifetime); token.Expiry.After(maxExpiry) {
+		s.logger.Warn("Capping the lifetime of the token to max_token_lifetime",
+			zap.Time("token_expiry", token.Expiry), zap.Time("expiry", maxExpiry))
+		token.Expiry = maxExpiry
+	}
+	return token, nil
+}
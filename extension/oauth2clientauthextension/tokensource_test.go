@@ -0,0 +1,1097 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/oauth2"
+)
+
+func TestFailedToGetSecurityTokenErrorNamesExtension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+	}))
+	defer server.Close()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ExtensionSettings: config.NewExtensionSettings(config.NewComponentIDWithName(typeStr, "backend-a")),
+		ClientID:          "testclientid",
+		ClientSecret:      "testsecret",
+		TokenURL:          server.URL,
+	}, zap.New(core))
+	require.NoError(t, err)
+
+	roundTripper, err := oauth2Authenticator.RoundTripper(&testRoundTripper{})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/v1/metrics", nil)
+	require.NoError(t, err)
+	_, err = roundTripper.RoundTrip(req)
+
+	var tokenErr *FailedToGetSecurityTokenError
+	require.True(t, errors.As(err, &tokenErr))
+	assert.Contains(t, err.Error(), "oauth2client/backend-a: failed to get security token from token endpoint")
+	var retrieveErr *oauth2.RetrieveError
+	assert.True(t, errors.As(err, &retrieveErr))
+
+	entries := logs.FilterMessage("Failed to get security token").All()
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0].ContextMap()["error"], "oauth2client/backend-a")
+}
+
+func TestFailedToGetSecurityTokenErrorWithoutID(t *testing.T) {
+	err := &FailedToGetSecurityTokenError{err: errors.New("connection refused")}
+	assert.Equal(t, "failed to get security token from token endpoint: connection refused", err.Error())
+}
+
+func TestFailedToGetSecurityTokenErrorClassification(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		expectedTemporary bool
+		expectedCode      string
+	}{
+		{
+			name:         "invalid_client",
+			status:       http.StatusUnauthorized,
+			body:         `{"error":"invalid_client"}`,
+			expectedCode: "invalid_client",
+		},
+		{
+			name:         "invalid_scope",
+			status:       http.StatusBadRequest,
+			body:         `{"error":"invalid_scope","error_description":"unknown scope"}`,
+			expectedCode: "invalid_scope",
+		},
+		{
+			name:              "temporarily_unavailable",
+			status:            http.StatusBadRequest,
+			body:              `{"error":"temporarily_unavailable"}`,
+			expectedTemporary: true,
+			expectedCode:      "temporarily_unavailable",
+		},
+		{
+			name:              "too_many_requests",
+			status:            http.StatusTooManyRequests,
+			expectedTemporary: true,
+		},
+		{
+			name:              "server_error",
+			status:            http.StatusBadGateway,
+			body:              `<html>Bad Gateway</html>`,
+			expectedTemporary: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			var tokenErr *FailedToGetSecurityTokenError
+			require.True(t, errors.As(err, &tokenErr))
+			assert.Equal(t, test.expectedTemporary, tokenErr.Temporary())
+			assert.Equal(t, test.expectedCode, tokenErr.ErrorCode())
+		})
+	}
+}
+
+func TestFailedToGetSecurityTokenErrorClassificationWithoutResponse(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	unreachableURL := server.URL
+	server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     unreachableURL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	_, err = fetchToken(oauth2Authenticator)
+	var tokenErr *FailedToGetSecurityTokenError
+	require.True(t, errors.As(err, &tokenErr))
+	assert.True(t, tokenErr.Temporary(), "network errors are temporary")
+	assert.Empty(t, tokenErr.ErrorCode())
+
+	tests := []struct {
+		name              string
+		err               error
+		expectedTemporary bool
+	}{
+		{
+			name:              "timeout",
+			err:               context.DeadlineExceeded,
+			expectedTemporary: true,
+		},
+		{
+			name:              "circuit_open",
+			err:               fmt.Errorf("%w: connection refused", errCircuitOpen),
+			expectedTemporary: true,
+		},
+		{
+			name: "unknown_profile",
+			err:  fmt.Errorf("%w: %q", errUnknownProfile, "shipping"),
+		},
+		{
+			name: "unreadable_assertion",
+			err:  fmt.Errorf("failed to read SAML assertion: %w", os.ErrNotExist),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenErr := &FailedToGetSecurityTokenError{err: test.err}
+			assert.Equal(t, test.expectedTemporary, tokenErr.Temporary())
+			assert.Empty(t, tokenErr.ErrorCode())
+		})
+	}
+}
+
+func TestFailedToGetSecurityTokenErrorClassificationOfClientFailures(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		tokenURL string
+	}{
+		{
+			// the certificate of the test server isn't trusted by the extension
+			name:     "untrusted_certificate",
+			tokenURL: server.URL,
+		},
+		{
+			name:     "unsupported_scheme",
+			tokenURL: "ftp://example.com/token",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     test.tokenURL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			var tokenErr *FailedToGetSecurityTokenError
+			require.True(t, errors.As(err, &tokenErr))
+			assert.False(t, tokenErr.Temporary())
+		})
+	}
+}
+
+func TestRemainingLifetimeLogging(t *testing.T) {
+	for _, level := range []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel} {
+		t.Run(level.String(), func(t *testing.T) {
+			core, logs := observer.New(level)
+			ts := &errorWrappingTokenSource{
+				logger: zap.New(core),
+				ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+					return &oauth2.Token{AccessToken: "secret-token", Expiry: time.Now().Add(time.Hour)}, nil
+				}),
+			}
+
+			for i := 0; i < 2; i++ {
+				_, err := ts.Token()
+				require.NoError(t, err)
+			}
+
+			if level != zapcore.DebugLevel {
+				assert.Zero(t, logs.Len())
+				return
+			}
+			entries := logs.FilterMessage("Using security token").All()
+			require.Len(t, entries, 2)
+			for _, entry := range entries {
+				remaining, ok := entry.ContextMap()["remaining_lifetime"].(time.Duration)
+				require.True(t, ok)
+				assert.InDelta(t, time.Hour, remaining, float64(time.Minute))
+				assert.NotContains(t, fmt.Sprint(entry.ContextMap()), "secret-token")
+			}
+		})
+	}
+}
+
+func TestMaskToken(t *testing.T) {
+	assert.Equal(t, "eyJh…Xk9Q", maskToken("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJjb2xsZWN0b3IifQ.sig-Xk9Q"))
+	assert.Equal(t, "abcd…mnop", maskToken("abcdefghijklmnop"))
+	// short tokens would be mostly revealed
+	assert.Equal(t, "…", maskToken("abcdefghijklmno"))
+	assert.Equal(t, "…", maskToken(""))
+}
+
+func TestMaskedTokenLogging(t *testing.T) {
+	for _, logMasked := range []bool{false, true} {
+		t.Run(fmt.Sprint(logMasked), func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			ts := &errorWrappingTokenSource{
+				logger:    zap.New(core),
+				logMasked: logMasked,
+				ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+					return &oauth2.Token{AccessToken: "secret-token-1234abcd"}, nil
+				}),
+			}
+
+			_, err := ts.Token()
+			require.NoError(t, err)
+
+			entries := logs.FilterMessage("Using security token").All()
+			if !logMasked {
+				// tokens without expiry have nothing to log
+				assert.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 1)
+			assert.Equal(t, "secr…abcd", entries[0].ContextMap()["token"])
+			assert.NotContains(t, fmt.Sprint(entries[0].ContextMap()), "secret-token-1234abcd")
+		})
+	}
+}
+
+func TestEmptyAccessToken(t *testing.T) {
+	for _, accessToken := range []string{"", "  "} {
+		t.Run(fmt.Sprintf("%q", accessToken), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":3600}`, accessToken)
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			var tokenErr *FailedToGetSecurityTokenError
+			require.True(t, errors.As(err, &tokenErr))
+			assert.Contains(t, err.Error(), "access_token")
+			assert.False(t, tokenErr.Temporary())
+		})
+	}
+}
+
+func TestDisableAutoRefresh(t *testing.T) {
+	tests := []struct {
+		name             string
+		disableRefresh   bool
+		expectedRequests int
+	}{
+		{
+			name:             "refreshes_expired_token",
+			expectedRequests: 2,
+		},
+		{
+			name:             "keeps_expired_token",
+			disableRefresh:   true,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				// the token is considered expired right away, as it expires within oauth2's expiry delta
+				_, _ = w.Write([]byte(`{"access_token":"short-lived","token_type":"bearer","expires_in":1}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:           "testclientid",
+				ClientSecret:       "testsecret",
+				TokenURL:           server.URL,
+				DisableAutoRefresh: test.disableRefresh,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			ts := oauth2Authenticator.tokenSource("")
+			token, err := ts.Token()
+			require.NoError(t, err)
+			assert.False(t, token.Valid())
+
+			token, err = ts.Token()
+			require.NoError(t, err)
+			assert.Equal(t, "short-lived", token.AccessToken)
+			assert.Equal(t, test.expectedRequests, requests)
+		})
+	}
+}
+
+func TestSingleTokenSourceRetriesFailedFetch(t *testing.T) {
+	calls := 0
+	ts := &singleTokenSource{ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &oauth2.Token{AccessToken: "token"}, nil
+	})}
+
+	_, err := ts.Token()
+	assert.Error(t, err)
+	for i := 0; i < 2; i++ {
+		token, err := ts.Token()
+		require.NoError(t, err)
+		assert.Equal(t, "token", token.AccessToken)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestSingleTokenSourceSeededToken(t *testing.T) {
+	calls := 0
+	seed := &oauth2.Token{AccessToken: "seed", Expiry: time.Now().Add(time.Hour)}
+	ts := &singleTokenSource{ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+		calls++
+		return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(-time.Minute)}, nil
+	}), token: seed, seeded: true}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "seed", token.AccessToken)
+	assert.Equal(t, 0, calls)
+
+	// the expired seeded token is replaced by the single token, kept even after it expired
+	seed.Expiry = time.Now().Add(-time.Minute)
+	for i := 0; i < 2; i++ {
+		token, err = ts.Token()
+		require.NoError(t, err)
+		assert.Equal(t, "token", token.AccessToken)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestMinRemainingValidity(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":120}`, requests)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name             string
+		minValidity      time.Duration
+		expectedRequests int
+	}{
+		{
+			name:             "reuses_token_with_enough_validity",
+			minValidity:      time.Minute,
+			expectedRequests: 1,
+		},
+		{
+			// the minimum validity is brought down to half of the lifetime of the token
+			name:             "caches_token_living_shorter_than_min_validity",
+			minValidity:      5 * time.Minute,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests = 0
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:             "testclientid",
+				ClientSecret:         "testsecret",
+				TokenURL:             server.URL,
+				MinRemainingValidity: test.minValidity,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			ts := oauth2Authenticator.tokenSource("")
+			for i := 0; i < 2; i++ {
+				token, err := ts.Token()
+				require.NoError(t, err)
+				// a fresh token is handed out even when it doesn't satisfy the minimum validity
+				assert.Equal(t, "token-1", token.AccessToken)
+			}
+			assert.Equal(t, test.expectedRequests, requests)
+		})
+	}
+}
+
+func TestMinValidityTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	ts := &minValidityTokenSource{
+		minValidity: time.Minute,
+		now:         func() time.Time { return now },
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", calls), Expiry: now.Add(10 * time.Minute)}, nil
+		}),
+	}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// 90s left: still valid for a minute
+	now = now.Add(8*time.Minute + 30*time.Second)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// 30s left: still reused by oauth2.ReuseTokenSource, but not valid for a minute
+	now = now.Add(time.Minute)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token.AccessToken)
+}
+
+func TestMinValidityTokenSourceShortLivedTokens(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	ts := &minValidityTokenSource{
+		minValidity: 5 * time.Minute,
+		now:         func() time.Time { return now },
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", calls), Expiry: now.Add(2 * time.Minute)}, nil
+		}),
+	}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// 70s left: less than min_remaining_validity, but more than half of the lifetime of the token
+	now = now.Add(50 * time.Second)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+	assert.Same(t, token, cachedToken(ts))
+
+	// 50s left: less than half of the lifetime of the token
+	now = now.Add(20 * time.Second)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token.AccessToken)
+}
+
+func TestMaxTokenLifetime(t *testing.T) {
+	tests := []struct {
+		name           string
+		expiresIn      int
+		expectedExpiry time.Duration
+		expectedWarns  int
+	}{
+		{
+			name:           "absurd_lifetime_capped",
+			expiresIn:      100 * 365 * 24 * 3600,
+			expectedExpiry: 24 * time.Hour,
+			expectedWarns:  1,
+		},
+		{
+			name:           "lifetime_within_cap",
+			expiresIn:      3600,
+			expectedExpiry: time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":"test-token","token_type":"bearer","expires_in":%d}`, test.expiresIn)
+			}))
+			defer server.Close()
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:         "testclientid",
+				ClientSecret:     "testsecret",
+				TokenURL:         server.URL,
+				MaxTokenLifetime: 24 * time.Hour,
+			}, zap.New(core))
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(test.expectedExpiry), token.Expiry, 5*time.Second)
+			assert.Equal(t, test.expectedWarns, logs.Len())
+		})
+	}
+}
+
+func TestStaleTokenSource(t *testing.T) {
+	now := time.Unix(0, 0)
+	expiry := now.Add(time.Hour)
+	refreshErr := errors.New("connection refused")
+
+	tests := []struct {
+		name          string
+		elapsed       time.Duration
+		expectedStale bool
+	}{
+		{
+			name:          "within_stale_window",
+			elapsed:       time.Hour + 4*time.Minute,
+			expectedStale: true,
+		},
+		{
+			name:          "end_of_stale_window",
+			elapsed:       time.Hour + 5*time.Minute,
+			expectedStale: true,
+		},
+		{
+			name:    "beyond_stale_window",
+			elapsed: time.Hour + 6*time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current := now
+			var fetchErr error
+			core, logs := observer.New(zapcore.WarnLevel)
+			ts := &staleTokenSource{
+				maxStale: 5 * time.Minute,
+				now:      func() time.Time { return current },
+				logger:   zap.New(core),
+				ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+					if fetchErr != nil {
+						return nil, fetchErr
+					}
+					return &oauth2.Token{AccessToken: "test-token", Expiry: expiry}, nil
+				}),
+			}
+
+			token, err := ts.Token()
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+
+			current = now.Add(test.elapsed)
+			fetchErr = refreshErr
+			token, err = ts.Token()
+			if !test.expectedStale {
+				assert.Equal(t, refreshErr, err)
+				assert.Nil(t, token)
+				assert.Zero(t, logs.Len())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+			require.Equal(t, 1, logs.Len())
+			assert.Equal(t, "Failed to refresh security token, using the expired token", logs.All()[0].Message)
+		})
+	}
+}
+
+func TestStaleTokenSourceBackoff(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	var fetchErr error
+	core, logs := observer.New(zapcore.WarnLevel)
+	ts := &staleTokenSource{
+		maxStale: time.Hour,
+		now:      func() time.Time { return now },
+		logger:   zap.New(core),
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", calls), Expiry: now}, nil
+		}),
+	}
+
+	_, err := ts.Token()
+	require.NoError(t, err)
+
+	fetchErr = errors.New("connection refused")
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+	assert.Equal(t, 2, calls)
+
+	// the expired token is handed out right away during the backoff
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+	assert.Equal(t, 2, calls)
+
+	// a new token is requested once the backoff has passed, the failure being logged once
+	now = now.Add(refreshFailureBackoff)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, logs.Len())
+
+	// the backoff doubles
+	now = now.Add(refreshFailureBackoff)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// the backoff is reset once a token is obtained
+	now = now.Add(refreshFailureBackoff)
+	fetchErr = nil
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-4", token.AccessToken)
+	fetchErr = errors.New("connection refused")
+	_, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, 2, logs.Len())
+}
+
+func TestStaleTokenSourceWithoutPreviousToken(t *testing.T) {
+	refreshErr := errors.New("connection refused")
+	ts := &staleTokenSource{
+		maxStale: time.Hour,
+		now:      time.Now,
+		logger:   zap.NewNop(),
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			return nil, refreshErr
+		}),
+	}
+	_, err := ts.Token()
+	assert.Equal(t, refreshErr, err)
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+func TestCustomCredentialFields(t *testing.T) {
+	tests := []struct {
+		name                string
+		clientIDField       string
+		clientSecretField   string
+		expectedIDField     string
+		expectedSecretField string
+	}{
+		{
+			name:                "both_fields",
+			clientIDField:       "clientId",
+			clientSecretField:   "clientSecret",
+			expectedIDField:     "clientId",
+			expectedSecretField: "clientSecret",
+		},
+		{
+			name:                "client_id_field_only",
+			clientIDField:       "clientId",
+			expectedIDField:     "clientId",
+			expectedSecretField: "client_secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				_, _, hasBasicAuth := r.BasicAuth()
+				assert.False(t, hasBasicAuth)
+				assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+				assert.Equal(t, "testclientid", r.PostForm.Get(test.expectedIDField))
+				assert.Equal(t, "testsecret", r.PostForm.Get(test.expectedSecretField))
+				if test.expectedIDField != "client_id" {
+					assert.NotContains(t, r.PostForm, "client_id")
+				}
+				if test.expectedSecretField != "client_secret" {
+					assert.NotContains(t, r.PostForm, "client_secret")
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:          "testclientid",
+				ClientSecret:      "testsecret",
+				ClientIDField:     test.clientIDField,
+				ClientSecretField: test.clientSecretField,
+				TokenURL:          server.URL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestAlwaysSendClientIDInBody(t *testing.T) {
+	tests := []struct {
+		name              string
+		alwaysSendInBody  bool
+		authStyle         oauth2.AuthStyle
+		expectedBasicAuth bool
+		expectedInBody    bool
+	}{
+		{
+			name:              "basic_auth_and_body",
+			alwaysSendInBody:  true,
+			authStyle:         oauth2.AuthStyleInHeader,
+			expectedBasicAuth: true,
+			expectedInBody:    true,
+		},
+		{
+			name:              "basic_auth_only",
+			authStyle:         oauth2.AuthStyleInHeader,
+			expectedBasicAuth: true,
+		},
+		{
+			name:             "body_only",
+			alwaysSendInBody: true,
+			authStyle:        oauth2.AuthStyleInParams,
+			expectedInBody:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, r.ParseForm())
+				clientID, clientSecret, hasBasicAuth := r.BasicAuth()
+				assert.Equal(t, test.expectedBasicAuth, hasBasicAuth)
+				if hasBasicAuth {
+					assert.Equal(t, "testclientid", clientID)
+					assert.Equal(t, "testsecret", clientSecret)
+					assert.NotContains(t, r.PostForm, "client_secret")
+				}
+				if test.expectedInBody {
+					assert.Equal(t, []string{"testclientid"}, r.PostForm["client_id"])
+				} else {
+					assert.NotContains(t, r.PostForm, "client_id")
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                 "testclientid",
+				ClientSecret:             "testsecret",
+				TokenURL:                 server.URL,
+				AlwaysSendClientIDInBody: test.alwaysSendInBody,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = test.authStyle
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+		})
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	now := time.Unix(0, 0)
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	ts := &revalidatingTokenSource{
+		window: 5 * time.Minute,
+		now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+		logger:   zap.NewNop(),
+		lifetime: context.Background(),
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			mu.Lock()
+			calls++
+			call := calls
+			expiry := now.Add(10 * time.Minute)
+			mu.Unlock()
+			if call > 1 {
+				// the background refresh blocks until released
+				<-release
+			}
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", call), Expiry: expiry}, nil
+		}),
+	}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// 6 minutes left: outside of the window
+	mu.Lock()
+	now = now.Add(4 * time.Minute)
+	mu.Unlock()
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token.AccessToken)
+
+	// 4 minutes left: the cached token is handed out while the refresh is blocked, a single refresh running
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	mu.Unlock()
+	for i := 0; i < 3; i++ {
+		token, err = ts.Token()
+		require.NoError(t, err)
+		assert.Equal(t, "token-1", token.AccessToken)
+	}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls == 2
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		token, err = ts.Token()
+		return err == nil && token.AccessToken == "token-2"
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, calls)
+}
+
+func TestStaleWhileRevalidateFailure(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Unix(0, 0)
+	refreshes := make(chan struct{})
+	core, logs := observer.New(zap.WarnLevel)
+	ts := &revalidatingTokenSource{
+		window: 5 * time.Minute,
+		now: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+		logger:   zap.New(core),
+		lifetime: context.Background(),
+		token:    &oauth2.Token{AccessToken: "cached", Expiry: now.Add(time.Minute)},
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			refreshes <- struct{}{}
+			return nil, errors.New("connection refused")
+		}),
+	}
+	// waitForRevalidation waits for the background refresh started by the previous call to end
+	waitForRevalidation := func() {
+		<-refreshes
+		require.Eventually(t, func() bool {
+			ts.mu.Lock()
+			defer ts.mu.Unlock()
+			return !ts.revalidating
+		}, time.Second, time.Millisecond)
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "cached", token.AccessToken)
+	waitForRevalidation()
+
+	// the cached token is kept until it expires, no refresh being attempted during the backoff
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "cached", token.AccessToken)
+	ts.mu.Lock()
+	assert.False(t, ts.revalidating)
+	ts.mu.Unlock()
+
+	// a refresh is attempted again once the backoff has passed, without logging the failure again
+	advance(refreshFailureBackoff)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	waitForRevalidation()
+	assert.Equal(t, 1, logs.Len())
+
+	// the backoff doubles
+	advance(refreshFailureBackoff)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	ts.mu.Lock()
+	assert.False(t, ts.revalidating)
+	ts.mu.Unlock()
+}
+
+func TestStaleWhileRevalidateAfterShutdown(t *testing.T) {
+	now := time.Unix(0, 0)
+	lifetime, endLifetime := context.WithCancel(context.Background())
+	endLifetime()
+	ts := &revalidatingTokenSource{
+		window:   5 * time.Minute,
+		now:      func() time.Time { return now },
+		logger:   zap.NewNop(),
+		lifetime: lifetime,
+		token:    &oauth2.Token{AccessToken: "cached", Expiry: now.Add(time.Minute)},
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			t.Error("no token is requested in the background once the extension is shut down")
+			return nil, context.Canceled
+		}),
+	}
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "cached", token.AccessToken)
+}
+
+func TestCachedToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings func(*Config)
+	}{
+		{
+			name:     "reuse",
+			settings: func(*Config) {},
+		},
+		{
+			name:     "disable_auto_refresh",
+			settings: func(cfg *Config) { cfg.DisableAutoRefresh = true },
+		},
+		{
+			name:     "min_remaining_validity",
+			settings: func(cfg *Config) { cfg.MinRemainingValidity = time.Minute },
+		},
+		{
+			name:     "max_stale",
+			settings: func(cfg *Config) { cfg.ServeStaleOnRefreshFailure, cfg.MaxStale = true, time.Minute },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, requests)
+			}))
+			defer server.Close()
+
+			cfg := &Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+			}
+			test.settings(cfg)
+			oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+			require.NoError(t, err)
+
+			ts := oauth2Authenticator.tokenSource("")
+			assert.Nil(t, cachedToken(ts))
+
+			token, err := ts.Token()
+			require.NoError(t, err)
+			assert.Same(t, token, cachedToken(ts))
+
+			// inspecting the cached token doesn't obtain one
+			assert.Equal(t, "token-1", cachedToken(ts).AccessToken)
+			assert.Equal(t, 1, requests)
+		})
+	}
+}
+
+func TestCachedTokenDroppedOnReload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	cfg := &Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+	}
+	oauth2Authenticator, err := newClientCredentialsExtension(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	ts := oauth2Authenticator.tokenSource("")
+	_, err = ts.Token()
+	require.NoError(t, err)
+	require.NotNil(t, cachedToken(ts))
+
+	require.NoError(t, oauth2Authenticator.Reload(cfg))
+	assert.Nil(t, cachedToken(ts))
+}
+
+func TestCachedTokenRefresh(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	ts := &minValidityTokenSource{
+		minValidity: time.Minute,
+		now:         func() time.Time { return now },
+		ts: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", calls), Expiry: now.Add(10 * time.Minute)}, nil
+		}),
+	}
+
+	_, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", cachedToken(ts).AccessToken)
+
+	// the cached token is kept until a token is requested, even when it needs to be refreshed
+	now = now.Add(9*time.Minute + 30*time.Second)
+	assert.Equal(t, "token-1", cachedToken(ts).AccessToken)
+
+	_, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", cachedToken(ts).AccessToken)
+}
+
+func TestCachedTokenWithoutCache(t *testing.T) {
+	ts := tokenSourceFunc(func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "token"}, nil
+	})
+	_, err := ts.Token()
+	require.NoError(t, err)
+	assert.Nil(t, cachedToken(ts))
+}
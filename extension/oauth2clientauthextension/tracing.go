@@ -0,0 +1,238 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tracerName       = "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+	refreshSpanName  = "oauth2client/token_refresh"
+	extensionAttrKey = attribute.Key("extension")
+	profileAttrKey   = attribute.Key("profile")
+
+	// attributes of the spans of the requests authorized by the extension
+	refreshOccurredAttrKey = attribute.Key("auth.refresh_occurred")
+	waitMsAttrKey          = attribute.Key("auth.wait_ms")
+)
+
+// withTracerProvider makes the extension emit a span for every token request with the tracers of tp.
+func withTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *ClientCredentialsAuthenticator) {
+		if tp != nil {
+			o.tracer = tp.Tracer(tracerName)
+		}
+	}
+}
+
+// refreshTriggers holds the span contexts of the requests waiting for a token, which are linked to the span of the
+// token request they wait for.
+type refreshTriggers struct {
+	mu      sync.Mutex
+	next    uint64
+	waiting map[uint64]*refreshTrigger
+}
+
+// refreshTrigger is a request waiting for a token.
+type refreshTrigger struct {
+	spanContext trace.SpanContext
+	// refreshed tells whether a token request was sent while the request waited
+	refreshed bool
+}
+
+func newRefreshTriggers() *refreshTriggers {
+	return &refreshTriggers{waiting: map[uint64]*refreshTrigger{}}
+}
+
+// tracedTokenSource is implemented by the token sources emitting spans for their token requests, each one linking
+// the requests waiting for a token of that token source only.
+type tracedTokenSource interface {
+	refreshTriggers() *refreshTriggers
+}
+
+// refreshTriggersOf returns the refresh triggers of ts, nil when ts doesn't emit spans.
+func refreshTriggersOf(ts oauth2.TokenSource) *refreshTriggers {
+	if traced, ok := ts.(tracedTokenSource); ok {
+		return traced.refreshTriggers()
+	}
+	return nil
+}
+
+// add registers a request waiting for a token, until the returned function is called, which reports whether a token
+// request was sent in the meantime.
+func (r *refreshTriggers) add(spanContext trace.SpanContext) func() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.next
+	r.next++
+	trigger := &refreshTrigger{spanContext: spanContext}
+	r.waiting[id] = trigger
+	return func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.waiting, id)
+		return trigger.refreshed
+	}
+}
+
+// links returns the links to the spans of the requests waiting for a token, for the span of the token request
+// about to be sent.
+func (r *refreshTriggers) links() []trace.Link {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	links := make([]trace.Link, 0, len(r.waiting))
+	for _, trigger := range r.waiting {
+		trigger.refreshed = true
+		links = append(links, trace.Link{SpanContext: trigger.spanContext})
+	}
+	return links
+}
+
+// releaseTriggerKey is the context key of the function removing a request from the refresh triggers.
+type releaseTriggerKey struct{}
+
+// triggerRoundTripper registers the span context of the requests as refresh triggers while their token is obtained.
+// The span of the requests is marked with whether a token request was sent while they waited, and how long they
+// waited for their token, so that the latency added by the extension shows in the traces of the exporters.
+type triggerRoundTripper struct {
+	base     http.RoundTripper
+	triggers *refreshTriggers
+	now      func() time.Time
+}
+
+func (t *triggerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	spanContext := trace.SpanContextFromContext(req.Context())
+	if !spanContext.IsValid() {
+		return t.base.RoundTrip(req)
+	}
+	start := t.now()
+	release := t.triggers.add(spanContext)
+	var once sync.Once
+	obtained := func() {
+		once.Do(func() {
+			refreshed := release()
+			trace.SpanFromContext(req.Context()).SetAttributes(
+				refreshOccurredAttrKey.Bool(refreshed),
+				waitMsAttrKey.Int64(t.now().Sub(start).Milliseconds()))
+		})
+	}
+	// released when the token isn't obtained too
+	defer obtained()
+	return t.base.RoundTrip(req.WithContext(context.WithValue(req.Context(), releaseTriggerKey{}, obtained)))
+}
+
+// tokenObtainedRoundTripper removes the requests it sends from the refresh triggers, their token being obtained.
+type tokenObtainedRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (t *tokenObtainedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if release, ok := req.Context().Value(releaseTriggerKey{}).(func()); ok {
+		release()
+	}
+	return t.base.RoundTrip(req)
+}
+
+// refreshSpanKey is the context key of the refreshSpan of a token source.
+type refreshSpanKey struct{}
+
+// refreshSpan holds the span context of the token request in progress of a token source.
+type refreshSpan struct {
+	mu          sync.Mutex
+	spanContext trace.SpanContext
+}
+
+func (r *refreshSpan) set(spanContext trace.SpanContext) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spanContext = spanContext
+}
+
+func (r *refreshSpan) get() trace.SpanContext {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spanContext
+}
+
+// traceContextRoundTripper propagates the span of the token request in progress to the authorization server, with
+// the W3C traceparent and tracestate headers and, if set, the trace ID in the correlation header, so that the logs of
+// the authorization server can be correlated with the traces of the collector.
+// See https://www.w3.org/TR/trace-context/
+type traceContextRoundTripper struct {
+	base              http.RoundTripper
+	propagate         bool
+	correlationHeader string
+}
+
+func (t *traceContextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	span, ok := req.Context().Value(refreshSpanKey{}).(*refreshSpan)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	spanContext := span.get()
+	if !spanContext.IsValid() {
+		return t.base.RoundTrip(req)
+	}
+	req2 := req.Clone(req.Context())
+	if t.propagate {
+		propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(req.Context(), spanContext),
+			propagation.HeaderCarrier(req2.Header))
+	}
+	if t.correlationHeader != "" {
+		req2.Header.Set(t.correlationHeader, spanContext.TraceID().String())
+	}
+	return t.base.RoundTrip(req2)
+}
+
+// tracingTokenSource emits a span for every token request, linked to the spans of the requests waiting for it.
+type tracingTokenSource struct {
+	ts       oauth2.TokenSource
+	tracer   trace.Tracer
+	triggers *refreshTriggers
+	span     *refreshSpan
+	id       config.ComponentID
+	profile  string
+}
+
+func (s *tracingTokenSource) Token() (*oauth2.Token, error) {
+	attributes := []attribute.KeyValue{extensionAttrKey.String(s.id.String())}
+	if s.profile != "" {
+		attributes = append(attributes, profileAttrKey.String(s.profile))
+	}
+	_, span := s.tracer.Start(context.Background(), refreshSpanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(s.triggers.links()...),
+		trace.WithAttributes(attributes...))
+	defer span.End()
+	s.span.set(span.SpanContext())
+	defer s.span.set(trace.SpanContext{})
+
+	token, err := s.ts.Token()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return token, err
+}
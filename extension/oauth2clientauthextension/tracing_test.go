@@ -0,0 +1,330 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshSpanLinkedToTriggeringRequest(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+	}, zap.NewNop(), withTracerProvider(tp))
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+	rt, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+
+	send := func() {
+		ctx, span := tp.Tracer("exporter").Start(context.Background(), "export")
+		defer span.End()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	send()
+	// the token is cached, no other token request is sent
+	send()
+
+	var refreshes, exports []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case refreshSpanName:
+			refreshes = append(refreshes, span)
+		case "export":
+			exports = append(exports, span)
+		}
+	}
+	require.Len(t, refreshes, 1)
+	require.Len(t, exports, 2)
+
+	refresh := refreshes[0]
+	require.Len(t, refresh.Links(), 1)
+	assert.Equal(t, exports[0].SpanContext(), refresh.Links()[0].SpanContext)
+	// the refresh isn't a child of the request, it may serve other requests
+	assert.False(t, refresh.Parent().IsValid())
+	assert.Contains(t, refresh.Attributes(), extensionAttrKey.String(oauth2Authenticator.id.String()))
+	assert.Equal(t, codes.Unset, refresh.Status().Code)
+	// nothing waits for a token anymore
+	assert.Empty(t, rt.(*triggerRoundTripper).triggers.links())
+}
+
+func TestRefreshSpanFailure(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     newFailingTokenServer(t).URL,
+		Profiles:     map[string]TokenProfile{"billing": {Audience: "billing"}},
+	}, zap.NewNop(), withTracerProvider(tp))
+	require.NoError(t, err)
+	rt, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+
+	ctx, span := tp.Tracer("exporter").Start(ContextWithProfile(context.Background(), "billing"), "export")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost", nil)
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.Error(t, err)
+	span.End()
+
+	require.Len(t, recorder.Ended(), 2)
+	refresh := recorder.Ended()[0]
+	assert.Equal(t, refreshSpanName, refresh.Name())
+	assert.Equal(t, codes.Error, refresh.Status().Code)
+	assert.Contains(t, refresh.Attributes(), attribute.String("profile", "billing"))
+	require.Len(t, refresh.Links(), 1)
+	assert.Equal(t, span.SpanContext(), refresh.Links()[0].SpanContext)
+	assert.Empty(t, refreshTriggersOf(rt.(*profileRoundTripper).sources.forProfile("billing")).links())
+}
+
+func TestRefreshSpanLinksRequestsOfItsTokenSourceOnly(t *testing.T) {
+	billingRequested := make(chan struct{})
+	releaseBilling := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("audience") == "billing" {
+			// the request of the billing profile waits for its token while the shipping one is obtained
+			close(billingRequested)
+			<-releaseBilling
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":  {Audience: "billing"},
+			"shipping": {Audience: "shipping"},
+		},
+	}, zap.NewNop(), withTracerProvider(tp))
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+	rt, err := oauth2Authenticator.RoundTripper(http.DefaultTransport)
+	require.NoError(t, err)
+
+	send := func(profile string) trace.SpanContext {
+		ctx, span := tp.Tracer("exporter").Start(ContextWithProfile(context.Background(), profile), "export")
+		defer span.End()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return span.SpanContext()
+	}
+	billingDone := make(chan trace.SpanContext)
+	go func() {
+		billingDone <- send("billing")
+	}()
+	<-billingRequested
+	shipping := send("shipping")
+	close(releaseBilling)
+	billing := <-billingDone
+
+	links := map[string][]trace.SpanContext{}
+	for _, span := range recorder.Ended() {
+		if span.Name() != refreshSpanName {
+			continue
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == profileAttrKey {
+				for _, link := range span.Links() {
+					links[attr.Value.AsString()] = append(links[attr.Value.AsString()], link.SpanContext)
+				}
+			}
+		}
+	}
+	assert.Equal(t, []trace.SpanContext{billing}, links["billing"])
+	assert.Equal(t, []trace.SpanContext{shipping}, links["shipping"])
+}
+
+func TestTraceContextPropagatedToTokenRequests(t *testing.T) {
+	tests := []struct {
+		name              string
+		propagate         bool
+		correlationHeader string
+	}{
+		{
+			name:      "traceparent",
+			propagate: true,
+		},
+		{
+			name:              "correlation_header",
+			correlationHeader: "X-Correlation-ID",
+		},
+		{
+			name: "not_propagated",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var headers http.Header
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers = r.Header.Clone()
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer tokenServer.Close()
+
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:              "testclientid",
+				ClientSecret:          "testsecret",
+				TokenURL:              tokenServer.URL,
+				PropagateTraceContext: test.propagate,
+				CorrelationHeader:     test.correlationHeader,
+			}, zap.NewNop(), withTracerProvider(tp))
+			require.NoError(t, err)
+
+			_, err = fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+
+			require.Len(t, recorder.Ended(), 1)
+			refresh := recorder.Ended()[0].SpanContext()
+			if test.propagate {
+				assert.Equal(t, fmt.Sprintf("00-%s-%s-01", refresh.TraceID(), refresh.SpanID()), headers.Get("traceparent"))
+			} else {
+				assert.Empty(t, headers.Get("traceparent"))
+			}
+			if test.correlationHeader != "" {
+				assert.Equal(t, refresh.TraceID().String(), headers.Get(test.correlationHeader))
+			}
+		})
+	}
+}
+
+func TestTraceContextNotPropagatedWithoutSpan(t *testing.T) {
+	var headers http.Header
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:              "testclientid",
+		ClientSecret:          "testsecret",
+		TokenURL:              tokenServer.URL,
+		PropagateTraceContext: true,
+		CorrelationHeader:     "X-Correlation-ID",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Empty(t, headers.Get("traceparent"))
+	assert.Empty(t, headers.Get("X-Correlation-ID"))
+}
+
+func TestRefreshMarkedOnRequestSpans(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     tokenServer.URL,
+	}, zap.NewNop(), withTracerProvider(tp))
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+	rt, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		ctx, span := tp.Tracer("exporter").Start(context.Background(), "export")
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost", nil)
+		require.NoError(t, err)
+		_, err = rt.RoundTrip(req)
+		require.NoError(t, err)
+		span.End()
+	}
+
+	var exports []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "export" {
+			exports = append(exports, span)
+		}
+	}
+	require.Len(t, exports, 2)
+	attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		values := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+		return values
+	}
+
+	// the first request waited for the token request
+	first := attributes(exports[0])
+	assert.True(t, first[refreshOccurredAttrKey].AsBool())
+	assert.GreaterOrEqual(t, first[waitMsAttrKey].AsInt64(), int64(20))
+	// the second one got the cached token
+	second := attributes(exports[1])
+	require.Contains(t, second, refreshOccurredAttrKey)
+	assert.False(t, second[refreshOccurredAttrKey].AsBool())
+	assert.Less(t, second[waitMsAttrKey].AsInt64(), int64(20))
+}
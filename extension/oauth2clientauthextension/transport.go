@@ -0,0 +1,337 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/oauth2"
+)
+
+// acceptJSONRoundTripper advertises that JSON token responses are expected, which some authorization
+// servers require. Token responses are JSON as per RFC 6749, so servers ignoring the header are unaffected.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type acceptJSONRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (a *acceptJSONRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept") != "" {
+		return a.base.RoundTrip(req)
+	}
+	req2 := req.Clone(req.Context())
+	req2.Header.Set("Accept", "application/json")
+	return a.base.RoundTrip(req2)
+}
+
+// responseReadError reports a failure to read the body of a token response, e.g. the connection being reset by
+// the authorization server midway. Like other connection failures, it is temporary.
+type responseReadError struct {
+	err error
+}
+
+var _ net.Error = (*responseReadError)(nil)
+
+func (e *responseReadError) Error() string {
+	return fmt.Sprintf("while reading token response: %v", e.err)
+}
+
+func (e *responseReadError) Unwrap() error {
+	return e.err
+}
+
+func (e *responseReadError) Timeout() bool {
+	var netErr net.Error
+	return errors.As(e.err, &netErr) && netErr.Timeout()
+}
+
+func (e *responseReadError) Temporary() bool {
+	return true
+}
+
+// temporaryNetworkError reports whether err is a network failure that may not happen again: a timeout, a failure
+// to connect to or to exchange with the authorization server, a temporary DNS failure or an interrupted response.
+// Other failures of the HTTP client, like an untrusted certificate or an unsupported scheme, are permanent.
+func temporaryNetworkError(err error) bool {
+	var readErr *responseReadError
+	if errors.As(err, &readErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write") {
+		return true
+	}
+	// the connection was closed before the response was received
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// readResponseBody reads the body of the token response, which is replaced by the bytes read, so that reading it
+// fails in RoundTrip, where the failure can be retried, rather than when the oauth2 package parses the response.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	// same limit as the oauth2 package
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, &responseReadError{err: err}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// errHTMLTokenResponse is reported for token responses that are HTML pages, typically the login page of the
+// authorization server served when token_url is not its token endpoint.
+var errHTMLTokenResponse = errors.New("the token endpoint returned an HTML page rather than a token response, check that token_url is the token endpoint of the authorization server and not a login page")
+
+// errorResponseRoundTripper turns successful token responses carrying an OAuth2 error into error responses,
+// for authorization servers answering errors with a 2xx status code, so that their error code is reported.
+// See https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+// Handshake failures caused by a TLS version mismatch are reported along with the versions offered by tlsCfg.
+// Token responses are read in full, so that failures to read them are reported as responseReadError.
+// Successful HTML responses are reported as errHTMLTokenResponse, rather than as a failure to parse them.
+type errorResponseRoundTripper struct {
+	base   http.RoundTripper
+	tlsCfg *tls.Config
+}
+
+func (e *errorResponseRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := e.base.RoundTrip(req)
+	if err != nil {
+		return nil, tlsVersionError(err, e.tlsCfg)
+	}
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, nil
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "text/html" {
+		return nil, errHTMLTokenResponse
+	}
+	if contentType != "application/json" {
+		return resp, nil
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if json.Unmarshal(body, &tokenResponse) == nil && tokenResponse.Error != "" && tokenResponse.AccessToken == "" {
+		// the status line is kept as is, so that the error message reports the actual response
+		resp.StatusCode = http.StatusBadRequest
+	}
+	return resp, nil
+}
+
+// hostRoundTripper sends the token requests with a Host header differing from the host of the token URL,
+// for authorization servers reached through a gateway routing requests by their Host header.
+type hostRoundTripper struct {
+	base http.RoundTripper
+	host string
+}
+
+func (h *hostRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req2 := req.Clone(req.Context())
+	req2.Host = h.host
+	return h.base.RoundTrip(req2)
+}
+
+// idempotencyKeyRoundTripper sends the token requests with a new random key, identifying the token request rather than
+// each of its attempts: it runs above retryRoundTripper, whose retries are sent with the same key.
+type idempotencyKeyRoundTripper struct {
+	base   http.RoundTripper
+	header string
+}
+
+func (i *idempotencyKeyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	req2 := req.Clone(req.Context())
+	req2.Header.Set(i.header, key)
+	return i.base.RoundTrip(req2)
+}
+
+// newIdempotencyKey returns a random (version 4) UUID.
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// compressionRoundTripper compresses the body of the token requests, for authorization servers accepting
+// compressed requests, which saves bandwidth with large assertions.
+type compressionRoundTripper struct {
+	base     http.RoundTripper
+	encoding string
+}
+
+func (c *compressionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return c.base.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := c.compress(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req2 := req.Clone(req.Context())
+	req2.Header.Set("Content-Encoding", c.encoding)
+	req2.ContentLength = int64(len(compressed))
+	req2.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	req2.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	return c.base.RoundTrip(req2)
+}
+
+func (c *compressionRoundTripper) compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if c.encoding == compressionGzip {
+		w = gzip.NewWriter(&buf)
+	} else {
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parameterOrderRoundTripper sends the form parameters of the token requests in the given order, the other
+// parameters following sorted by name like url.Values.Encode does. It runs above the round trippers signing or
+// compressing the body, so that they see the body as sent.
+type parameterOrderRoundTripper struct {
+	base  http.RoundTripper
+	order []string
+}
+
+func (p *parameterOrderRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Body == nil || req.Body == http.NoBody || mediaType != "application/x-www-form-urlencoded" {
+		return p.base.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	ordered := []byte(encodeInOrder(params, p.order))
+
+	req2 := req.Clone(req.Context())
+	req2.ContentLength = int64(len(ordered))
+	req2.Body = ioutil.NopCloser(bytes.NewReader(ordered))
+	req2.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(ordered)), nil
+	}
+	return p.base.RoundTrip(req2)
+}
+
+// encodeInOrder encodes params like url.Values.Encode, but with the parameters of order first, in that order.
+func encodeInOrder(params url.Values, order []string) string {
+	var buf strings.Builder
+	write := func(name string) {
+		for _, value := range params[name] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(url.QueryEscape(name))
+			buf.WriteByte('=')
+			buf.WriteString(url.QueryEscape(value))
+		}
+		delete(params, name)
+	}
+	for _, name := range order {
+		write(name)
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		write(name)
+	}
+	return buf.String()
+}
+
+// queryTokenRoundTripper authorizes requests with the access_token query parameter instead of the Authorization
+// header, for servers only accepting that form.
+// See https://datatracker.ietf.org/doc/html/rfc6750#section-2.3
+type queryTokenRoundTripper struct {
+	source oauth2.TokenSource
+	base   http.RoundTripper
+}
+
+func (q *queryTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := q.source.Token()
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+
+	req2 := req.Clone(req.Context())
+	query := req2.URL.Query()
+	// replacing the parameter keeps it from being duplicated when the request is sent again
+	query.Set("access_token", token.AccessToken)
+	req2.URL.RawQuery = query.Encode()
+	return q.base.RoundTrip(req2)
+}
@@ -0,0 +1,456 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenRequestAcceptsJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+}
+
+func TestAcceptJSONRoundTripperKeepsExplicitAccept(t *testing.T) {
+	var accept []string
+	rt := &acceptJSONRoundTripper{base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		accept = append(accept, req.Header.Get("Accept"))
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/v1/token", nil)
+	require.NoError(t, err)
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+	// the original request must not be modified
+	assert.Empty(t, req.Header.Get("Accept"))
+
+	req.Header.Set("Accept", "application/jwt")
+	_, err = rt.RoundTrip(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"application/json", "application/jwt"}, accept)
+}
+
+func TestTokenRequestHost(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:         "testclientid",
+		ClientSecret:     "testsecret",
+		TokenURL:         server.URL,
+		TokenRequestHost: "auth.internal.example.com",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	token, err := fetchToken(oauth2Authenticator)
+	require.NoError(t, err)
+	assert.Equal(t, "test-token", token.AccessToken)
+	assert.Equal(t, []string{"auth.internal.example.com"}, hosts)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var keys []string
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		// every other request fails, and is retried
+		if requests%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:             "testclientid",
+		ClientSecret:         "testsecret",
+		TokenURL:             server.URL,
+		IdempotencyKeyHeader: "Idempotency-Key",
+		Retry: RetrySettings{
+			MaxRetries:      1,
+			InitialInterval: time.Millisecond,
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	for i := 0; i < 2; i++ {
+		_, err = fetchToken(oauth2Authenticator)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, keys, 4)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, keys[0])
+	// the key is kept across the retries of a token request, and changes for the next one
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[2], keys[3])
+	assert.NotEqual(t, keys[0], keys[2])
+}
+
+func TestTokenRequestCompression(t *testing.T) {
+	for _, encoding := range []string{compressionGzip, compressionDeflate} {
+		t.Run(encoding, func(t *testing.T) {
+			var forms []url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, encoding, r.Header.Get("Content-Encoding"))
+				var body io.ReadCloser
+				var err error
+				if encoding == compressionGzip {
+					body, err = gzip.NewReader(r.Body)
+				} else {
+					body, err = zlib.NewReader(r.Body)
+				}
+				require.NoError(t, err)
+				decoded, err := ioutil.ReadAll(body)
+				require.NoError(t, err)
+				form, err := url.ParseQuery(string(decoded))
+				require.NoError(t, err)
+				forms = append(forms, form)
+
+				// the first request fails to check that retries resend the compressed body
+				if len(forms) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                "testclientid",
+				ClientSecret:            "testsecret",
+				TokenURL:                server.URL,
+				Scopes:                  []string{"resource.read"},
+				TokenRequestCompression: encoding,
+				Retry: RetrySettings{
+					MaxRetries:      1,
+					InitialInterval: time.Millisecond,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := fetchToken(oauth2Authenticator)
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+
+			require.Len(t, forms, 2)
+			for _, form := range forms {
+				assert.Equal(t, "client_credentials", form.Get("grant_type"))
+				assert.Equal(t, "resource.read", form.Get("scope"))
+			}
+		})
+	}
+}
+
+func TestTokenRequestParameterOrder(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        []string
+		expectedBody string
+	}{
+		{
+			name:         "not_set",
+			expectedBody: "client_id=testclientid&client_secret=testsecret&grant_type=client_credentials&scope=resource.read+resource.write",
+		},
+		{
+			name:         "configured_order",
+			order:        []string{"grant_type", "scope", "client_id"},
+			expectedBody: "grant_type=client_credentials&scope=resource.read+resource.write&client_id=testclientid&client_secret=testsecret",
+		},
+		{
+			name:         "unknown_parameters_ignored",
+			order:        []string{"client_assertion", "client_secret"},
+			expectedBody: "client_secret=testsecret&client_id=testclientid&grant_type=client_credentials&scope=resource.read+resource.write",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				bodies = append(bodies, string(body))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:                   "testclientid",
+				ClientSecret:               "testsecret",
+				TokenURL:                   server.URL,
+				Scopes:                     []string{"resource.read", "resource.write"},
+				TokenRequestParameterOrder: test.order,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInParams
+
+			// every request sends the same body
+			for i := 0; i < 3; i++ {
+				_, err = fetchToken(oauth2Authenticator)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, []string{test.expectedBody, test.expectedBody, test.expectedBody}, bodies)
+		})
+	}
+}
+
+func TestNonConformingTokenResponses(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		shouldError       bool
+		expectedCode      string
+		expectedTemporary bool
+	}{
+		{
+			name:   "created",
+			status: http.StatusCreated,
+			body:   `{"access_token":"test-token","token_type":"bearer","expires_in":3600}`,
+		},
+		{
+			name:         "ok_with_error",
+			status:       http.StatusOK,
+			body:         `{"error":"invalid_client","error_description":"unknown client"}`,
+			shouldError:  true,
+			expectedCode: "invalid_client",
+		},
+		{
+			name:              "ok_with_temporary_error",
+			status:            http.StatusOK,
+			body:              `{"error":"temporarily_unavailable"}`,
+			shouldError:       true,
+			expectedCode:      "temporarily_unavailable",
+			expectedTemporary: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := fetchToken(oauth2Authenticator)
+			if !test.shouldError {
+				require.NoError(t, err)
+				assert.Equal(t, "test-token", token.AccessToken)
+				return
+			}
+			var tokenErr *FailedToGetSecurityTokenError
+			require.True(t, errors.As(err, &tokenErr))
+			assert.Equal(t, test.expectedCode, tokenErr.ErrorCode())
+			assert.Equal(t, test.expectedTemporary, tokenErr.Temporary())
+			// the actual status is reported
+			assert.Contains(t, err.Error(), "200 OK")
+		})
+	}
+}
+
+func TestHTMLTokenResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<!DOCTYPE html><html><body><form action="/login">Sign in</form></body></html>`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL + "/login",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	_, err = fetchToken(oauth2Authenticator)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errHTMLTokenResponse))
+	assert.Contains(t, err.Error(), "token_url")
+}
+
+func TestTokenLocationQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"a+b/c=","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:      "testclientid",
+		ClientSecret:  "testsecret",
+		TokenURL:      server.URL,
+		TokenLocation: tokenLocationQuery,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var sent []*http.Request
+	roundTripper, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/v1/metrics?tenant=team%20a&access_token=stale", nil)
+	require.NoError(t, err)
+	// the same request is sent twice, as done on retries
+	for i := 0; i < 2; i++ {
+		_, err = roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, sent, 2)
+	for _, r := range sent {
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Equal(t, []string{"a+b/c="}, r.URL.Query()["access_token"])
+		assert.Equal(t, "team a", r.URL.Query().Get("tenant"))
+		assert.Contains(t, r.URL.RawQuery, "access_token=a%2Bb%2Fc%3D")
+	}
+	// the original request must not be modified
+	assert.Equal(t, "tenant=team%20a&access_token=stale", req.URL.RawQuery)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestConnectionResetWhileReadingResponse(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxRetries       int
+		expectedRequests int
+		shouldError      bool
+	}{
+		{
+			name:             "reported_with_context",
+			expectedRequests: 1,
+			shouldError:      true,
+		},
+		{
+			name:             "retried",
+			maxRetries:       1,
+			expectedRequests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests > 1 {
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+					return
+				}
+				// announce a longer body than sent, then reset the connection
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"access_token\":")
+				_ = buf.Flush()
+				_ = conn.(*net.TCPConn).SetLinger(0)
+				_ = conn.Close()
+			}))
+			defer server.Close()
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:     "testclientid",
+				ClientSecret: "testsecret",
+				TokenURL:     server.URL,
+				Retry: RetrySettings{
+					MaxRetries:      test.maxRetries,
+					InitialInterval: time.Millisecond,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			token, err := oauth2Authenticator.tokenSource("").Token()
+			assert.Equal(t, test.expectedRequests, requests)
+			if !test.shouldError {
+				require.NoError(t, err)
+				assert.Equal(t, "test-token", token.AccessToken)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "while reading token response")
+			var tokenErr *FailedToGetSecurityTokenError
+			require.True(t, errors.As(err, &tokenErr))
+			assert.True(t, tokenErr.Temporary())
+			var readErr *responseReadError
+			assert.True(t, errors.As(err, &readErr))
+		})
+	}
+}
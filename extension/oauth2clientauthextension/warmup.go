@@ -0,0 +1,118 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension // import "github.com/open-telemetry/opentelemetry-collector-contrib/extension/oauth2clientauthextension"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// warmUpParallelism bounds the number of concurrent token requests sent by warmUp.
+const warmUpParallelism = 4
+
+// warmUpWithin calls warmUp again on temporary failures, waiting between the attempts as for the retries of the token
+// requests, until the grace period elapsed or ctx is done. The last failure is returned.
+func (o *ClientCredentialsAuthenticator) warmUpWithin(ctx context.Context, grace time.Duration) error {
+	deadline := time.Now().Add(grace)
+	backoff := &retryBackoff{}
+	for {
+		err := o.warmUp()
+		if err == nil || !temporaryFailures(err) {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		wait := backoff.next(o.startRetry.InitialInterval, o.startRetry.MaxInterval)
+		if wait <= 0 || wait > remaining {
+			wait = remaining
+		}
+		o.logger.Warn("Failed to get security tokens on start, trying again", zap.Error(err),
+			zap.Duration("remaining_grace_period", remaining))
+		if sleep(ctx, wait) != nil {
+			return err
+		}
+	}
+}
+
+// temporaryFailures reports whether all the failures of warmUp are temporary.
+func temporaryFailures(err error) bool {
+	for _, err := range multierr.Errors(err) {
+		var tokenErr *FailedToGetSecurityTokenError
+		if !errors.As(err, &tokenErr) || !tokenErr.Temporary() {
+			return false
+		}
+	}
+	return true
+}
+
+// warmUp fetches a token for the extension configuration and for every profile, checking that all of them can be
+// obtained. The tokens are handed out to the exporters first using the extension, saving them a token request.
+func (o *ClientCredentialsAuthenticator) warmUp() error {
+	profiles := []string{""}
+	o.mu.RLock()
+	for profile := range o.profiles {
+		profiles = append(profiles, profile)
+	}
+	o.mu.RUnlock()
+	sort.Strings(profiles)
+
+	var mu sync.Mutex
+	var errs error
+	tokens := map[string]*oauth2.Token{}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, warmUpParallelism)
+	for _, profile := range profiles {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(profile string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			token, err := o.tokenSource(profile).Token()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if profile != "" {
+					err = fmt.Errorf("profile %q: %w", profile, err)
+				}
+				errs = multierr.Append(errs, err)
+				return
+			}
+			tokens[profile] = token
+		}(profile)
+	}
+	wg.Wait()
+
+	if errs != nil {
+		return errs
+	}
+	o.mu.Lock()
+	o.warmTokens = tokens
+	o.mu.Unlock()
+	return nil
+}
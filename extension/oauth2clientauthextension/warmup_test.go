@@ -0,0 +1,187 @@
+// Copyright The OpenTelemetry Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2clientauthextension
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestValidateOnStart(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"inventory": {Audience: "inventory"},
+		},
+		ValidateOnStart: true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+	assert.Equal(t, map[string]int{"": 1, "billing": 1, "inventory": 1}, requests)
+
+	// the tokens obtained at start are handed out to the exporters
+	var authorizations []string
+	roundTripper, err := oauth2Authenticator.RoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	require.NoError(t, err)
+	for _, profile := range []string{"", "billing", "inventory"} {
+		req, err := http.NewRequestWithContext(ContextWithProfile(context.Background(), profile),
+			http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		_, err = roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"Bearer |", "Bearer billing|", "Bearer inventory|"}, authorizations)
+	assert.Equal(t, map[string]int{"": 1, "billing": 1, "inventory": 1}, requests)
+}
+
+func TestValidateOnStartFailingProfiles(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+		Profiles: map[string]TokenProfile{
+			"billing":   {Audience: "billing"},
+			"shipping":  {Audience: "unknown-shipping"},
+			"warehouse": {Audience: "unknown-warehouse"},
+		},
+		ValidateOnStart: true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	// avoid the second request of the auth style auto detection
+	oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+	err = oauth2Authenticator.Start(context.Background(), nil)
+	require.Error(t, err)
+	// every token is requested and every failure is reported
+	assert.Equal(t, map[string]int{"": 1, "billing": 1, "unknown-shipping": 1, "unknown-warehouse": 1}, requests)
+	errs := multierr.Errors(err)
+	require.Len(t, errs, 2)
+	assert.Contains(t, err.Error(), `profile "shipping"`)
+	assert.Contains(t, err.Error(), `profile "warehouse"`)
+	for _, err := range errs {
+		var tokenErr *FailedToGetSecurityTokenError
+		require.True(t, errors.As(err, &tokenErr))
+		assert.Equal(t, "invalid_target", tokenErr.ErrorCode())
+	}
+}
+
+func TestValidateOnStartDisabled(t *testing.T) {
+	server, requests := newProfileTokenServer(t)
+
+	oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+		ClientID:     "testclientid",
+		ClientSecret: "testsecret",
+		TokenURL:     server.URL,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, oauth2Authenticator.Start(context.Background(), nil))
+	assert.Empty(t, requests)
+}
+
+func TestStartGracePeriod(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         []int
+		grace            time.Duration
+		expectedRequests int
+		shouldError      bool
+	}{
+		{
+			name:             "up_within_grace_period",
+			failures:         []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			grace:            5 * time.Second,
+			expectedRequests: 3,
+		},
+		{
+			name:             "no_grace_period",
+			failures:         []int{http.StatusServiceUnavailable},
+			expectedRequests: 1,
+			shouldError:      true,
+		},
+		{
+			name:             "down_for_longer",
+			failures:         []int{503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503, 503},
+			grace:            100 * time.Millisecond,
+			expectedRequests: 0,
+			shouldError:      true,
+		},
+		{
+			// permanent failures aren't tried again
+			name:             "rejected_credentials",
+			failures:         []int{http.StatusUnauthorized},
+			grace:            5 * time.Second,
+			expectedRequests: 1,
+			shouldError:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newFlakyTokenServer(t, test.failures...)
+
+			oauth2Authenticator, err := newClientCredentialsExtension(&Config{
+				ClientID:         "testclientid",
+				ClientSecret:     "testsecret",
+				TokenURL:         server.URL,
+				ValidateOnStart:  true,
+				StartGracePeriod: test.grace,
+				Retry: RetrySettings{
+					InitialInterval: 10 * time.Millisecond,
+					MaxInterval:     20 * time.Millisecond,
+				},
+			}, zap.NewNop())
+			require.NoError(t, err)
+			oauth2Authenticator.clientCredentials.AuthStyle = oauth2.AuthStyleInHeader
+
+			err = oauth2Authenticator.Start(context.Background(), nil)
+			if test.expectedRequests > 0 {
+				assert.Equal(t, test.expectedRequests, *requests)
+			} else {
+				// tried again until the grace period elapsed
+				assert.Greater(t, *requests, 2)
+				assert.Less(t, *requests, len(test.failures))
+			}
+			if test.shouldError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			token, err := oauth2Authenticator.tokenSource("").Token()
+			require.NoError(t, err)
+			assert.Equal(t, "test-token", token.AccessToken)
+			// the warm token is handed out
+			assert.Equal(t, test.expectedRequests, *requests)
+		})
+	}
+}